@@ -1,16 +1,20 @@
 module github.com/ondi/go-log
 
-go 1.20
+go 1.21
 
 require (
 	github.com/google/uuid v1.6.0
 	github.com/ondi/go-cache v0.0.0-20230425151132-e34113a7989a
 	github.com/ondi/go-circular v0.0.0-20240806163217-2b2a2afb1db4
 	github.com/ondi/go-queue v0.0.0-20241202144359-797dec4cff85
+	go.opentelemetry.io/otel/trace v1.24.0
+	golang.org/x/term v0.20.0
 	gotest.tools v2.2.0+incompatible
 )
 
 require (
 	github.com/google/go-cmp v0.6.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
+	go.opentelemetry.io/otel v1.24.0 // indirect
+	golang.org/x/sys v0.20.0 // indirect
 )
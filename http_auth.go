@@ -0,0 +1,62 @@
+//
+//
+//
+
+package log
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type Auth interface {
+	Authorization(ctx context.Context, ts time.Time) (string, error)
+	Invalidate()
+}
+
+type NoAuth_t struct{}
+
+func (NoAuth_t) Authorization(context.Context, time.Time) (string, error) {
+	return "", nil
+}
+
+func (NoAuth_t) Invalidate() {}
+
+type Auth_t struct {
+	mx       sync.Mutex
+	provider func(context.Context) (string, error)
+	ttl      time.Duration
+	value    string
+	expires  time.Time
+}
+
+// provider returns Authorization header value, i.e. "Bearer <token>" or "Splunk <token>".
+// value is cached for ttl or until endpoint answers 401
+func NewAuth(provider func(context.Context) (string, error), ttl time.Duration) (self *Auth_t) {
+	self = &Auth_t{
+		provider: provider,
+		ttl:      ttl,
+	}
+	return
+}
+
+func (self *Auth_t) Authorization(ctx context.Context, ts time.Time) (value string, err error) {
+	self.mx.Lock()
+	defer self.mx.Unlock()
+	if len(self.value) > 0 && ts.Before(self.expires) {
+		return self.value, nil
+	}
+	if value, err = self.provider(ctx); err != nil {
+		return
+	}
+	self.value = value
+	self.expires = ts.Add(self.ttl)
+	return
+}
+
+func (self *Auth_t) Invalidate() {
+	self.mx.Lock()
+	self.value = ""
+	self.mx.Unlock()
+}
@@ -0,0 +1,112 @@
+//
+//
+//
+
+package log
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// request body framing, messages are written by WriteOne with index i in batch.
+// encoder is shared by writers and must not keep state between calls
+type BatchEncoder interface {
+	BeginBatch(out io.Writer) error
+	WriteOne(out io.Writer, i int, m Msg_t) error
+	EndBatch(out io.Writer) error
+}
+
+type Lines_t struct {
+	message Formatter
+}
+
+// message per line, i.e. ndjson
+func NewLines(message Formatter) BatchEncoder {
+	return &Lines_t{message: message}
+}
+
+func (self *Lines_t) BeginBatch(out io.Writer) error {
+	return nil
+}
+
+func (self *Lines_t) WriteOne(out io.Writer, i int, m Msg_t) (err error) {
+	buf := GetBuffer()
+	defer PutBuffer(buf)
+	if _, err = self.message.FormatMessage(buf, m); err != nil {
+		return
+	}
+	if buf.Len() > 0 && buf.Bytes()[buf.Len()-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+	_, err = out.Write(buf.Bytes())
+	return
+}
+
+func (self *Lines_t) EndBatch(out io.Writer) error {
+	return nil
+}
+
+type Array_t struct {
+	message Formatter
+}
+
+// [message,message], trailing newline of message is removed
+func NewArray(message Formatter) BatchEncoder {
+	return &Array_t{message: message}
+}
+
+func (self *Array_t) BeginBatch(out io.Writer) (err error) {
+	_, err = io.WriteString(out, "[")
+	return
+}
+
+func (self *Array_t) WriteOne(out io.Writer, i int, m Msg_t) (err error) {
+	buf := GetBuffer()
+	defer PutBuffer(buf)
+	if i > 0 {
+		buf.WriteByte(',')
+	}
+	if _, err = self.message.FormatMessage(buf, m); err != nil {
+		return
+	}
+	if buf.Len() > 0 && buf.Bytes()[buf.Len()-1] == '\n' {
+		buf.Truncate(buf.Len() - 1)
+	}
+	_, err = out.Write(buf.Bytes())
+	return
+}
+
+func (self *Array_t) EndBatch(out io.Writer) (err error) {
+	_, err = io.WriteString(out, "]")
+	return
+}
+
+type LengthPrefix_t struct {
+	message Formatter
+}
+
+// 4 bytes big endian length then message, i.e. for protobuf or msgpack formatters
+func NewLengthPrefix(message Formatter) BatchEncoder {
+	return &LengthPrefix_t{message: message}
+}
+
+func (self *LengthPrefix_t) BeginBatch(out io.Writer) error {
+	return nil
+}
+
+func (self *LengthPrefix_t) WriteOne(out io.Writer, i int, m Msg_t) (err error) {
+	buf := GetBuffer()
+	defer PutBuffer(buf)
+	buf.Write([]byte{0, 0, 0, 0})
+	if _, err = self.message.FormatMessage(buf, m); err != nil {
+		return
+	}
+	binary.BigEndian.PutUint32(buf.Bytes(), uint32(buf.Len()-4))
+	_, err = out.Write(buf.Bytes())
+	return
+}
+
+func (self *LengthPrefix_t) EndBatch(out io.Writer) error {
+	return nil
+}
@@ -0,0 +1,105 @@
+//
+//
+//
+
+package log
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	CIRCUIT_CLOSED    = "closed"
+	CIRCUIT_OPEN      = "open"
+	CIRCUIT_HALF_OPEN = "half-open"
+)
+
+type Breaker interface {
+	Allow(ts time.Time) bool
+	Success()
+	Failure(ts time.Time)
+	State() string
+}
+
+type NoBreaker_t struct{}
+
+func (NoBreaker_t) Allow(time.Time) bool {
+	return true
+}
+
+func (NoBreaker_t) Success() {}
+
+func (NoBreaker_t) Failure(time.Time) {}
+
+func (NoBreaker_t) State() string {
+	return ""
+}
+
+type Breaker_t struct {
+	mx            sync.Mutex
+	state         string
+	opened        time.Time
+	open_duration time.Duration
+	threshold     int
+	failures      int
+	probe         bool
+}
+
+// open after threshold consecutive failures,
+// after open_duration single probe is allowed (half-open)
+func NewBreaker(threshold int, open_duration time.Duration) (self *Breaker_t) {
+	self = &Breaker_t{
+		state:         CIRCUIT_CLOSED,
+		threshold:     threshold,
+		open_duration: open_duration,
+	}
+	return
+}
+
+func (self *Breaker_t) Allow(ts time.Time) (ok bool) {
+	self.mx.Lock()
+	defer self.mx.Unlock()
+	switch self.state {
+	case CIRCUIT_OPEN:
+		if ts.Sub(self.opened) < self.open_duration {
+			return false
+		}
+		self.state = CIRCUIT_HALF_OPEN
+		self.probe = true
+		return true
+	case CIRCUIT_HALF_OPEN:
+		if self.probe {
+			return false
+		}
+		self.probe = true
+		return true
+	}
+	return true
+}
+
+func (self *Breaker_t) Success() {
+	self.mx.Lock()
+	self.state = CIRCUIT_CLOSED
+	self.failures = 0
+	self.probe = false
+	self.mx.Unlock()
+}
+
+func (self *Breaker_t) Failure(ts time.Time) {
+	self.mx.Lock()
+	self.failures++
+	if self.state == CIRCUIT_HALF_OPEN || self.failures >= self.threshold {
+		self.state = CIRCUIT_OPEN
+		self.opened = ts
+	}
+	self.probe = false
+	self.mx.Unlock()
+}
+
+func (self *Breaker_t) State() (res string) {
+	self.mx.Lock()
+	res = self.state
+	self.mx.Unlock()
+	return
+}
@@ -0,0 +1,12 @@
+//
+//
+//
+
+// logging wrapper in a subdirectory of the log package, used by tests as a caller outside the package
+package wrapper
+
+import "github.com/ondi/go-log"
+
+func Info(logger log.Logger, format string, args ...any) {
+	logger.Info(format, args...)
+}
@@ -0,0 +1,141 @@
+package log_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	stdlog "log"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+
+	"github.com/ondi/go-log"
+	"github.com/ondi/go-log/internal/wrapper"
+)
+
+// caller lookup skips frames by package path, so tests of callers run outside package log
+
+func stackHelper(logger log.Logger) {
+	logger.Error("error")
+}
+
+func TestStackTrace(t *testing.T) {
+	mem := log.NewMemory()
+	logger := log.New(log.NewLevelMap().AddOutputs("mem", mem, log.WhatLevel(0)), log.StackTrace(log.LOG_WARN, 2))
+	logger.Info("info")
+	stackHelper(logger)
+
+	msg := mem.Messages()
+	assert.Assert(t, len(msg) == 2, len(msg))
+	assert.Assert(t, len(msg[0].Fields) == 0, msg[0].Fields)
+	assert.Assert(t, len(msg[1].Fields) == 1 && msg[1].Fields[0].Key == "stack", msg[1].Fields)
+	lines := msg[1].Fields[0].Value.(log.Stack_t).Strings()
+	assert.Assert(t, len(lines) == 2, lines)
+	assert.Assert(t, strings.HasPrefix(lines[0], "go-log_test.stackHelper log_caller_test.go:"), lines)
+	assert.Assert(t, strings.HasPrefix(lines[1], "go-log_test.TestStackTrace log_caller_test.go:"), lines)
+
+	buf, err := json.Marshal(msg[1].Fields[0].Value)
+	assert.NilError(t, err)
+	assert.Assert(t, strings.HasPrefix(string(buf), `["go-log_test.stackHelper log_caller_test.go:`), string(buf))
+}
+
+func TestFileLineFunc(t *testing.T) {
+	var buf1, buf2 bytes.Buffer
+	logger := log.New(log.NewLevelMap().
+		AddOutputs("buf1", log.NewWriterStdany([]log.Formatter{log.NewFileLine()}, &buf1, 0), log.WhatLevel(0)).
+		AddOutputs("buf2", log.NewWriterStdany([]log.Formatter{log.NewFileLine(log.FileLineFunc())}, &buf2, 0), log.WhatLevel(0)),
+	)
+	stackHelper(logger)
+	assert.Assert(t, strings.HasPrefix(buf1.String(), "log_caller_test.go:"), buf1.String())
+	assert.Assert(t, strings.HasPrefix(buf2.String(), "go-log_test.stackHelper log_caller_test.go:"), buf2.String())
+	assert.Assert(t, strings.TrimPrefix(buf2.String(), "go-log_test.stackHelper ") == buf1.String(), buf2.String())
+}
+
+func TestFileLineDepth(t *testing.T) {
+	assert.Equal(t, string(log.AppendFileLineDepth(nil, "/src/auth/handler.go", 10, 0)), "handler.go:10")
+	assert.Equal(t, string(log.AppendFileLineDepth(nil, "/src/auth/handler.go", 10, 2)), "auth/handler.go:10")
+	assert.Equal(t, string(log.AppendFileLineDepth(nil, "/src/auth/handler.go", 10, 5)), "src/auth/handler.go:10")
+	assert.Equal(t, string(log.AppendFileLineDepth(nil, "handler.go", 10, 2)), "handler.go:10")
+
+	var buf bytes.Buffer
+	logger := log.New(log.NewLevelMap().AddOutputs("buf", log.NewWriterStdany([]log.Formatter{log.NewFileLineDepth(2)}, &buf, 0), log.WhatLevel(0)))
+	logger.Info("test")
+	_, file, _, _ := runtime.Caller(0)
+	dir := filepath.Base(filepath.Dir(file))
+	assert.Assert(t, strings.HasPrefix(buf.String(), dir+"/log_caller_test.go:"), buf.String())
+}
+
+func TestLogWriter(t *testing.T) {
+	m := log.NewLevelMap()
+
+	var buf bytes.Buffer
+	m.AddOutputs("buf", log.NewWriterStdany([]log.Formatter{log.NewFileLine()}, &buf, 0), log.WhatLevel(log.LOG_TRACE.LevelId))
+
+	w := log.New(m).Writer(log.LOG_WARN)
+	std := stdlog.New(w, "", 0)
+	std.Printf("line1\nline2")
+	_, _, line, _ := runtime.Caller(0)
+	io.WriteString(w, "partial")
+	io.WriteString(w, " line\r\nrest")
+	w.Close()
+
+	assert.Assert(t, buf.String() == fmt.Sprintf("log_caller_test.go:%[1]v WARN line1\nlog_caller_test.go:%[1]v WARN line2\nlog_caller_test.go:%[2]v WARN partial line\nlog_caller_test.go:%[3]v WARN rest\n", line-1, line+2, line+3), buf.String())
+}
+
+func facadeInfo(logger log.Logger, format string, args ...any) {
+	facadeLog(logger, format, args...)
+}
+
+func facadeLog(logger log.Logger, format string, args ...any) {
+	logger.Info(format, args...)
+}
+
+func TestCallerSkip(t *testing.T) {
+	var buf bytes.Buffer
+	m := log.NewLevelMap().AddOutputs("buf", log.NewWriterStdany([]log.Formatter{log.NewFileLine()}, &buf, 0), log.WhatLevel(0))
+
+	_, _, line, _ := runtime.Caller(0)
+	log.New(m).Info("direct")
+	facadeInfo(log.New(m, log.CallerSkip(2)), "facade")
+	facadeInfo(log.New(m).With("a", 1).WithCallerSkip(2), "facade")
+	assert.Assert(t, buf.String() == fmt.Sprintf("log_caller_test.go:%d INFO direct\nlog_caller_test.go:%d INFO facade\nlog_caller_test.go:%d INFO facade a=1\n", line+1, line+2, line+3), buf.String())
+}
+
+func TestNoCaller(t *testing.T) {
+	c1, c2 := log.NewMemory(), log.NewMemory()
+	logger := log.New(log.NewLevelMap().AddOutputs("c1", log.NoCaller(c1), log.WhatLevel(0)).AddOutputs("c2", c2, log.WhatLevel(3)))
+	logger.Info("info")
+	logger.Error("error")
+	msg1, msg2 := c1.Messages(), c2.Messages()
+	assert.Assert(t, len(msg1) == 2 && len(msg2) == 1)
+	assert.Assert(t, msg1[0].Info.File == "" && !msg1[0].Info.Ts.IsZero(), msg1[0].Info)
+	assert.Assert(t, strings.HasSuffix(msg1[1].Info.File, "log_caller_test.go"), msg1[1].Info)
+
+	c3 := log.NewMemory()
+	log.New(log.NewLevelMap().AddOutputs("c3", c3, log.WhatLevel(0)), log.DisableCaller()).With("a", 1).Info("info")
+	assert.Assert(t, c3.Messages()[0].Info.File == "", c3.Messages()[0].Info)
+}
+
+func TestCallerPackage(t *testing.T) {
+	mem := log.NewMemory()
+	logger := log.New(log.NewLevelMap().AddOutputs("mem", mem, log.WhatLevel(0)))
+
+	wrapper.Info(logger, "sub")
+	_, file, line, _ := runtime.Caller(0)
+	wrapper.Info(logger.WithCallerSkip(1), "skip")
+
+	msg := mem.Messages()
+	assert.Assert(t, len(msg) == 2, len(msg))
+	assert.Assert(t, strings.HasSuffix(msg[0].Info.File, "internal/wrapper/wrapper.go"), msg[0].Info)
+	assert.Assert(t, msg[1].Info.File == file && msg[1].Info.Line == line+1, msg[1].Info)
+
+	pc, _, _, _ := runtime.Caller(0)
+	assert.Assert(t, log.InPackage(runtime.Frame{Function: runtime.FuncForPC(reflect.ValueOf(log.AppendFileLine).Pointer()).Name(), File: "/other/dir/log_prefix.go"}))
+	assert.Assert(t, !log.InPackage(runtime.Frame{Function: runtime.FuncForPC(reflect.ValueOf(wrapper.Info).Pointer()).Name(), File: msg[0].Info.File}))
+	assert.Assert(t, !log.InPackage(runtime.Frame{Function: runtime.FuncForPC(pc).Name(), File: file}))
+}
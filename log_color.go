@@ -0,0 +1,42 @@
+//
+//
+//
+
+package log
+
+import (
+	"io"
+	"os"
+
+	"golang.org/x/term"
+)
+
+var LevelColors = map[int64]string{
+	4: "\x1b[31m", // ERROR red
+	3: "\x1b[33m", // WARN yellow
+	2: "\x1b[32m", // INFO green
+	1: "\x1b[90m", // DEBUG gray
+	0: "\x1b[90m", // TRACE gray
+}
+
+type ColorMessage_t struct{}
+
+// as NewTextMessage() with ANSI colored level, colors are disabled if out is not a terminal or NO_COLOR is set.
+// use with NewWriterStdany(prefix, os.Stderr, 0, WriteMessage(NewColor(os.Stderr)))
+func NewColor(out *os.File) Formatter {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok || !term.IsTerminal(int(out.Fd())) {
+		return NewTextMessage()
+	}
+	return &ColorMessage_t{}
+}
+
+func (self *ColorMessage_t) FormatMessage(out io.Writer, in ...Msg_t) (n int, err error) {
+	if len(in) == 0 {
+		return
+	}
+	color, ok := LevelColors[in[0].Info.LevelId]
+	if !ok {
+		return TextMessage(out, in[0].Info.LevelName, in[0])
+	}
+	return TextMessage(out, color+in[0].Info.LevelName+"\x1b[0m", in[0])
+}
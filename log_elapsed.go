@@ -0,0 +1,36 @@
+//
+//
+//
+
+package log
+
+import (
+	"strconv"
+	"time"
+)
+
+// duration arg rendered as "1.234s", "5m3s"
+type Elapsed_t time.Duration
+
+// time.Since uses monotonic clock reading of start, start must come from time.Now()
+func Elapsed(start time.Time) Elapsed_t {
+	return Elapsed_t(time.Since(start))
+}
+
+// minutes and above to seconds, seconds to milliseconds, milliseconds to microseconds
+func (self Elapsed_t) String() string {
+	d := time.Duration(self)
+	switch abs := d.Abs(); {
+	case abs >= time.Minute:
+		d = d.Round(time.Second)
+	case abs >= time.Second:
+		d = d.Round(time.Millisecond)
+	case abs >= time.Millisecond:
+		d = d.Round(time.Microsecond)
+	}
+	return d.String()
+}
+
+func (self Elapsed_t) MarshalJSON() ([]byte, error) {
+	return strconv.AppendQuote(nil, self.String()), nil
+}
@@ -0,0 +1,86 @@
+//
+// Elasticsearch _bulk api
+// {"index":{"_index":"logs-2024.01"}}
+// {"timestamp":"...","Level":"INFO","Message":"..."}
+//
+
+package log
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+)
+
+type elastic_error_t struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+type elastic_item_t struct {
+	Status int             `json:"status"`
+	Error  elastic_error_t `json:"error"`
+}
+
+// items with status >= 300 of 2xx _bulk response are counted, Reason is first error.
+// items are decoded one by one, response is not buffered
+func ElasticBulkResponse(resp *http.Response) error {
+	var res PartialError_t
+	dec := json.NewDecoder(resp.Body)
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+		switch t {
+		case "errors":
+			var has_errors bool
+			if err = dec.Decode(&has_errors); err != nil || has_errors == false {
+				return nil
+			}
+		case "items":
+			if t, err = dec.Token(); err != nil || t != json.Delim('[') {
+				return nil
+			}
+			for dec.More() {
+				var item map[string]elastic_item_t
+				if err = dec.Decode(&item); err != nil {
+					break
+				}
+				for _, v := range item {
+					if v.Status < 300 {
+						continue
+					}
+					if res.Failed++; len(res.Reason) == 0 {
+						res.Reason = v.Error.Type + ": " + v.Error.Reason
+					}
+				}
+			}
+			if res.Failed > 0 {
+				return &res
+			}
+			return nil
+		default:
+			var skip json.RawMessage
+			if err = dec.Decode(&skip); err != nil {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// index_format is time layout of index name, i.e. "logs-2006.01.02".
+// for data stream use empty index_format with message.Index = MessageIndexKB_t{Action: "create", Index: MessageIndexNameKB_t{Index: "logs"}}
+func NewElastic(queue_size int, writers int, address string, index_format string, message MessageKB_t, client Client, opts ...HttpOption) Queue {
+	if u, err := url.Parse(address); err == nil && (len(u.Path) == 0 || u.Path == "/") {
+		u.Path = "/_bulk"
+		address = u.String()
+	}
+	message.Index.Index.Format = index_format
+	opts = append([]HttpOption{ContentType("application/x-ndjson"), BulkWrite(256)}, opts...)
+	return NewHttpQueue(queue_size, writers, NewUrls(address), message, client, opts...)
+}
@@ -0,0 +1,46 @@
+//
+//
+//
+
+package log
+
+import (
+	"sync/atomic"
+)
+
+var __error_handler atomic.Pointer[func(error)]
+
+// internal logger errors: failed writes, failed outputs in SetupLogger, syslog fallback.
+// default handler writes to Stderr, nil restores default
+func SetErrorHandler(fn func(error)) {
+	if fn == nil {
+		__error_handler.Store(nil)
+	} else {
+		__error_handler.Store(&fn)
+	}
+}
+
+func HandleError(err error) {
+	if fn := __error_handler.Load(); fn != nil {
+		(*fn)(err)
+	} else {
+		DefaultErrorHandler(err)
+	}
+}
+
+func DefaultErrorHandler(err error) {
+	LogStderr("LOG ERROR: %v", err)
+}
+
+// per-output error handler, falls back to HandleError
+type ErrorHandler_t struct {
+	on_error func(error)
+}
+
+func (self *ErrorHandler_t) handle_error(err error) {
+	if self.on_error != nil {
+		self.on_error(err)
+	} else {
+		HandleError(err)
+	}
+}
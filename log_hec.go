@@ -0,0 +1,83 @@
+//
+// Splunk HTTP Event Collector
+// {"time":1426279439.123,"host":"...","source":"...","sourcetype":"...","event":"...","fields":{"level":"INFO"}}
+//
+
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"strings"
+)
+
+type MessageHEC_t struct {
+	Time       json.Number       `json:"time"`
+	Host       string            `json:"host,omitempty"`
+	Source     string            `json:"source,omitempty"`
+	SourceType string            `json:"sourcetype,omitempty"`
+	Index      string            `json:"index,omitempty"`
+	Event      any               `json:"event"`
+	Fields     map[string]string `json:"fields,omitempty"`
+	TextLimit  int               `json:"-"`
+}
+
+// event is message text, or Args as json when Format starts with "json".
+// level, location and message fields are indexed fields
+func (self MessageHEC_t) FormatMessage(out io.Writer, in ...Msg_t) (n int, err error) {
+	var buf strings.Builder
+
+	if self.TextLimit == 0 {
+		self.TextLimit = math.MaxInt
+	}
+
+	w := &LimitWriter_t{Buf: &buf, Limit: self.TextLimit}
+	enc := json.NewEncoder(out)
+
+	for _, v := range in {
+		buf.Reset()
+		w.Limit = self.TextLimit
+
+		if strings.HasPrefix(v.Format, "json") {
+			var data []byte
+			if data, err = json.Marshal(v.Args); err != nil {
+				return
+			}
+			self.Event = json.RawMessage(data)
+		} else {
+			fmt.Fprintf(w, v.Format, v.Args...)
+			self.Event = buf.String()
+		}
+
+		self.Time = json.Number(fmt.Sprintf("%d.%03d", v.Info.Ts.Unix(), v.Info.Ts.Nanosecond()/1e6))
+
+		buf.Reset()
+		for _, fm := range __get_fl_cx {
+			fm.FormatMessage(&buf, v)
+		}
+		self.Fields = map[string]string{"level": v.Info.LevelName, "location": buf.String()}
+		for _, field := range v.Fields {
+			self.Fields[field.Key] = fmt.Sprint(field.Value)
+		}
+
+		if err = enc.Encode(self); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// address is HEC base url, i.e. "https://splunk:8088", path defaults to /services/collector/event
+func NewHec(queue_size int, writers int, address string, token string, message MessageHEC_t, client Client, opts ...HttpOption) Queue {
+	if u, err := url.Parse(address); err == nil && (len(u.Path) == 0 || u.Path == "/") {
+		u.Path = "/services/collector/event"
+		address = u.String()
+	}
+	auth := "Splunk " + token
+	opts = append([]HttpOption{AuthProvider(func(context.Context) (string, error) { return auth, nil }, 0)}, opts...)
+	return NewHttpQueue(queue_size, writers, NewUrls(address), message, client, opts...)
+}
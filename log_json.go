@@ -0,0 +1,193 @@
+//
+// {"ts":"...","level":"INFO","file":"main.go","line":42,"msg":"..."}
+//
+
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type Json_t struct {
+	Layout   string
+	Location *time.Location
+	RawArgs  bool
+	Redact   []string
+}
+
+type JsonOption func(self *Json_t)
+
+func JsonLayout(layout string) JsonOption {
+	return func(self *Json_t) {
+		self.Layout = layout
+	}
+}
+
+// render ts in loc, default is location of message Ts
+func JsonLocation(loc *time.Location) JsonOption {
+	return func(self *Json_t) {
+		self.Location = loc
+	}
+}
+
+// keep Args as raw json array when Format starts with "json"
+func JsonRawArgs() JsonOption {
+	return func(self *Json_t) {
+		self.RawArgs = true
+	}
+}
+
+// values of keys in raw args and fields are replaced with "***", see RedactJson
+func JsonRedactKeys(keys ...string) JsonOption {
+	return func(self *Json_t) {
+		self.Redact = keys
+	}
+}
+
+func NewJson(opts ...JsonOption) Formatter {
+	self := &Json_t{
+		Layout: time.RFC3339Nano,
+	}
+	for _, opt := range opts {
+		opt(self)
+	}
+	return self
+}
+
+func (self *Json_t) FormatMessage(out io.Writer, in ...Msg_t) (n int, err error) {
+	buf := GetBuffer()
+	defer PutBuffer(buf)
+	for i, v := range in {
+		if i > 0 {
+			buf.WriteByte('\n')
+		}
+		if err = self.format(buf, v); err != nil {
+			return
+		}
+	}
+	return out.Write(buf.Bytes())
+}
+
+func (self *Json_t) format(buf *bytes.Buffer, m Msg_t) (err error) {
+	var b [64]byte
+	buf.WriteString(`{"ts":`)
+	JsonString(buf, string(InLocation(m.Info.Ts, self.Location).AppendFormat(b[:0], self.Layout)))
+	buf.WriteString(`,"level":`)
+	JsonString(buf, m.Info.LevelName)
+	buf.WriteString(`,"file":`)
+	JsonString(buf, filepath.Base(m.Info.File))
+	buf.WriteString(`,"line":`)
+	buf.Write(strconv.AppendInt(b[:0], int64(m.Info.Line), 10))
+	if self.RawArgs && strings.HasPrefix(m.Format, "json") {
+		var args []byte
+		if args, err = json.Marshal(m.Args); err != nil {
+			return
+		}
+		if len(self.Redact) > 0 {
+			if args, err = RedactJson(args, self.Redact); err != nil {
+				return
+			}
+		}
+		buf.WriteString(`,"args":`)
+		buf.Write(args)
+	} else {
+		buf.WriteString(`,"msg":`)
+		msg := GetBuffer()
+		fmt.Fprintf(msg, m.Format, m.Args...)
+		JsonBytes(buf, msg.Bytes())
+		PutBuffer(msg)
+	}
+	JsonFieldsRedact(buf, "", m.Fields, self.Redact, "ts", "level", "file", "line", "msg", "args")
+	buf.WriteString(`}`)
+	return
+}
+
+// ,"key":value for each field, keys colliding with reserved are prefixed with "fields."
+func JsonFields(buf *bytes.Buffer, prefix string, fields []Field_t, reserved ...string) {
+	JsonFieldsRedact(buf, prefix, fields, nil, reserved...)
+}
+
+// JsonFields with values of redact keys replaced, nested values included
+func JsonFieldsRedact(buf *bytes.Buffer, prefix string, fields []Field_t, redact []string, reserved ...string) {
+	for _, v := range fields {
+		buf.WriteByte(',')
+		key := prefix + v.Key
+		for _, r := range reserved {
+			if key == r {
+				key = prefix + "fields." + v.Key
+				break
+			}
+		}
+		JsonString(buf, key)
+		buf.WriteByte(':')
+		if redact_key(v.Key, redact) {
+			JsonString(buf, REDACTED)
+		} else if value, err := json.Marshal(v.Value); err == nil {
+			if len(redact) > 0 {
+				value, _ = RedactJson(value, redact)
+			}
+			buf.Write(value)
+		} else {
+			JsonString(buf, fmt.Sprint(v.Value))
+		}
+	}
+}
+
+// control characters and newlines are escaped, output is always single line.
+// invalid UTF-8 bytes are replaced with U+FFFD and U+2028, U+2029 are escaped as encoding/json does
+func JsonString(buf *bytes.Buffer, in string) {
+	json_escape(buf, in)
+}
+
+func JsonBytes(buf *bytes.Buffer, in []byte) {
+	json_escape(buf, in)
+}
+
+func json_escape[T string | []byte](buf *bytes.Buffer, in T) {
+	const hex = "0123456789abcdef"
+	buf.WriteByte('"')
+	for i := 0; i < len(in); i++ {
+		switch c := in[i]; c {
+		case '"', '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if c < 0x20 || c == 0x7f {
+				buf.WriteString(`\u00`)
+				buf.WriteByte(hex[c>>4])
+				buf.WriteByte(hex[c&0xf])
+			} else if c < utf8.RuneSelf {
+				buf.WriteByte(c)
+			} else {
+				var b [utf8.UTFMax]byte
+				r, size := utf8.DecodeRune(b[:copy(b[:], in[i:])])
+				switch {
+				case r == utf8.RuneError && size == 1:
+					buf.WriteRune(utf8.RuneError)
+				case r == '\u2028':
+					buf.WriteString(`\u2028`)
+				case r == '\u2029':
+					buf.WriteString(`\u2029`)
+				default:
+					buf.Write(b[:size])
+				}
+				i += size - 1
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
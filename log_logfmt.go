@@ -0,0 +1,96 @@
+//
+// ts=2006-01-02T15:04:05 level=INFO file=main.go:42 msg="..."
+//
+
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+type Logfmt_t struct {
+	Layout   string
+	Location *time.Location
+}
+
+type LogfmtOption func(self *Logfmt_t)
+
+// render ts in loc, default is location of message Ts
+func LogfmtLocation(loc *time.Location) LogfmtOption {
+	return func(self *Logfmt_t) {
+		self.Location = loc
+	}
+}
+
+func NewLogfmt(layout string, opts ...LogfmtOption) Formatter {
+	self := &Logfmt_t{Layout: layout}
+	for _, opt := range opts {
+		opt(self)
+	}
+	return self
+}
+
+func (self *Logfmt_t) FormatMessage(out io.Writer, in ...Msg_t) (n int, err error) {
+	var b [128]byte
+	var buf bytes.Buffer
+	for i, v := range in {
+		if i > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString("ts=")
+		LogfmtValue(&buf, string(InLocation(v.Info.Ts, self.Location).AppendFormat(b[:0], self.Layout)))
+		buf.WriteString(" level=")
+		LogfmtValue(&buf, v.Info.LevelName)
+		buf.WriteString(" file=")
+		LogfmtValue(&buf, string(AppendFileLine(b[:0], v.Info.File, v.Info.Line)))
+		buf.WriteString(" msg=")
+		LogfmtValue(&buf, fmt.Sprintf(v.Format, v.Args...))
+		for _, field := range v.Fields {
+			buf.WriteByte(' ')
+			switch field.Key {
+			case "ts", "level", "file", "msg":
+				buf.WriteString("fields.")
+			}
+			LogfmtKey(&buf, field.Key)
+			buf.WriteByte('=')
+			LogfmtValue(&buf, fmt.Sprint(field.Value))
+		}
+	}
+	return out.Write(buf.Bytes())
+}
+
+// keys are never quoted, spaces, quotes, '=', control characters
+// and invalid UTF-8 bytes are replaced with '_'
+func LogfmtKey(buf *bytes.Buffer, in string) {
+	if len(in) == 0 {
+		buf.WriteByte('_')
+		return
+	}
+	for _, v := range in {
+		if v <= ' ' || v == '=' || v == '"' || v == '\\' || v == utf8.RuneError || v == 0x7f {
+			buf.WriteByte('_')
+		} else {
+			buf.WriteRune(v)
+		}
+	}
+}
+
+// values with spaces, quotes, '=' or control characters are quoted
+// with JSON escaping, invalid UTF-8 bytes are replaced with U+FFFD
+func LogfmtValue(buf *bytes.Buffer, in string) {
+	if len(in) == 0 {
+		buf.WriteString(`""`)
+		return
+	}
+	for _, v := range in {
+		if v <= ' ' || v == '=' || v == '"' || v == '\\' || v == utf8.RuneError || v == 0x7f {
+			JsonString(buf, in)
+			return
+		}
+	}
+	buf.WriteString(in)
+}
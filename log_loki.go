@@ -0,0 +1,91 @@
+//
+// Loki push api
+// {"streams":[{"stream":{"job":"app","level":"INFO"},"values":[["1704164645123456789","main.go:1 message"]]}]}
+//
+
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"strconv"
+)
+
+type loki_stream_t struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+type MessageLoki_t struct {
+	// static labels, i.e. job, host
+	Labels map[string]string
+	// label name for level, default "level"
+	LevelLabel string
+	TextLimit  int
+}
+
+// batch is grouped into streams by level, line is "file:line message key=value"
+func (self MessageLoki_t) FormatMessage(out io.Writer, in ...Msg_t) (n int, err error) {
+	var buf, value bytes.Buffer
+	var streams []*loki_stream_t
+	var b [32]byte
+
+	if self.TextLimit == 0 {
+		self.TextLimit = math.MaxInt
+	}
+	if len(self.LevelLabel) == 0 {
+		self.LevelLabel = "level"
+	}
+
+	w := &LimitWriter_t{Buf: &buf, Limit: self.TextLimit}
+	levels := map[string]*loki_stream_t{}
+
+	for _, v := range in {
+		buf.Reset()
+		w.Limit = self.TextLimit
+		for _, fm := range __get_fl_cx {
+			fm.FormatMessage(w, v)
+		}
+		fmt.Fprintf(w, v.Format, v.Args...)
+		for _, field := range v.Fields {
+			io.WriteString(w, " ")
+			io.WriteString(w, field.Key)
+			io.WriteString(w, "=")
+			value.Reset()
+			LogfmtValue(&value, fmt.Sprint(field.Value))
+			w.Write(value.Bytes())
+		}
+
+		stream, ok := levels[v.Info.LevelName]
+		if !ok {
+			stream = &loki_stream_t{Stream: map[string]string{}}
+			for k, label := range self.Labels {
+				stream.Stream[k] = label
+			}
+			stream.Stream[self.LevelLabel] = v.Info.LevelName
+			levels[v.Info.LevelName] = stream
+			streams = append(streams, stream)
+		}
+		stream.Values = append(stream.Values, [2]string{string(strconv.AppendInt(b[:0], v.Info.Ts.UnixNano(), 10)), buf.String()})
+	}
+
+	var body []byte
+	if body, err = json.Marshal(map[string][]*loki_stream_t{"streams": streams}); err != nil {
+		return
+	}
+	return out.Write(body)
+}
+
+// address is Loki base url, i.e. "http://loki:3100", path defaults to /loki/api/v1/push
+func NewLoki(queue_size int, writers int, address string, message MessageLoki_t, client Client, opts ...HttpOption) Queue {
+	if u, err := url.Parse(address); err == nil && (len(u.Path) == 0 || u.Path == "/") {
+		u.Path = "/loki/api/v1/push"
+		address = u.String()
+	}
+	opts = append([]HttpOption{ContentType("application/json"), BulkWrite(256)}, opts...)
+	return NewHttpQueue(queue_size, writers, NewUrls(address), message, client, opts...)
+}
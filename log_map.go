@@ -4,6 +4,33 @@
 
 package log
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	ERROR_OUTPUT_NOT_FOUND = errors.New("OUTPUT NOT FOUND")
+	ERROR_OUTPUT_EXISTS    = errors.New("OUTPUT EXISTS")
+)
+
+type CloseError_t struct {
+	Outputs []string
+	Err     error
+}
+
+func (self *CloseError_t) Error() string {
+	return fmt.Sprintf("CLOSE: %v: %v", self.Err, strings.Join(self.Outputs, ", "))
+}
+
+func (self *CloseError_t) Unwrap() error {
+	return self.Err
+}
+
 type Queue_map_t map[string]Queue
 
 type Level_map_t map[int64]Queue_map_t
@@ -36,11 +63,25 @@ func (self Level_map_t) DelOutput(level_id int64, writer_name string) (writer Qu
 	return
 }
 
+// writer_name already registered at a level is not replaced, first wins
 func (self Level_map_t) AddOutputs(writer_name string, queue Queue, levels []Info_t) Level_map_t {
+	self.add_outputs(writer_name, queue, levels)
+	return self
+}
+
+func (self Level_map_t) add_outputs(writer_name string, queue Queue, levels []Info_t) {
 	for _, v := range levels {
 		self.AddOutput(v.LevelId, writer_name, queue)
 	}
-	return self
+}
+
+// returns ERROR_OUTPUT_EXISTS and adds nothing if writer_name is registered at any level
+func (self Level_map_t) AddOutputsUnique(writer_name string, queue Queue, levels []Info_t) (err error) {
+	if _, ok := self.GetOutput(writer_name); ok {
+		return ERROR_OUTPUT_EXISTS
+	}
+	self.add_outputs(writer_name, queue, levels)
+	return
 }
 
 func (self Level_map_t) DelOutputs(writer_name string, levels []Info_t) Level_map_t {
@@ -53,6 +94,104 @@ func (self Level_map_t) DelOutputs(writer_name string, levels []Info_t) Level_ma
 	return self
 }
 
+// writers of lowest level above level_id with writers
+func (self Level_map_t) Above(level_id int64) (res Queue_map_t) {
+	found := level_id
+	for k, v := range self {
+		if k > level_id && len(v) > 0 && (found == level_id || k < found) {
+			found, res = k, v
+		}
+	}
+	return
+}
+
+// writers of level_id, writers of lowest level above it if level_id has none, see ContextWithLevel
+func (self Level_map_t) Elevated(level_id int64) (res Queue_map_t) {
+	if res = self[level_id]; len(res) == 0 {
+		res = self.Above(level_id)
+	}
+	return
+}
+
+func (self Level_map_t) GetOutput(writer_name string) (writer Queue, ok bool) {
+	for _, writers := range self {
+		if writer, ok = writers[writer_name]; ok {
+			return
+		}
+	}
+	return
+}
+
+// re-point writer_name to levels, other writers are not changed
+func (self Level_map_t) SetOutputLevel(writer_name string, levels []Info_t) (err error) {
+	writer, ok := self.GetOutput(writer_name)
+	if !ok {
+		return ERROR_OUTPUT_NOT_FOUND
+	}
+	for level_id, writers := range self {
+		delete(writers, writer_name)
+		if len(writers) == 0 {
+			delete(self, level_id)
+		}
+	}
+	self.add_outputs(writer_name, writer, levels)
+	return
+}
+
+// detach writer_name from all levels, writer is not closed
+func (self Level_map_t) RemoveOutput(writer_name string) (writer Queue, ok bool) {
+	var temp Queue
+	for level_id, writers := range self {
+		if temp, ok = writers[writer_name]; ok {
+			writer = temp
+			delete(writers, writer_name)
+			if len(writers) == 0 {
+				delete(self, level_id)
+			}
+		}
+	}
+	return writer, writer != nil
+}
+
+// unique writers sorted by name
+func (self Level_map_t) Outputs() (names []string, writers Queue_map_t) {
+	writers = Queue_map_t{}
+	for _, level := range self {
+		for writer_name, writer := range level {
+			if _, ok := writers[writer_name]; !ok {
+				writers[writer_name] = writer
+				names = append(names, writer_name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return
+}
+
+type OutputInfo_t struct {
+	Name   string
+	Levels []Info_t
+	Size   QueueSize_t
+}
+
+// unique writers sorted by name with levels attached, highest first
+func (self Level_map_t) OutputInfo() (res []OutputInfo_t) {
+	names, writers := self.Outputs()
+	for _, name := range names {
+		info := OutputInfo_t{Name: name, Size: writers[name].Size()}
+		for level_id, level := range self {
+			if _, ok := level[name]; ok {
+				temp := level_by_id(level_id)
+				temp.LevelName = LevelName(temp)
+				info.Levels = append(info.Levels, temp)
+			}
+		}
+		sort.Slice(info.Levels, func(i, j int) bool { return info.Levels[i].LevelId > info.Levels[j].LevelId })
+		res = append(res, info)
+	}
+	return
+}
+
 func (self Level_map_t) Copy(out Level_map_t) Level_map_t {
 	var ok bool
 	var temp Queue_map_t
@@ -68,14 +207,63 @@ func (self Level_map_t) Copy(out Level_map_t) Level_map_t {
 	return out
 }
 
-func (self Level_map_t) Close() {
-	writers := Queue_map_t{}
-	for _, level := range self {
-		for writer_name, writer := range level {
-			writers[writer_name] = writer
+// reopen each writer once, returns first error
+func (self Level_map_t) Reopen() (err error) {
+	_, writers := self.Outputs()
+	for _, v := range writers {
+		if err2 := Reopen(v); err == nil {
+			err = err2
+		}
+	}
+	return
+}
+
+// flush each writer once, returns first error
+func (self Level_map_t) Flush() (err error) {
+	_, writers := self.Outputs()
+	for _, v := range writers {
+		if err2 := Flush(v); err == nil {
+			err = err2
 		}
 	}
+	return
+}
+
+// close each writer once, returns first error
+func (self Level_map_t) Close() (err error) {
+	_, writers := self.Outputs()
 	for _, v := range writers {
-		v.Close()
+		if err2 := v.Close(); err == nil {
+			err = err2
+		}
+	}
+	return
+}
+
+// close writers in parallel, returns *CloseError_t with writers not closed before ctx is done
+func (self Level_map_t) CloseContext(ctx context.Context) error {
+	var wg sync.WaitGroup
+	names, writers := self.Outputs()
+	errs := make([]error, len(names))
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, q Queue) {
+			defer wg.Done()
+			errs[i] = CloseQueue(ctx, q)
+		}(i, writers[name])
+	}
+	wg.Wait()
+	var res CloseError_t
+	for i, err := range errs {
+		if err != nil {
+			res.Outputs = append(res.Outputs, names[i])
+			if res.Err == nil {
+				res.Err = err
+			}
+		}
+	}
+	if res.Err != nil {
+		return &res
 	}
+	return nil
 }
@@ -0,0 +1,35 @@
+//
+//
+//
+
+package log
+
+import (
+	"io"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type Otel_t struct{}
+
+// "trace_id=... span_id=... " from active span of Msg_t.Ctx
+func NewOtel() Formatter {
+	return &Otel_t{}
+}
+
+func (self *Otel_t) FormatMessage(out io.Writer, in ...Msg_t) (n int, err error) {
+	if len(in) == 0 || in[0].Ctx == nil {
+		return
+	}
+	sc := trace.SpanContextFromContext(in[0].Ctx)
+	if !sc.IsValid() {
+		return
+	}
+	var b [80]byte
+	res := append(b[:0], "trace_id="...)
+	res = append(res, sc.TraceID().String()...)
+	res = append(res, " span_id="...)
+	res = append(res, sc.SpanID().String()...)
+	res = append(res, ' ')
+	return out.Write(res)
+}
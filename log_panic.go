@@ -0,0 +1,49 @@
+//
+//
+//
+
+package log
+
+import (
+	"context"
+	"os"
+	"runtime/debug"
+)
+
+var (
+	// exit code of Fatal and FatalCtx
+	FatalExitCode = 1
+	// replaced in tests to intercept exit
+	FatalExit = os.Exit
+)
+
+// log recovered value with stack at ERROR level and flush outputs, nil is ignored.
+// defer func() { log.LogPanic(recover()) }() recovers without re-panic
+func LogPanic(recovered any) {
+	if recovered == nil {
+		return
+	}
+	logger := GetLogger()
+	logger.With("stack", string(debug.Stack())).Error("panic: %v", recovered)
+	logger.Flush()
+}
+
+// defer log.Recover() logs panic with LogPanic, then panics again with the same value
+func Recover() {
+	if r := recover(); r != nil {
+		LogPanic(r)
+		panic(r)
+	}
+}
+
+// log at ERROR level, close outputs of logger set by SetLogger, then FatalExit(FatalExitCode)
+func Fatal(format string, args ...any) {
+	FatalCtx(context.Background(), format, args...)
+}
+
+func FatalCtx(ctx context.Context, format string, args ...any) {
+	logger := GetLogger()
+	logger.Log(ctx, LOG_ERROR, format, args...)
+	logger.Close()
+	FatalExit(FatalExitCode)
+}
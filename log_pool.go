@@ -0,0 +1,37 @@
+//
+//
+//
+
+package log
+
+import (
+	"bytes"
+	"sync"
+)
+
+var __buffers = sync.Pool{
+	New: func() any {
+		return new(bytes.Buffer)
+	},
+}
+
+// program counters for PcFileLineSkip, runtime.Frames keeps a reference so stack array would escape
+var __pcs = sync.Pool{
+	New: func() any {
+		return new([64]uintptr)
+	},
+}
+
+// scratch buffer for formatters, content must not be referenced after PutBuffer
+func GetBuffer() (res *bytes.Buffer) {
+	res = __buffers.Get().(*bytes.Buffer)
+	res.Reset()
+	return
+}
+
+// large buffers are left to GC
+func PutBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= 64*1024 {
+		__buffers.Put(buf)
+	}
+}
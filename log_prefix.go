@@ -5,33 +5,162 @@
 package log
 
 import (
+	"bytes"
 	"io"
+	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
 	"strconv"
+	"strings"
+	"time"
 )
 
 func FileLine(skip int, limit int) (path string, line int) {
-	var next_line int
-	var next_path string
-	_, path, line, ok := runtime.Caller(skip)
-	for i := skip + 1; i < limit; i++ {
-		if _, next_path, next_line, ok = runtime.Caller(i); !ok {
-			return
+	return FileLineSkip(skip+1, limit, 0)
+}
+
+// first frame outside this package after frame skip, then caller_skip more frames.
+func FileLineSkip(skip int, limit int, caller_skip int) (path string, line int) {
+	_, path, line = PcFileLineSkip(skip+1, limit, caller_skip)
+	return
+}
+
+// as FileLineSkip with program counter of frame
+func PcFileLineSkip(skip int, limit int, caller_skip int) (pc uintptr, path string, line int) {
+	pcs := __pcs.Get().(*[64]uintptr)
+	defer __pcs.Put(pcs)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(skip+2, pcs[:min(limit-skip, len(pcs))])])
+	first, more := frames.Next()
+	for more {
+		var frame runtime.Frame
+		if frame, more = frames.Next(); InPackage(frame) {
+			continue
+		}
+		if caller_skip == 0 {
+			return frame.PC, frame.File, frame.Line
+		}
+		for ; caller_skip > 0 && more; caller_skip-- {
+			frame, more = frames.Next()
 		}
-		if filepath.Dir(path) != filepath.Dir(next_path) {
-			return next_path, next_line
+		if caller_skip > 0 {
+			return 0, "", 0
 		}
+		return frame.PC, frame.File, frame.Line
+	}
+	return first.PC, first.File, first.Line
+}
+
+// "github.com/ondi/go-log." detected from function name, so frames are matched regardless of file location
+var __package = func() string {
+	name := runtime.FuncForPC(reflect.ValueOf(AppendFileLine).Pointer()).Name()
+	return name[:strings.LastIndexByte(name, '.')+1]
+}()
+
+// frame of this package, frames of sub-packages are not
+func InPackage(frame runtime.Frame) bool {
+	name, ok := strings.CutPrefix(frame.Function, __package)
+	if !ok {
+		return false
+	}
+	if i := strings.IndexByte(name, '['); i >= 0 {
+		name = name[:i]
+	}
+	return !strings.Contains(name, "/")
+}
+
+// layout and location of timestamps, same value may be used by NewDt, MessageKB_t and MessageTG_t
+type Timestamp_t struct {
+	Layout   string
+	Location *time.Location
+}
+
+// nil loc keeps location of message Ts, i.e. Timestamp(DT_MILLIS, time.UTC)
+func Timestamp(layout string, loc *time.Location) Timestamp_t {
+	return Timestamp_t{Layout: layout, Location: loc}
+}
+
+func (self Timestamp_t) AppendFormat(b []byte, ts time.Time) []byte {
+	return InLocation(ts, self.Location).AppendFormat(b, self.Layout)
+}
+
+// "ts " of first message, nothing for empty Layout
+func (self Timestamp_t) FormatMessage(out io.Writer, in ...Msg_t) (n int, err error) {
+	if len(in) == 0 || len(self.Layout) == 0 {
+		return
+	}
+	var b [64]byte
+	if n, err = out.Write(self.AppendFormat(b[:0], in[0].Info.Ts)); n > 0 {
+		io.WriteString(out, " ")
 	}
 	return
 }
 
 type DT_t struct {
-	Layout string
+	Timestamp_t
+}
+
+type DtOption func(self *DT_t)
+
+// layout and location of ts, see Timestamp()
+func DtTimestamp(ts Timestamp_t) DtOption {
+	return func(self *DT_t) {
+		self.Timestamp_t = ts
+	}
+}
+
+// render timestamps in loc, default is location of message Ts
+func DtLocation(loc *time.Location) DtOption {
+	return func(self *DT_t) {
+		self.Location = loc
+	}
+}
+
+func DtUTC() DtOption {
+	return DtLocation(time.UTC)
+}
+
+// ts in loc, nil loc keeps ts
+func InLocation(ts time.Time, loc *time.Location) time.Time {
+	if loc == nil {
+		return ts
+	}
+	return ts.In(loc)
+}
+
+const (
+	DT_SECONDS = "2006-01-02 15:04:05"
+	DT_MILLIS  = "2006-01-02 15:04:05.000"
+	DT_MICROS  = "2006-01-02 15:04:05.000000"
+	DT_NANOS   = "2006-01-02 15:04:05.000000000"
+)
+
+func NewDt(layout string, opts ...DtOption) Formatter {
+	self := &DT_t{Timestamp_t: Timestamp_t{Layout: layout}}
+	for _, opt := range opts {
+		opt(self)
+	}
+	return self
+}
+
+func DtSeconds(opts ...DtOption) Formatter {
+	return NewDt(DT_SECONDS, opts...)
+}
+
+func DtMillis(opts ...DtOption) Formatter {
+	return NewDt(DT_MILLIS, opts...)
+}
+
+func DtMicros(opts ...DtOption) Formatter {
+	return NewDt(DT_MICROS, opts...)
+}
+
+func DtNanos(opts ...DtOption) Formatter {
+	return NewDt(DT_NANOS, opts...)
 }
 
-func NewDt(layout string) Formatter {
-	return &DT_t{Layout: layout}
+func DtRFC3339Nano(opts ...DtOption) Formatter {
+	return NewDt(time.RFC3339Nano, opts...)
 }
 
 func (self *DT_t) FormatMessage(out io.Writer, in ...Msg_t) (n int, err error) {
@@ -39,30 +168,83 @@ func (self *DT_t) FormatMessage(out io.Writer, in ...Msg_t) (n int, err error) {
 		return
 	}
 	var b [64]byte
-	if n, err = out.Write(in[0].Info.Ts.AppendFormat(b[:0], self.Layout)); n > 0 {
+	if n, err = out.Write(self.AppendFormat(b[:0], in[0].Info.Ts)); n > 0 {
 		io.WriteString(out, " ")
 	}
 	return
 }
 
-type FileLine_t struct{}
+type FileLine_t struct {
+	function bool
+	depth    int
+}
+
+type FileLineOption func(self *FileLine_t)
 
-func NewFileLine() Formatter {
-	return &FileLine_t{}
+// render function name before file, i.e. "pkg.Func file.go:42"
+func FileLineFunc() FileLineOption {
+	return func(self *FileLine_t) {
+		self.function = true
+	}
+}
+
+// keep depth trailing path segments, i.e. "auth/handler.go:10" for 2, default is 1
+func FileLineDepth(depth int) FileLineOption {
+	return func(self *FileLine_t) {
+		self.depth = depth
+	}
+}
+
+func NewFileLineDepth(depth int, opts ...FileLineOption) Formatter {
+	return NewFileLine(append([]FileLineOption{FileLineDepth(depth)}, opts...)...)
+}
+
+func NewFileLine(opts ...FileLineOption) Formatter {
+	self := &FileLine_t{}
+	for _, opt := range opts {
+		opt(self)
+	}
+	return self
 }
 
 func (self *FileLine_t) FormatMessage(out io.Writer, in ...Msg_t) (n int, err error) {
 	if len(in) == 0 {
 		return
 	}
-	if n, err = io.WriteString(out, filepath.Base(in[0].Info.File)); n > 0 {
-		io.WriteString(out, ":")
-		io.WriteString(out, strconv.FormatInt(int64(in[0].Info.Line), 10))
+	var b [256]byte
+	buf := b[:0]
+	if self.function && in[0].Info.Pc != 0 {
+		if fn := runtime.FuncForPC(in[0].Info.Pc); fn != nil {
+			buf = append(buf, FuncName(fn.Name())...)
+			buf = append(buf, ' ')
+		}
+	}
+	if n, err = out.Write(AppendFileLineDepth(buf, in[0].Info.File, in[0].Info.Line, self.depth)); n > 0 {
 		io.WriteString(out, " ")
 	}
 	return
 }
 
+func AppendFileLine(b []byte, file string, line int) []byte {
+	b = append(b, filepath.Base(file)...)
+	b = append(b, ':')
+	return strconv.AppendInt(b, int64(line), 10)
+}
+
+// as AppendFileLine with depth trailing segments of slash separated file
+func AppendFileLineDepth(b []byte, file string, line int, depth int) []byte {
+	if depth <= 1 {
+		return AppendFileLine(b, file, line)
+	}
+	i := len(file)
+	for ; depth > 0 && i > 0; depth-- {
+		i = strings.LastIndexByte(file[:i], '/')
+	}
+	b = append(b, file[i+1:]...)
+	b = append(b, ':')
+	return strconv.AppendInt(b, int64(line), 10)
+}
+
 type GetLogContext_t struct{}
 
 func NewGetLogContext() Formatter {
@@ -80,3 +262,72 @@ func (self *GetLogContext_t) FormatMessage(out io.Writer, in ...Msg_t) (n int, e
 	}
 	return
 }
+
+type Hostname_t struct {
+	hostname string
+}
+
+// os.Hostname() at construction
+func NewHostname() Formatter {
+	hostname, _ := os.Hostname()
+	return &Hostname_t{hostname: hostname}
+}
+
+func (self *Hostname_t) FormatMessage(out io.Writer, in ...Msg_t) (n int, err error) {
+	if len(self.hostname) == 0 {
+		return
+	}
+	if n, err = io.WriteString(out, self.hostname); n > 0 {
+		io.WriteString(out, " ")
+	}
+	return
+}
+
+type Pid_t struct {
+	pid []byte
+}
+
+func NewPid() Formatter {
+	return &Pid_t{pid: strconv.AppendInt(nil, int64(os.Getpid()), 10)}
+}
+
+func (self *Pid_t) FormatMessage(out io.Writer, in ...Msg_t) (n int, err error) {
+	if n, err = out.Write(self.pid); n > 0 {
+		io.WriteString(out, " ")
+	}
+	return
+}
+
+type GoroutineId_t struct{}
+
+// debugging only: parses runtime.Stack on every message.
+// prints Info_t.Gid captured by Log for outputs wrapped with WithGoroutineId,
+// otherwise goroutine of formatter, which is queue worker for queued outputs
+func NewGoroutineId() Formatter {
+	return GoroutineId_t{}
+}
+
+func GoroutineId() (res int64) {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		res, _ = strconv.ParseInt(string(b[:i]), 10, 64)
+	}
+	return
+}
+
+func (GoroutineId_t) FormatMessage(out io.Writer, in ...Msg_t) (n int, err error) {
+	var buf [32]byte
+	b := append(buf[:0], "gid="...)
+	var gid int64
+	if len(in) > 0 {
+		gid = in[0].Info.Gid
+	}
+	if gid == 0 {
+		gid = GoroutineId()
+	}
+	b = strconv.AppendInt(b, gid, 10)
+	b = append(b, ' ')
+	return out.Write(b)
+}
@@ -5,47 +5,191 @@
 package log
 
 import (
+	"context"
 	"errors"
 	"sync"
+	"time"
 
 	"github.com/ondi/go-queue"
 )
 
 var ERROR_OVERFLOW = errors.New("QUEUE OVERFLOW")
 
+type Overflow_t int
+
+const (
+	// message is dropped with ERROR_OVERFLOW
+	DropNewest Overflow_t = iota
+	// LogWrite waits for space up to timeout, zero timeout waits forever.
+	// message is dropped with ctx error if Msg_t.Ctx is done before space is available.
+	// deadlock if queue is written from its own writer, i.e. by error handler
+	Block
+	// oldest queued message is dropped, LogWrite never fails.
+	// dropped messages are counted in QueueOverflow
+	DropOldest
+)
+
+type OverflowQueue interface {
+	SetOverflow(policy Overflow_t, timeout time.Duration)
+}
+
+// returns false if q has no overflow policy
+func SetOverflow(q Queue, policy Overflow_t, timeout time.Duration) bool {
+	if o, ok := q.(OverflowQueue); ok {
+		o.SetOverflow(policy, timeout)
+		return true
+	}
+	return false
+}
+
+type DropQueue interface {
+	SetOnDrop(fn func(m Msg_t))
+}
+
+// returns false if q does not report dropped messages
+func SetOnDrop(q Queue, fn func(m Msg_t)) bool {
+	if d, ok := q.(DropQueue); ok {
+		d.SetOnDrop(fn)
+		return true
+	}
+	return false
+}
+
+// dropped messages kept for on_drop, others are only counted
+const DROP_PENDING = 1024
+
 type Queue_t struct {
+	ctx             context.Context
+	cancel          context.CancelFunc
 	wg              sync.WaitGroup
 	mx              sync.Mutex
 	q               queue.Queue[Msg_t]
+	flush           *sync.Cond
+	space           *sync.Cond
+	workers         int
+	reopen          func() error
+	overflow        Overflow_t
+	block_timeout   time.Duration
+	on_drop         func(m Msg_t)
+	dropped         []Msg_t
 	queue_write     int
+	high_water      int
 	queue_read      int
 	queue_overflow  int
+	queue_retry     int
+	queue_drop      int
+	circuit_drop    int
+	circuit_state   string
 	write_error_cnt int
 	write_error_msg string
 }
 
 func NewQueue(limit int) (self *Queue_t) {
 	self = &Queue_t{}
+	self.ctx, self.cancel = context.WithCancel(context.Background())
 	self.q = queue.NewOpen[Msg_t](&self.mx, limit)
+	self.flush = sync.NewCond(&self.mx)
+	self.space = sync.NewCond(&self.mx)
 	return self
 }
 
+func (self *Queue_t) SetOverflow(policy Overflow_t, timeout time.Duration) {
+	self.mx.Lock()
+	self.overflow = policy
+	self.block_timeout = timeout
+	self.mx.Unlock()
+}
+
+// fn is called for overflowed, dropped on Requeue and on CloseContext messages.
+// fn is called by queue writers after LogRead, not by LogWrite, up to DROP_PENDING messages between reads
+func (self *Queue_t) SetOnDrop(fn func(m Msg_t)) {
+	self.mx.Lock()
+	self.on_drop = fn
+	if fn == nil {
+		self.dropped = nil
+	}
+	self.mx.Unlock()
+}
+
+func (self *Queue_t) __drop(m Msg_t) {
+	if self.on_drop != nil && len(self.dropped) < DROP_PENDING {
+		self.dropped = append(self.dropped, m)
+	}
+}
+
 func (self *Queue_t) LogWrite(m Msg_t) (n int, err error) {
 	self.mx.Lock()
 	self.queue_write++
+	switch self.overflow {
+	case Block:
+		if err = self.__wait_space(m.Ctx); err != nil {
+			self.queue_overflow++
+			self.__drop(m)
+			self.mx.Unlock()
+			return
+		}
+	case DropOldest:
+		if self.q.Limit() > 0 && self.q.Size() >= self.q.Limit() {
+			if old, ok := self.q.PopFrontNoLock(); ok {
+				self.queue_overflow++
+				self.__drop(old)
+			}
+		}
+	}
 	if self.q.PushBackNoLock(m) == false {
 		self.queue_overflow++
+		self.__drop(m)
 		err = ERROR_OVERFLOW
+	} else if size := self.q.Size(); size > self.high_water {
+		self.high_water = size
 	}
 	self.mx.Unlock()
 	return
 }
 
+func (self *Queue_t) __wait_space(ctx context.Context) (err error) {
+	if self.q.Limit() == 0 || self.q.Size() < self.q.Limit() || self.q.Closed() {
+		return
+	}
+	var expired bool
+	if ctx != nil && ctx.Done() != nil {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		stop := context.AfterFunc(ctx, func() {
+			self.mx.Lock()
+			self.space.Broadcast()
+			self.mx.Unlock()
+		})
+		defer stop()
+	}
+	if self.block_timeout > 0 {
+		timer := time.AfterFunc(self.block_timeout, func() {
+			self.mx.Lock()
+			expired = true
+			self.space.Broadcast()
+			self.mx.Unlock()
+		})
+		defer timer.Stop()
+	}
+	for self.q.Size() >= self.q.Limit() && !self.q.Closed() && !expired {
+		if ctx != nil {
+			if err = ctx.Err(); err != nil {
+				return
+			}
+		}
+		self.space.Wait()
+	}
+	return
+}
+
 // bad design: messages stay in buffer forever and not garbage-collected
 // LogRead(p []Msg_t) (n int, ok bool)
 func (self *Queue_t) LogRead(limit int) (res []Msg_t, ok bool) {
 	var m Msg_t
 	self.mx.Lock()
+	// previous batch of this reader is written
+	self.flush.Broadcast()
 	for len(res) < limit {
 		if m, ok = self.q.PopFront(); ok {
 			res = append(res, m)
@@ -57,10 +201,46 @@ func (self *Queue_t) LogRead(limit int) (res []Msg_t, ok bool) {
 		}
 	}
 	self.queue_read += len(res)
+	if len(res) > 0 {
+		self.space.Broadcast()
+	}
+	on_drop, dropped := self.on_drop, self.dropped
+	self.dropped = nil
 	self.mx.Unlock()
+	for _, v := range dropped {
+		on_drop(v)
+	}
 	return
 }
 
+// return failed messages to the front of queue, messages not fit are dropped
+func (self *Queue_t) Requeue(msg []Msg_t) (n int) {
+	self.mx.Lock()
+	for i := len(msg) - 1; i >= 0; i-- {
+		if self.q.PushFrontNoLock(msg[i]) {
+			n++
+		} else {
+			self.queue_drop++
+			self.__drop(msg[i])
+		}
+	}
+	self.mx.Unlock()
+	return
+}
+
+func (self *Queue_t) Retry(count int) {
+	self.mx.Lock()
+	self.queue_retry += count
+	self.mx.Unlock()
+}
+
+func (self *Queue_t) Circuit(state string, drop int) {
+	self.mx.Lock()
+	self.circuit_state = state
+	self.circuit_drop += drop
+	self.mx.Unlock()
+}
+
 func (self *Queue_t) WriteError(count int, msg string) {
 	self.mx.Lock()
 	self.write_error_cnt += count
@@ -72,29 +252,149 @@ func (self *Queue_t) Size() (res QueueSize_t) {
 	self.mx.Lock()
 	res.Limit = self.q.Limit()
 	res.Size = self.q.Size()
+	res.HighWater = self.high_water
 	res.Readers = self.q.Readers()
 	res.Writers = self.q.Writers()
 	res.QueueWrite = self.queue_write
 	res.QueueOverflow = self.queue_overflow
 	res.QueueRead = self.queue_read
+	res.QueueRetry = self.queue_retry
+	res.QueueDrop = self.queue_drop
+	res.CircuitDrop = self.circuit_drop
+	res.CircuitState = self.circuit_state
 	res.WriteErrorCnt = self.write_error_cnt
 	res.WriteErrorMsg = self.write_error_msg
 	self.mx.Unlock()
 	return
 }
 
+func (self *Queue_t) Closed() (res bool) {
+	self.mx.Lock()
+	res = self.q.Closed()
+	self.mx.Unlock()
+	return
+}
+
+// Reopen of writer behind queue
+func (self *Queue_t) Reopen() error {
+	if self.reopen == nil {
+		return nil
+	}
+	return self.reopen()
+}
+
+// HighWater is set to current size, previous value is returned
+func (self *Queue_t) ResetHighWater() (res int) {
+	self.mx.Lock()
+	res = self.high_water
+	self.high_water = self.q.Size()
+	self.mx.Unlock()
+	return
+}
+
 func (self *Queue_t) WgAdd(n int) {
+	self.mx.Lock()
+	self.workers += n
+	self.mx.Unlock()
 	self.wg.Add(n)
 }
 
 func (self *Queue_t) WgDone() {
+	self.mx.Lock()
+	self.workers--
+	self.flush.Broadcast()
+	self.mx.Unlock()
 	self.wg.Done()
 }
 
+// done when workers are stopped by Close or CloseContext, writers use it for requests and retry delays
+func (self *Queue_t) Context() context.Context {
+	return self.ctx
+}
+
+// messages read but not written by stopped writer
+func (self *Queue_t) Drop(msg []Msg_t) {
+	self.mx.Lock()
+	self.queue_drop += len(msg)
+	for _, v := range msg {
+		self.__drop(v)
+	}
+	on_drop, dropped := self.on_drop, self.dropped
+	self.dropped = nil
+	self.mx.Unlock()
+	for _, v := range dropped {
+		on_drop(v)
+	}
+}
+
+// queue of writer holding file or connection, writer is closed after workers are stopped
+type WriterQueue_t struct {
+	*Queue_t
+	writer Queue
+}
+
+func (self *WriterQueue_t) Close() (err error) {
+	self.Queue_t.Close()
+	return self.writer.Close()
+}
+
+// writer is closed even if workers are not stopped, blocked write of connection fails then
+func (self *WriterQueue_t) CloseContext(ctx context.Context) (err error) {
+	err = self.Queue_t.CloseContext(ctx)
+	if err2 := self.writer.Close(); err == nil {
+		err = err2
+	}
+	return
+}
+
+// wait until queue is empty and all workers are waiting for messages
+func (self *Queue_t) Flush() (err error) {
+	self.mx.Lock()
+	for self.workers > 0 && (self.q.Size() > 0 || self.q.Readers() < self.workers) {
+		self.flush.Wait()
+	}
+	self.mx.Unlock()
+	return
+}
+
 func (self *Queue_t) Close() (err error) {
 	self.mx.Lock()
 	self.q.Close()
+	self.space.Broadcast()
 	self.mx.Unlock()
 	self.wg.Wait()
+	self.cancel()
 	return
 }
+
+// close and wait for workers until ctx is done, then Context() is cancelled and queued messages are dropped.
+// writers using Context() stop, worker blocked in other write is not interrupted
+func (self *Queue_t) CloseContext(ctx context.Context) (err error) {
+	done := make(chan struct{})
+	go func() {
+		self.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+	self.cancel()
+	self.mx.Lock()
+	for {
+		m, ok := self.q.PopFrontNoLock()
+		if !ok {
+			break
+		}
+		self.queue_drop++
+		self.__drop(m)
+	}
+	on_drop, dropped := self.on_drop, self.dropped
+	self.dropped = nil
+	self.mx.Unlock()
+	for _, v := range dropped {
+		on_drop(v)
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,89 @@
+//
+//
+//
+
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+)
+
+const REDACTED = "***"
+
+var (
+	// 13-19 digits, optionally separated by space or dash
+	RedactCardNumber = regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)
+	// "Bearer <token>", token is replaced
+	RedactBearerToken = regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9\-._~+/]+=*`)
+)
+
+type Redact_t struct {
+	patterns    []*regexp.Regexp
+	replacement []byte
+}
+
+// "level message key=value" like TextMessage, then every match of patterns is replaced, $ in replacement is not expanded.
+// runs on rendered message so it catches secrets passed as %v args.
+// use with WriteMessage(NewRedact(...))
+func NewRedact(patterns []*regexp.Regexp, replacement string) Formatter {
+	return &Redact_t{patterns: patterns, replacement: []byte(replacement)}
+}
+
+func (self *Redact_t) FormatMessage(out io.Writer, in ...Msg_t) (n int, err error) {
+	if len(in) == 0 {
+		return
+	}
+	buf := GetBuffer()
+	defer PutBuffer(buf)
+	if _, err = TextMessage(buf, in[0].Info.LevelName, in[0]); err != nil {
+		return
+	}
+	res := buf.Bytes()
+	for _, v := range self.patterns {
+		res = v.ReplaceAllLiteral(res, self.replacement)
+	}
+	return out.Write(res)
+}
+
+// values of keys (case insensitive) in objects at any depth are replaced with REDACTED.
+// output keys of objects are sorted
+func RedactJson(in []byte, keys []string) (out []byte, err error) {
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(in))
+	dec.UseNumber()
+	if err = dec.Decode(&v); err != nil {
+		return in, err
+	}
+	return json.Marshal(redact_value(v, keys))
+}
+
+func redact_value(in any, keys []string) any {
+	switch v := in.(type) {
+	case map[string]any:
+		for k, value := range v {
+			if redact_key(k, keys) {
+				v[k] = REDACTED
+			} else {
+				v[k] = redact_value(value, keys)
+			}
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = redact_value(value, keys)
+		}
+	}
+	return in
+}
+
+func redact_key(key string, keys []string) bool {
+	for _, v := range keys {
+		if strings.EqualFold(key, v) {
+			return true
+		}
+	}
+	return false
+}
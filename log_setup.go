@@ -11,6 +11,7 @@ Logs:
     LogSize: 10000000
     LogDuration: "24h"
     LogBackup: 15
+    LogCompress: true
 
   - LogType: "file"
     LogLevel: 3
@@ -20,6 +21,12 @@ Logs:
     LogDuration: "24h"
     LogBackup: 15
 
+  - LogType: "syslog"
+    LogLevel: 2
+    LogNetwork: "udp"
+    LogAddress: "127.0.0.1:514"
+    LogFacility: 16
+
 	for k, v := range cfg.Kibana {
 		log_http := log.NewHttpQueue(
 			v.QueueSize,
@@ -42,7 +49,9 @@ Logs:
 			log.RpsLimit(log.NewRps(time.Second, 100, 1000)),
 			log.BulkWrite(1024),
 		)
-		log.GetLogger().SwapLevelMap(log.GetLogger().CopyLevelMap().AddOutputs(k, log_http, log.WhatLevel(v.Level)))
+		log.GetLogger().UpdateLevelMap(func(m log.Level_map_t) error {
+			return m.AddOutputsUnique(k, log_http, log.WhatLevel(v.Level))
+		})
 	}
 	for k, v := range cfg.Telegram {
 		log_tg := log.NewHttpQueue(
@@ -50,32 +59,42 @@ Logs:
 			v.Writers,
 			log.NewUrls(v.Host),
 			log.MessageTG_t{
-				ChatId:    v.ChatID,
-				Hostname:  self.hostname,
-				TextLimit: 4096,
+				ChatId:          v.ChatID,
+				MessageThreadId: v.ThreadID,
+				Hostname:        self.hostname,
+				TextLimit:       4096,
 			},
 			self.client,
 			log.PostHeader(headers),
 			log.PostTimeout(15*time.Second),
 			log.PostDelay(1500*time.Millisecond),
 		)
-		log.GetLogger().SwapLevelMap(log.GetLogger().CopyLevelMap().AddOutputs(k, log_tg, log.WhatLevel(v.Level)))
+		log.GetLogger().UpdateLevelMap(func(m log.Level_map_t) error {
+			return m.AddOutputsUnique(k, log_tg, log.WhatLevel(v.Level))
+		})
 	}
 */
 
 package log
 
 import (
+	"bytes"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math"
 	"net"
 	"net/http"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
 	"unicode/utf8"
 )
 
@@ -93,16 +112,87 @@ var (
 )
 
 type Args_t struct {
-	LogType     string        `yaml:"LogType"`
-	LogFile     string        `yaml:"LogFile"`
-	LogDate     string        `yaml:"LogDate"`
-	LogLevel    int64         `yaml:"LogLevel"`
-	LogLimit    int           `yaml:"LogLimit"`
-	LogSize     int           `yaml:"LogSize"`
-	LogBackup   int           `yaml:"LogBackup"`
-	LogQueue    int           `yaml:"LogQueue"`
-	LogWriters  int           `yaml:"LogWriters"`
-	LogDuration time.Duration `yaml:"LogDuration"`
+	LogType      string        `yaml:"LogType" json:"LogType"`
+	LogFile      string        `yaml:"LogFile" json:"LogFile"`
+	LogDate      string        `yaml:"LogDate" json:"LogDate"`
+	LogLevel     int64         `yaml:"LogLevel" json:"LogLevel"`
+	LogLimit     int           `yaml:"LogLimit" json:"LogLimit"`
+	LogSize      int           `yaml:"LogSize" json:"LogSize"`
+	LogBackup    int           `yaml:"LogBackup" json:"LogBackup"`
+	LogQueue     int           `yaml:"LogQueue" json:"LogQueue"`
+	LogWriters   int           `yaml:"LogWriters" json:"LogWriters"`
+	LogDuration  time.Duration `yaml:"LogDuration" json:"LogDuration"`
+	LogNetwork   string        `yaml:"LogNetwork" json:"LogNetwork"`
+	LogAddress   string        `yaml:"LogAddress" json:"LogAddress"`
+	LogFacility  int           `yaml:"LogFacility" json:"LogFacility"`
+	LogCompress  bool          `yaml:"LogCompress" json:"LogCompress"`
+	LogSync      bool          `yaml:"LogSync" json:"LogSync"`
+	LogSyncEvery time.Duration `yaml:"LogSyncEvery" json:"LogSyncEvery"`
+	LogFileMode  os.FileMode   `yaml:"LogFileMode" json:"LogFileMode"`
+	LogDirMode   os.FileMode   `yaml:"LogDirMode" json:"LogDirMode"`
+	// group id of file outputs, 0 keeps group of process
+	LogFileGroup int           `yaml:"LogFileGroup" json:"LogFileGroup"`
+	LogColor     bool          `yaml:"LogColor" json:"LogColor"`
+	LogMaxTotal  int           `yaml:"LogMaxTotal" json:"LogMaxTotal"`
+	LogMaxAge    time.Duration `yaml:"LogMaxAge" json:"LogMaxAge"`
+	LogEllipsis  string        `yaml:"LogEllipsis" json:"LogEllipsis"`
+}
+
+// time.Duration as string in JSON, i.e. "24h", number is accepted as nanoseconds
+type Duration_t time.Duration
+
+func (self Duration_t) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(self).String())
+}
+
+func (self *Duration_t) UnmarshalJSON(in []byte) (err error) {
+	var temp any
+	if err = json.Unmarshal(in, &temp); err != nil {
+		return
+	}
+	switch v := temp.(type) {
+	case string:
+		var d time.Duration
+		if d, err = time.ParseDuration(v); err == nil {
+			*self = Duration_t(d)
+		}
+	case float64:
+		*self = Duration_t(v)
+	case nil:
+	default:
+		err = fmt.Errorf("duration: %s", in)
+	}
+	return
+}
+
+type args_json_t Args_t
+
+func (self Args_t) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		args_json_t
+		LogDuration  Duration_t `json:"LogDuration"`
+		LogSyncEvery Duration_t `json:"LogSyncEvery"`
+		LogMaxAge    Duration_t `json:"LogMaxAge"`
+	}{
+		args_json_t:  args_json_t(self),
+		LogDuration:  Duration_t(self.LogDuration),
+		LogSyncEvery: Duration_t(self.LogSyncEvery),
+		LogMaxAge:    Duration_t(self.LogMaxAge),
+	})
+}
+
+func (self *Args_t) UnmarshalJSON(in []byte) error {
+	return json.Unmarshal(in, &struct {
+		*args_json_t
+		LogDuration  *Duration_t `json:"LogDuration"`
+		LogSyncEvery *Duration_t `json:"LogSyncEvery"`
+		LogMaxAge    *Duration_t `json:"LogMaxAge"`
+	}{
+		args_json_t:  (*args_json_t)(self),
+		LogDuration:  (*Duration_t)(&self.LogDuration),
+		LogSyncEvery: (*Duration_t)(&self.LogSyncEvery),
+		LogMaxAge:    (*Duration_t)(&self.LogMaxAge),
+	})
 }
 
 func NewLogger() (out Logger) {
@@ -125,19 +215,101 @@ func NewLogger() (out Logger) {
 	return
 }
 
-func WhatLevel(in int64) []Info_t {
-	switch in {
-	case 4:
-		return []Info_t{LOG_ERROR}
-	case 3:
-		return []Info_t{LOG_ERROR, LOG_WARN}
-	case 2:
-		return []Info_t{LOG_ERROR, LOG_WARN, LOG_INFO}
-	case 1:
-		return []Info_t{LOG_ERROR, LOG_WARN, LOG_INFO, LOG_DEBUG}
-	default:
-		return []Info_t{LOG_ERROR, LOG_WARN, LOG_INFO, LOG_DEBUG, LOG_TRACE}
+var (
+	__levels_mx sync.Mutex
+	__levels    = []Info_t{LOG_ERROR, LOG_WARN, LOG_INFO, LOG_DEBUG, LOG_TRACE}
+)
+
+// custom level, i.e. RegisterLevel("SECURITY", 10) is in every WhatLevel(n) for n <= 10.
+// registering existing id renames level
+func RegisterLevel(name string, id int64) Info_t {
+	level := Info_t{LevelName: name, LevelId: id}
+	__levels_mx.Lock()
+	defer __levels_mx.Unlock()
+	temp := make([]Info_t, 0, len(__levels)+1)
+	for _, v := range __levels {
+		if v.LevelId != id {
+			temp = append(temp, v)
+		}
 	}
+	temp = append(temp, level)
+	sort.Slice(temp, func(i, j int) bool { return temp[i].LevelId > temp[j].LevelId })
+	__levels = temp
+	return level
+}
+
+var __level_names atomic.Pointer[map[int64]string]
+
+// rendered LevelName of messages with level id, i.e. SetLevelName(LOG_WARN, "WRN").
+// id and filtering are not changed, empty name restores LevelName of message
+func SetLevelName(level Info_t, name string) {
+	__levels_mx.Lock()
+	defer __levels_mx.Unlock()
+	temp := map[int64]string{}
+	if names := __level_names.Load(); names != nil {
+		for k, v := range *names {
+			temp[k] = v
+		}
+	}
+	if len(name) == 0 {
+		delete(temp, level.LevelId)
+	} else {
+		temp[level.LevelId] = name
+	}
+	__level_names.Store(&temp)
+}
+
+func LevelName(level Info_t) string {
+	if names := __level_names.Load(); names != nil {
+		if name, ok := (*names)[level.LevelId]; ok {
+			return name
+		}
+	}
+	return level.LevelName
+}
+
+// registered levels with LevelId >= in, highest first.
+// in above highest registered level selects all levels as before
+func WhatLevel(in int64) (res []Info_t) {
+	__levels_mx.Lock()
+	defer __levels_mx.Unlock()
+	for _, v := range __levels {
+		if v.LevelId >= in {
+			res = append(res, v)
+		}
+	}
+	if len(res) == 0 {
+		res = append(res, __levels...)
+	}
+	return
+}
+
+// registered level with id, LevelName is empty if not registered
+func level_by_id(id int64) Info_t {
+	__levels_mx.Lock()
+	defer __levels_mx.Unlock()
+	for _, v := range __levels {
+		if v.LevelId == id {
+			return v
+		}
+	}
+	return Info_t{LevelId: id}
+}
+
+// registered level by case-insensitive name or by id, i.e. "debug", "DEBUG", "1"
+func ParseLevel(in string) (level Info_t, ok bool) {
+	__levels_mx.Lock()
+	for _, v := range __levels {
+		if strings.EqualFold(v.LevelName, in) {
+			__levels_mx.Unlock()
+			return v, true
+		}
+	}
+	__levels_mx.Unlock()
+	if id, err := strconv.ParseInt(in, 10, 64); err == nil {
+		return level_by_id(id), true
+	}
+	return
 }
 
 func LogStderr(format string, args ...any) {
@@ -145,55 +317,284 @@ func LogStderr(format string, args ...any) {
 	io.WriteString(os.Stderr, "\n")
 }
 
+func FileOptions(v Args_t) (opts []WriterOption) {
+	if v.LogCompress {
+		opts = append(opts, CompressBackups())
+	}
+	if v.LogSync {
+		opts = append(opts, SyncEvery(v.LogSyncEvery))
+	}
+	if v.LogFileMode != 0 {
+		opts = append(opts, FileMode(v.LogFileMode))
+	}
+	if v.LogDirMode != 0 {
+		opts = append(opts, DirMode(v.LogDirMode))
+	}
+	if v.LogFileGroup != 0 {
+		opts = append(opts, FileGroup(v.LogFileGroup))
+	}
+	if v.LogMaxTotal > 0 {
+		opts = append(opts, MaxTotalSize(v.LogMaxTotal))
+	}
+	if v.LogMaxAge > 0 {
+		opts = append(opts, MaxAge(v.LogMaxAge))
+	}
+	if len(v.LogEllipsis) > 0 {
+		opts = append(opts, LimitEllipsis(v.LogEllipsis))
+	}
+	return
+}
+
+// writer options of stdout and stderr types from Args_t
+func StdOptions(v Args_t, out *os.File) (opts []WriterOption) {
+	if v.LogColor {
+		opts = append(opts, WriteMessage(NewColor(out)))
+	}
+	if len(v.LogEllipsis) > 0 {
+		opts = append(opts, LimitEllipsis(v.LogEllipsis))
+	}
+	return
+}
+
+type ArgsError_t struct {
+	Index   int
+	LogType string
+	Err     error
+}
+
+func (self *ArgsError_t) Error() string {
+	return fmt.Sprintf("LOG OUTPUT %v %v: %v", self.Index, self.LogType, self.Err)
+}
+
+func (self *ArgsError_t) Unwrap() error {
+	return self.Err
+}
+
+// all misconfigured entries joined, each is *ArgsError_t
+func ValidateArgs(logs []Args_t) error {
+	var errs []error
+	for i, v := range logs {
+		check := func(ok bool, format string, args ...any) {
+			if !ok {
+				errs = append(errs, &ArgsError_t{Index: i, LogType: v.LogType, Err: fmt.Errorf(format, args...)})
+			}
+		}
+		switch v.LogType {
+		case "ctx", "syslog", "stdout", "stderr":
+		case "file", "filequeue":
+			check(len(v.LogFile) > 0, "LogFile is empty")
+			check(v.LogSize > 0, "LogSize=%v, must be > 0", v.LogSize)
+		case "filetime", "filetimequeue":
+			check(len(v.LogFile) > 0, "LogFile is empty")
+			check(v.LogDuration > 0, "LogDuration=%v, must be > 0", v.LogDuration)
+		case "syslogqueue", "stdoutqueue", "stderrqueue":
+		default:
+			check(false, "unknown LogType")
+			continue
+		}
+		if strings.HasSuffix(v.LogType, "queue") {
+			check(v.LogWriters > 0, "LogWriters=%v, must be > 0", v.LogWriters)
+			check(v.LogWriters <= v.LogQueue, "LogWriters=%v > LogQueue=%v", v.LogWriters, v.LogQueue)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// LogLevel from environment, LOG_LEVEL_<NAME> has precedence over LOG_LEVEL, both over configured LogLevel.
+// NAME is output name in upper case with non-alphanumeric characters replaced by '_',
+// i.e. LOG_LEVEL_STDOUT or LOG_LEVEL__VAR_LOG_APP_LOG for LogFile /var/log/app.log
+func EnvLevels(logs []Args_t) (res []Args_t, err error) {
+	var errs []error
+	for i, v := range logs {
+		for _, key := range []string{"LOG_LEVEL", EnvLevelName(setup_name(v))} {
+			if value := os.Getenv(key); len(value) > 0 {
+				if level, ok := ParseLevel(value); ok {
+					v.LogLevel = level.LevelId
+				} else {
+					errs = append(errs, &ArgsError_t{Index: i, LogType: v.LogType, Err: fmt.Errorf("%v=%v: unknown level", key, value)})
+				}
+			}
+		}
+		res = append(res, v)
+	}
+	return res, errors.Join(errs...)
+}
+
+func EnvLevelName(name string) string {
+	return "LOG_LEVEL_" + strings.Map(func(r rune) rune {
+		if r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, name)
+}
+
+// output is nil for unknown LogType
+func setup_output(ts time.Time, v Args_t) (name string, output Queue, err error) {
+	name = setup_name(v)
+	switch v.LogType {
+	case "ctx":
+		output = NewLogContextWriter()
+	case "file":
+		output, err = NewWriterFileBytes(ts, v.LogFile, []Formatter{NewDt(v.LogDate), NewFileLine(), NewGetLogContext()}, v.LogSize, v.LogBackup, v.LogLimit, FileOptions(v)...)
+	case "filequeue":
+		output, err = NewWriterFileBytesQueue(v.LogQueue, v.LogWriters, ts, v.LogFile, []Formatter{NewDt(v.LogDate), NewFileLine(), NewGetLogContext()}, v.LogSize, v.LogBackup, v.LogLimit, FileOptions(v)...)
+	case "filetime":
+		output, err = NewWriterFileTime(ts, v.LogFile, []Formatter{NewDt(v.LogDate), NewFileLine(), NewGetLogContext()}, v.LogDuration, v.LogBackup, v.LogLimit, FileOptions(v)...)
+	case "filetimequeue":
+		output, err = NewWriterFileTimeQueue(v.LogQueue, v.LogWriters, ts, v.LogFile, []Formatter{NewDt(v.LogDate), NewFileLine(), NewGetLogContext()}, v.LogDuration, v.LogBackup, v.LogLimit, FileOptions(v)...)
+	case "syslog":
+		output, err = NewWriterSyslog(v.LogNetwork, v.LogAddress, v.LogFacility, []Formatter{NewFileLine(), NewGetLogContext()}, v.LogLimit)
+	case "syslogqueue":
+		output, err = NewWriterSyslogQueue(v.LogQueue, v.LogWriters, v.LogNetwork, v.LogAddress, v.LogFacility, []Formatter{NewFileLine(), NewGetLogContext()}, v.LogLimit)
+	case "stdout":
+		output = NewWriterStdany([]Formatter{NewDt(v.LogDate), NewFileLine(), NewGetLogContext()}, os.Stdout, v.LogLimit, StdOptions(v, os.Stdout)...)
+	case "stdoutqueue":
+		output = NewWriterStdanyQueue(v.LogQueue, v.LogWriters, []Formatter{NewDt(v.LogDate), NewFileLine(), NewGetLogContext()}, os.Stdout, v.LogLimit, StdOptions(v, os.Stdout)...)
+	case "stderr":
+		output = NewWriterStdany([]Formatter{NewDt(v.LogDate), NewFileLine(), NewGetLogContext()}, os.Stderr, v.LogLimit, StdOptions(v, os.Stderr)...)
+	case "stderrqueue":
+		output = NewWriterStdanyQueue(v.LogQueue, v.LogWriters, []Formatter{NewDt(v.LogDate), NewFileLine(), NewGetLogContext()}, os.Stderr, v.LogLimit, StdOptions(v, os.Stderr)...)
+	}
+	return
+}
+
+type setup_t struct {
+	args   Args_t
+	output Queue
+}
+
+// outputs created by SetupLogger and ReloadLogger
+var (
+	__setup_mx      sync.Mutex
+	__setup_outputs = map[string]setup_t{}
+)
+
+// failed outputs are reported to HandleError, log_debug may be nil.
+// LogLevel may be overridden from environment, see EnvLevels()
 func SetupLogger(ts time.Time, logs []Args_t, log_debug func(string, ...any)) (out Logger, err error) {
+	if log_debug == nil {
+		log_debug = func(string, ...any) {}
+	}
+	__setup_mx.Lock()
+	defer __setup_mx.Unlock()
 	m := NewLevelMap()
+	outputs := map[string]setup_t{}
+	logs, err = EnvLevels(logs)
+	errs := []error{err, ValidateArgs(logs)}
 	for _, v := range logs {
-		switch v.LogType {
-		case "ctx":
-			m.AddOutputs("ctx", NewLogContextWriter(), WhatLevel(v.LogLevel))
-		case "file":
-			if output, err := NewWriterFileBytes(ts, v.LogFile, []Formatter{NewDt(v.LogDate), NewFileLine(), NewGetLogContext()}, v.LogSize, v.LogBackup, v.LogLimit); err != nil {
-				log_debug("LOG ERROR: %v %v", ts.Format("2006-01-02 15:04:05"), err)
-			} else {
-				m.AddOutputs(v.LogFile, output, WhatLevel(v.LogLevel))
-			}
-		case "filequeue":
-			if output, err := NewWriterFileBytesQueue(v.LogQueue, v.LogWriters, ts, v.LogFile, []Formatter{NewDt(v.LogDate), NewFileLine(), NewGetLogContext()}, v.LogSize, v.LogBackup, v.LogLimit); err != nil {
-				log_debug("LOG ERROR: %v %v", ts.Format("2006-01-02 15:04:05"), err)
-			} else {
-				m.AddOutputs(v.LogFile, output, WhatLevel(v.LogLevel))
+		errs = append(errs, setup_add(ts, m, outputs, v))
+	}
+	err = errors.Join(errs...)
+	__setup_outputs = outputs
+	out = New(m)
+	SetLogger(out)
+	setup_debug(logs, log_debug)
+	return
+}
+
+// reconfigure logger set by SetupLogger, only outputs created by SetupLogger or ReloadLogger are changed.
+// outputs are matched by name, output with same Args_t except LogLevel is kept open and only moved to new levels.
+// changed and removed outputs are detached and closed before replacements are opened
+func ReloadLogger(ts time.Time, logs []Args_t, log_debug func(string, ...any)) (out Logger, err error) {
+	if log_debug == nil {
+		log_debug = func(string, ...any) {}
+	}
+	__setup_mx.Lock()
+	defer __setup_mx.Unlock()
+	out = GetLogger()
+	logs, err = EnvLevels(logs)
+	errs := []error{err, ValidateArgs(logs)}
+
+	keep := map[string]Args_t{}
+	for _, v := range logs {
+		name := setup_name(v)
+		if prev, ok := __setup_outputs[name]; ok && setup_same(prev.args, v) {
+			keep[name] = v
+		}
+	}
+	var stale []Queue
+	out.UpdateLevelMap(func(m Level_map_t) error {
+		for name, prev := range __setup_outputs {
+			if _, ok := keep[name]; ok {
+				continue
 			}
-		case "filetime":
-			if output, err := NewWriterFileTime(ts, v.LogFile, []Formatter{NewDt(v.LogDate), NewFileLine(), NewGetLogContext()}, v.LogDuration, v.LogBackup, v.LogLimit); err != nil {
-				log_debug("LOG ERROR: %v %v", ts.Format("2006-01-02 15:04:05"), err)
-			} else {
-				m.AddOutputs(v.LogFile, output, WhatLevel(v.LogLevel))
+			// output removed by user is closed already
+			if output, ok := m.GetOutput(name); ok && output == prev.output {
+				m.RemoveOutput(name)
+				stale = append(stale, output)
 			}
-		case "filetimequeue":
-			if output, err := NewWriterFileTimeQueue(v.LogQueue, v.LogWriters, ts, v.LogFile, []Formatter{NewDt(v.LogDate), NewFileLine(), NewGetLogContext()}, v.LogDuration, v.LogBackup, v.LogLimit); err != nil {
-				log_debug("LOG ERROR: %v %v", ts.Format("2006-01-02 15:04:05"), err)
-			} else {
-				m.AddOutputs(v.LogFile, output, WhatLevel(v.LogLevel))
+		}
+		return nil
+	})
+	for _, v := range stale {
+		v.Close()
+	}
+
+	outputs := map[string]setup_t{}
+	out.UpdateLevelMap(func(m Level_map_t) error {
+		for _, v := range logs {
+			name := setup_name(v)
+			if _, ok := keep[name]; ok {
+				if output, ok := m.GetOutput(name); ok && output == __setup_outputs[name].output {
+					m.SetOutputLevel(name, WhatLevel(v.LogLevel))
+					outputs[name] = setup_t{args: v, output: output}
+					continue
+				}
 			}
-		case "stdout":
-			m.AddOutputs("stdout", NewWriterStdany([]Formatter{NewDt(v.LogDate), NewFileLine(), NewGetLogContext()}, os.Stdout, v.LogLimit), WhatLevel(v.LogLevel))
-		case "stdoutqueue":
-			m.AddOutputs("stdout", NewWriterStdanyQueue(v.LogQueue, v.LogWriters, []Formatter{NewDt(v.LogDate), NewFileLine(), NewGetLogContext()}, os.Stdout, v.LogLimit), WhatLevel(v.LogLevel))
-		case "stderr":
-			m.AddOutputs("stderr", NewWriterStdany([]Formatter{NewDt(v.LogDate), NewFileLine(), NewGetLogContext()}, os.Stderr, v.LogLimit), WhatLevel(v.LogLevel))
-		case "stderrqueue":
-			m.AddOutputs("stderr", NewWriterStdanyQueue(v.LogQueue, v.LogWriters, []Formatter{NewDt(v.LogDate), NewFileLine(), NewGetLogContext()}, os.Stderr, v.LogLimit), WhatLevel(v.LogLevel))
+			errs = append(errs, setup_add(ts, m, outputs, v))
 		}
+		return nil
+	})
+	err = errors.Join(errs...)
+	__setup_outputs = outputs
+	setup_debug(logs, log_debug)
+	return
+}
+
+// failed outputs are reported to HandleError and returned
+func setup_add(ts time.Time, m Level_map_t, outputs map[string]setup_t, v Args_t) (err error) {
+	name, output, err := setup_output(ts, v)
+	if err != nil {
+		err = fmt.Errorf("%v %v: %w", v.LogType, v.LogFile, err)
+		HandleError(err)
+		return
 	}
-	out = New(m)
-	SetLogger(out)
-	for _, v := range logs {
-		log_debug("LOG OUTPUT: LogLevel=%v, LogLimit=%v, LogType=%v, LogFile=%v, LogSize=%v, LogDuration=%v, LogBackup=%v, LogQueue=%v, LogWriters=%v",
-			v.LogLevel, v.LogLimit, v.LogType, v.LogFile, ByteSize(uint64(v.LogSize)), v.LogDuration, v.LogBackup, v.LogQueue, v.LogWriters)
+	if output == nil {
+		return
+	}
+	if err = m.AddOutputsUnique(name, output, WhatLevel(v.LogLevel)); err != nil {
+		output.Close()
+		err = fmt.Errorf("%v: %w", name, err)
+		HandleError(err)
+		return
 	}
+	outputs[name] = setup_t{args: v, output: output}
 	return
 }
 
+// LogFile for file types, LogType without queue suffix for others
+func setup_name(v Args_t) string {
+	switch v.LogType {
+	case "file", "filequeue", "filetime", "filetimequeue":
+		return v.LogFile
+	}
+	return strings.TrimSuffix(v.LogType, "queue")
+}
+
+func setup_same(a Args_t, b Args_t) bool {
+	a.LogLevel, b.LogLevel = 0, 0
+	return a == b
+}
+
+func setup_debug(logs []Args_t, log_debug func(string, ...any)) {
+	for _, v := range logs {
+		log_debug("LOG OUTPUT: LogLevel=%v, LogLimit=%v, LogType=%v, LogFile=%v, LogSize=%v, LogDuration=%v, LogBackup=%v, LogMaxTotal=%v, LogQueue=%v, LogWriters=%v",
+			v.LogLevel, v.LogLimit, v.LogType, v.LogFile, ByteSize(uint64(v.LogSize)), v.LogDuration, v.LogBackup, ByteSize(uint64(v.LogMaxTotal)), v.LogQueue, v.LogWriters)
+	}
+}
+
+// Index is fixed name if Format is empty, i.e. data stream or write alias
 type MessageIndexNameKB_t struct {
 	Format string `json:"-"`
 	Index  string `json:"_index,omitempty"`
@@ -201,10 +602,32 @@ type MessageIndexNameKB_t struct {
 }
 
 // {"index":{"_index":"logs-2022-01","_type":"_doc"}}
+// {"create":{"_index":"logs"}}
 type MessageIndexKB_t struct {
-	Index MessageIndexNameKB_t `json:"index"`
+	// bulk action, default "index", data streams accept only "create"
+	Action string
+	Index  MessageIndexNameKB_t
 }
 
+// action is one of bulk API names and is written as is
+func (self MessageIndexKB_t) MarshalJSON() (res []byte, err error) {
+	if len(self.Action) == 0 {
+		self.Action = "index"
+	}
+	index, err := json.Marshal(self.Index)
+	if err != nil {
+		return
+	}
+	res = make([]byte, 0, len(self.Action)+len(index)+5)
+	res = append(res, `{"`...)
+	res = append(res, self.Action...)
+	res = append(res, `":`...)
+	res = append(res, index...)
+	return append(res, '}'), nil
+}
+
+const KBTimestamp = "2006-01-02T15:04:05.000-07:00"
+
 type MessageKB_t struct {
 	Index           MessageIndexKB_t `json:"-"`
 	Timestamp       string           `json:"timestamp"` // "2022-02-12T10:11:52.1862628+03:00"
@@ -216,17 +639,56 @@ type MessageKB_t struct {
 	Message         string           `json:"Message,omitempty"`
 	Data            json.RawMessage  `json:"Data,omitempty"`
 	TextLimit       int              `json:"-"`
+	// layout and location of Timestamp, default layout is KBTimestamp in location of message Ts
+	Ts Timestamp_t `json:"-"`
+	// values of keys in Data and fields are replaced with "***", see RedactJson
+	RedactKeys []string `json:"-"`
+	// top level keys added to every document, i.e. {"pod": "app-1", "region": "eu"}
+	// keys are sorted, keys colliding with document are prefixed with "fields.",
+	// fields of message with same keys are prefixed with "fields." too
+	ExtraFields map[string]any `json:"-"`
 }
 
+var __kb_reserved = []string{"timestamp", "ApplicationName", "Environment", "Level", "Location", "Hostname", "Message", "Data"}
+
+// ExtraFields are marshaled once per FormatMessage
+func (self MessageKB_t) extra(buf *bytes.Buffer) (reserved []string) {
+	if len(self.ExtraFields) == 0 {
+		return __kb_reserved
+	}
+	reserved = append(reserved, __kb_reserved...)
+	fields := make([]Field_t, 0, len(self.ExtraFields))
+	for k, v := range self.ExtraFields {
+		fields = append(fields, Field_t{Key: k, Value: v})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Key < fields[j].Key })
+	JsonFieldsRedact(buf, "", fields, self.RedactKeys, __kb_reserved...)
+	for _, v := range fields {
+		reserved = append(reserved, v.Key)
+	}
+	return
+}
+
+// scratch buffers are pooled, Message is copied from buffer and Data is owned by json.Marshal
 func (self MessageKB_t) FormatMessage(out io.Writer, in ...Msg_t) (n int, err error) {
 	var b [64]byte
-	var buf strings.Builder
+	buf := GetBuffer()
+	defer PutBuffer(buf)
+	doc := GetBuffer()
+	defer PutBuffer(doc)
+	enc := json.NewEncoder(doc)
+	extra := GetBuffer()
+	defer PutBuffer(extra)
+	reserved := self.extra(extra)
 
 	if self.TextLimit == 0 {
 		self.TextLimit = math.MaxInt
 	}
+	if len(self.Ts.Layout) == 0 {
+		self.Ts.Layout = KBTimestamp
+	}
 
-	w := &LimitWriter_t{Buf: &buf, Limit: self.TextLimit}
+	w := &LimitWriter_t{Buf: buf, Limit: self.TextLimit}
 
 	for _, v := range in {
 		buf.Reset()
@@ -234,43 +696,69 @@ func (self MessageKB_t) FormatMessage(out io.Writer, in ...Msg_t) (n int, err er
 
 		if len(self.Index.Index.Format) > 0 {
 			self.Index.Index.Index = string(v.Info.Ts.AppendFormat(b[:0], self.Index.Index.Format))
-			json.NewEncoder(out).Encode(self.Index)
+			enc.Encode(self.Index)
+		} else if len(self.Index.Index.Index) > 0 {
+			enc.Encode(self.Index)
 		}
 
 		if strings.HasPrefix(v.Format, "json") {
 			if self.Data, err = json.Marshal(v.Args); err != nil {
 				return
 			}
+			if len(self.RedactKeys) > 0 {
+				if self.Data, err = RedactJson(self.Data, self.RedactKeys); err != nil {
+					return
+				}
+			}
 		} else {
 			fmt.Fprintf(w, v.Format, v.Args...)
 			self.Message = buf.String()
 		}
 
 		self.Level = v.Info.LevelName
-		self.Timestamp = string(v.Info.Ts.AppendFormat(b[:0], "2006-01-02T15:04:05.000-07:00"))
+		self.Timestamp = string(self.Ts.AppendFormat(b[:0], v.Info.Ts))
 
 		buf.Reset()
 		for _, fm := range __get_fl_cx {
-			fm.FormatMessage(&buf, in...)
+			fm.FormatMessage(buf, v)
 		}
 		self.Location = buf.String()
 
-		if err = json.NewEncoder(out).Encode(self); err != nil {
+		if err = self.encode(enc, doc, extra.Bytes(), v.Fields, reserved); err != nil {
 			return
 		}
 	}
+	return out.Write(doc.Bytes())
+}
+
+// extra and fields are top level keys of document
+func (self MessageKB_t) encode(enc *json.Encoder, doc *bytes.Buffer, extra []byte, fields []Field_t, reserved []string) (err error) {
+	start := doc.Len()
+	if err = enc.Encode(self); err != nil || len(extra)+len(fields) == 0 {
+		return
+	}
+	doc.Truncate(start + bytes.LastIndexByte(doc.Bytes()[start:], '}'))
+	doc.Write(extra)
+	JsonFieldsRedact(doc, "", fields, self.RedactKeys, reserved...)
+	doc.WriteString("}\n")
 	return
 }
 
 type MessageTG_t struct {
 	// Unique identifier for the target chat or username of the target channel (in the format @channelusername)
 	ChatId int64 `json:"chat_id,omitempty"`
+	// Unique identifier for the target message thread (topic) of the forum; for forum supergroups only
+	MessageThreadId int64 `json:"message_thread_id,omitempty"`
 	// Text of the message to be sent
 	Text string `json:"text,omitempty"`
+	// Mode for parsing entities in the message text, "MarkdownV2", "HTML" or "Markdown". text is escaped for it
+	ParseMode string `json:"parse_mode,omitempty"`
 
 	ApplicationName string `json:"-"`
 	Hostname        string `json:"-"`
 	TextLimit       int    `json:"-"`
+	// timestamp of each message, empty layout for none
+	Ts Timestamp_t `json:"-"`
 }
 
 func (self MessageTG_t) FormatMessage(out io.Writer, in ...Msg_t) (n int, err error) {
@@ -280,8 +768,84 @@ func (self MessageTG_t) FormatMessage(out io.Writer, in ...Msg_t) (n int, err er
 		self.TextLimit = math.MaxInt
 	}
 
-	w := &LimitWriter_t{Buf: &buf, Limit: self.TextLimit}
+	self.text(&LimitWriter_t{Buf: &buf, Limit: self.TextLimit, Ellipsis: "…"}, in...)
+
+	self.Text = TelegramEscape(self.ParseMode, buf.String())
+	if err = json.NewEncoder(out).Encode(self); err != nil {
+		return
+	}
+	return
+}
+
+// split text longer than TextLimit into several messages, continued messages have (n/m) suffix
+func (self MessageTG_t) SplitMessage(in ...Msg_t) (res [][]byte, err error) {
+	var buf strings.Builder
+	var body bytes.Buffer
+
+	self.text(&buf, in...)
+	text := buf.String()
+
+	if self.TextLimit == 0 || len(text) <= self.TextLimit {
+		self.Text = TelegramEscape(self.ParseMode, text)
+		err = json.NewEncoder(&body).Encode(self)
+		return [][]byte{body.Bytes()}, err
+	}
+
+	// reserve for " (9999/9999)"
+	limit := self.TextLimit - 12
+	if limit <= 0 {
+		limit = 1
+	}
+	var chunks []string
+	for len(text) > self.TextLimit {
+		cut := TruncateBoundary([]byte(text), limit)
+		if cut == 0 {
+			_, cut = utf8.DecodeRuneInString(text)
+		}
+		chunks = append(chunks, text[:cut])
+		text = text[cut:]
+	}
+	if len(text) > 0 {
+		chunks = append(chunks, text)
+	}
+	for i, v := range chunks {
+		if i < len(chunks)-1 {
+			v = fmt.Sprintf("%s (%d/%d)", v, i+1, len(chunks))
+		}
+		body.Reset()
+		self.Text = TelegramEscape(self.ParseMode, v)
+		if err = json.NewEncoder(&body).Encode(self); err != nil {
+			return
+		}
+		res = append(res, append([]byte{}, body.Bytes()...))
+	}
+	return
+}
+
+var (
+	__tg_markdown_v2 = strings.NewReplacer(
+		"\\", "\\\\", "_", "\\_", "*", "\\*", "[", "\\[", "]", "\\]", "(", "\\(", ")", "\\)", "~", "\\~", "`", "\\`",
+		">", "\\>", "#", "\\#", "+", "\\+", "-", "\\-", "=", "\\=", "|", "\\|", "{", "\\{", "}", "\\}", ".", "\\.", "!", "\\!",
+	)
+	__tg_markdown = strings.NewReplacer("_", "\\_", "*", "\\*", "[", "\\[", "`", "\\`")
+	__tg_html     = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+)
+
+// escape text to be shown as is with parse_mode.
+// MessageTG_t escapes after truncation and split, since Telegram limits text length after entities parsing
+func TelegramEscape(parse_mode string, text string) string {
+	switch parse_mode {
+	case "MarkdownV2":
+		return __tg_markdown_v2.Replace(text)
+	case "Markdown":
+		return __tg_markdown.Replace(text)
+	case "HTML":
+		return __tg_html.Replace(text)
+	}
+	return text
+}
 
+func (self MessageTG_t) text(w io.Writer, in ...Msg_t) {
 	if len(self.Hostname) > 0 {
 		io.WriteString(w, self.Hostname)
 		io.WriteString(w, " ")
@@ -293,6 +857,7 @@ func (self MessageTG_t) FormatMessage(out io.Writer, in ...Msg_t) (n int, err er
 	}
 
 	for _, v := range in {
+		self.Ts.FormatMessage(w, v)
 		for _, fm := range __get_fl_cx {
 			fm.FormatMessage(w, v)
 		}
@@ -304,34 +869,62 @@ func (self MessageTG_t) FormatMessage(out io.Writer, in ...Msg_t) (n int, err er
 		fmt.Fprintf(w, v.Format, v.Args...)
 		fmt.Fprintf(w, "\n")
 	}
-
-	self.Text = buf.String()
-	if err = json.NewEncoder(out).Encode(self); err != nil {
-		return
-	}
-	return
 }
 
 type LimitWriter_t struct {
-	Buf   io.Writer
-	Limit int
+	Buf      io.Writer
+	Limit    int
+	Ellipsis string
 }
 
+// Ellipsis is written on truncation if it fits into Limit
 func (self *LimitWriter_t) Write(p []byte) (n int, err error) {
 	if self.Limit >= len(p) {
 		n, err = self.Buf.Write(p)
-	} else {
-		for ; self.Limit > 0; self.Limit-- {
-			if r, _ := utf8.DecodeLastRune(p[:self.Limit]); r != utf8.RuneError {
-				break
-			}
-		}
-		n, err = self.Buf.Write(p[:self.Limit])
+		self.Limit -= n
+		return
+	}
+	limit := self.Limit
+	if len(self.Ellipsis) > 0 && limit >= len(self.Ellipsis) {
+		limit -= len(self.Ellipsis)
+	}
+	if n, err = self.Buf.Write(p[:TruncateBoundary(p, limit)]); err == nil && self.Limit-n >= len(self.Ellipsis) {
+		io.WriteString(self.Buf, self.Ellipsis)
 	}
-	self.Limit -= n
+	self.Limit = 0
 	return
 }
 
+// largest cut <= limit not splitting utf-8 sequence, combining marks and zwj sequences
+func TruncateBoundary(p []byte, limit int) int {
+	if limit >= len(p) {
+		return len(p)
+	}
+	if limit <= 0 {
+		return 0
+	}
+	for limit > 0 && !utf8.RuneStart(p[limit]) {
+		limit--
+	}
+	for limit > 0 {
+		next, _ := utf8.DecodeRune(p[limit:])
+		last, size := utf8.DecodeLastRune(p[:limit])
+		if !runeExtend(next) && last != 0x200D {
+			break
+		}
+		limit -= size
+	}
+	return limit
+}
+
+func runeExtend(r rune) bool {
+	return r == 0x200D ||
+		(r >= 0xFE00 && r <= 0xFE0F) ||
+		(r >= 0x1F3FB && r <= 0x1F3FF) ||
+		(r >= 0xE0020 && r <= 0xE007F) ||
+		unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc)
+}
+
 func ByteUnit(bytes uint64) (float64, string) {
 	switch {
 	case bytes >= (1 << (10 * 6)):
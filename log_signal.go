@@ -0,0 +1,38 @@
+//
+//
+//
+
+package log
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// outputs of logger are reopened on each signal, SIGHUP if sig is empty.
+// errors go to HandleError
+func ReopenOnSignal(logger Logger, sig ...os.Signal) (stop func()) {
+	if len(sig) == 0 {
+		sig = []os.Signal{syscall.SIGHUP}
+	}
+	ch := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(ch, sig...)
+	go func() {
+		for {
+			select {
+			case <-ch:
+				if err := logger.CopyLevelMap().Reopen(); err != nil {
+					HandleError(err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(ch)
+		close(done)
+	}
+}
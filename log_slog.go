@@ -0,0 +1,110 @@
+//
+// slog.Handler adapter
+//
+
+package log
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"strings"
+)
+
+type SlogHandler_t struct {
+	logger Logger
+	group  string
+	format string
+	args   []any
+}
+
+func NewSlogHandler(logger Logger) slog.Handler {
+	return &SlogHandler_t{logger: logger}
+}
+
+func SlogLevel(level slog.Level) Info_t {
+	switch {
+	case level >= slog.LevelError:
+		return LOG_ERROR
+	case level >= slog.LevelWarn:
+		return LOG_WARN
+	case level >= slog.LevelInfo:
+		return LOG_INFO
+	case level >= slog.LevelDebug:
+		return LOG_DEBUG
+	default:
+		return LOG_TRACE
+	}
+}
+
+// levels enabled by ContextWithLevel included
+func (self *SlogHandler_t) Enabled(ctx context.Context, level slog.Level) bool {
+	return self.logger.EnabledContext(ctx, SlogLevel(level))
+}
+
+// message and attributes are rendered as "message key1=%v key2=%v" with values in Args
+func (self *SlogHandler_t) Handle(ctx context.Context, r slog.Record) error {
+	level := SlogLevel(r.Level)
+	level.Ts = r.Time
+	if r.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		level.Pc, level.File, level.Line = r.PC, frame.File, frame.Line
+	}
+	var format strings.Builder
+	format.WriteString(strings.ReplaceAll(r.Message, "%", "%%"))
+	format.WriteString(self.format)
+	args := append(make([]any, 0, len(self.args)+r.NumAttrs()), self.args...)
+	r.Attrs(func(a slog.Attr) bool {
+		args = slogAttr(&format, args, self.group, a)
+		return true
+	})
+	self.logger.Log(ctx, level, format.String(), args...)
+	return nil
+}
+
+func (self *SlogHandler_t) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var format strings.Builder
+	format.WriteString(self.format)
+	args := append([]any{}, self.args...)
+	for _, v := range attrs {
+		args = slogAttr(&format, args, self.group, v)
+	}
+	return &SlogHandler_t{
+		logger: self.logger,
+		group:  self.group,
+		format: format.String(),
+		args:   args,
+	}
+}
+
+func (self *SlogHandler_t) WithGroup(name string) slog.Handler {
+	if len(name) == 0 {
+		return self
+	}
+	return &SlogHandler_t{
+		logger: self.logger,
+		group:  self.group + name + ".",
+		format: self.format,
+		args:   self.args,
+	}
+}
+
+func slogAttr(format *strings.Builder, args []any, group string, a slog.Attr) []any {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return args
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if len(a.Key) > 0 {
+			group += a.Key + "."
+		}
+		for _, v := range a.Value.Group() {
+			args = slogAttr(format, args, group, v)
+		}
+		return args
+	}
+	format.WriteString(" ")
+	format.WriteString(strings.ReplaceAll(group+a.Key, "%", "%%"))
+	format.WriteString("=%v")
+	return append(args, a.Value.Any())
+}
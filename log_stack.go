@@ -0,0 +1,59 @@
+//
+//
+//
+
+package log
+
+import (
+	"encoding/json"
+	"runtime"
+	"strings"
+)
+
+// frames rendered as "pkg.Func file.go:line", one per line
+type Stack_t []runtime.Frame
+
+// depth frames starting from first caller outside this package after frame skip, then caller_skip more frames.
+// frames of _test.go files are callers, as in FileLineSkip
+func CallerStack(skip int, depth int, caller_skip int) (res Stack_t) {
+	pcs := make([]uintptr, depth+caller_skip+32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(skip+2, pcs)])
+	frames.Next()
+	for outside, more := false, true; more && len(res) < depth; {
+		var frame runtime.Frame
+		frame, more = frames.Next()
+		if outside = outside || !InPackage(frame); !outside {
+			continue
+		}
+		if caller_skip > 0 {
+			caller_skip--
+		} else {
+			res = append(res, frame)
+		}
+	}
+	return
+}
+
+func (self Stack_t) Strings() (res []string) {
+	var b [128]byte
+	for _, v := range self {
+		res = append(res, FuncName(v.Function)+" "+string(AppendFileLine(b[:0], v.File, v.Line)))
+	}
+	return
+}
+
+func (self Stack_t) String() string {
+	return strings.Join(self.Strings(), "\n")
+}
+
+func (self Stack_t) MarshalJSON() ([]byte, error) {
+	return json.Marshal(self.Strings())
+}
+
+// "github.com/user/repo/pkg.(*Type).Method" -> "pkg.(*Type).Method"
+func FuncName(function string) string {
+	if i := strings.LastIndexByte(function, '/'); i >= 0 {
+		return function[i+1:]
+	}
+	return function
+}
@@ -2,13 +2,31 @@ package log
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
+	"unicode/utf8"
 
+	"go.opentelemetry.io/otel/trace"
 	"gotest.tools/assert"
 )
 
@@ -89,3 +107,2738 @@ func Test3(t *testing.T) {
 	s1 := rps.Size(time.Now())
 	assert.Assert(t, s1 == 0, s1)
 }
+
+func TestJson(t *testing.T) {
+	m := NewLevelMap()
+
+	var buf bytes.Buffer
+	m.AddOutputs("buf", NewWriterStdany(nil, &buf, 0, WriteMessage(NewJson(JsonLayout("")))), WhatLevel(LOG_TRACE.LevelId))
+
+	SetLogger(New(m))
+
+	Info("panic: %v\n\tgoroutine \"1\"", "test")
+
+	assert.Assert(t, strings.HasPrefix(buf.String(), `{"ts":"","level":"INFO","file":`), buf.String())
+	assert.Assert(t, strings.HasSuffix(buf.String(), `,"msg":"panic: test\n\tgoroutine \"1\""}`+"\n"), buf.String())
+
+	// same escaping as encoding/json
+	text := "a\xffb\xe2\x82c я\u2028"
+	expected, _ := json.Marshal(text)
+	var res bytes.Buffer
+	JsonString(&res, text)
+	assert.Assert(t, res.String() == string(expected) && utf8.Valid(res.Bytes()), res.String())
+}
+
+func parseLogfmt(in string) (res map[string]string, err error) {
+	res = map[string]string{}
+	for len(in) > 0 {
+		in = strings.TrimLeft(in, " ")
+		key, value, _ := strings.Cut(in, "=")
+		if strings.HasPrefix(value, `"`) {
+			var quoted string
+			if quoted, err = strconv.QuotedPrefix(value); err != nil {
+				return
+			}
+			in = value[len(quoted):]
+			if value, err = strconv.Unquote(quoted); err != nil {
+				return
+			}
+		} else {
+			value, in, _ = strings.Cut(value, " ")
+		}
+		res[key] = value
+	}
+	return
+}
+
+func TestLogfmt(t *testing.T) {
+	var buf bytes.Buffer
+	formatter := NewLogfmt("2006-01-02")
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	formatter.FormatMessage(&buf, Msg_t{Info: Info_t{Ts: ts, LevelName: "INFO", File: "/src/main.go", Line: 42}, Format: `say "%s" = \ok`, Args: []any{"hello world"}})
+	assert.Assert(t, buf.String() == `ts=2024-01-02 level=INFO file=main.go:42 msg="say \"hello world\" = \\ok"`, buf.String())
+	res, err := parseLogfmt(buf.String())
+	assert.NilError(t, err)
+	assert.Assert(t, res["msg"] == `say "hello world" = \ok`, res["msg"])
+
+	buf.Reset()
+	formatter.FormatMessage(&buf, Msg_t{Info: Info_t{Ts: ts, LevelName: "WARN", File: "main.go", Line: 1}})
+	assert.Assert(t, buf.String() == `ts=2024-01-02 level=WARN file=main.go:1 msg=""`, buf.String())
+	res, err = parseLogfmt(buf.String())
+	assert.NilError(t, err)
+	assert.Assert(t, len(res) == 4 && res["msg"] == "", res)
+
+	buf.Reset()
+	formatter.FormatMessage(&buf, Msg_t{Info: Info_t{Ts: ts, LevelName: "INFO", File: "main.go", Line: 1}, Format: "a\xffb"})
+	assert.Assert(t, buf.String() == "ts=2024-01-02 level=INFO file=main.go:1 msg=\"a\ufffdb\"", buf.String())
+
+	buf.Reset()
+	formatter.FormatMessage(&buf, Msg_t{Info: Info_t{Ts: ts, LevelName: "INFO", File: "main.go", Line: 1}, Format: "ctl\x01", Fields: []Field_t{{Key: "user id", Value: "a b"}, {Key: `k="v"`, Value: 1}, {Key: "", Value: 2}}})
+	assert.Assert(t, buf.String() == `ts=2024-01-02 level=INFO file=main.go:1 msg="ctl\u0001" user_id="a b" k__v_=1 _=2`, buf.String())
+	res, err = parseLogfmt(buf.String())
+	assert.NilError(t, err)
+	assert.Assert(t, len(res) == 7 && res["msg"] == "ctl\x01" && res["user_id"] == "a b", res)
+}
+
+func TestSetOutputLevel(t *testing.T) {
+	m := NewLevelMap()
+
+	var buf1, buf2 bytes.Buffer
+	m.AddOutputs("buf1", NewWriterStdany(nil, &buf1, 0), WhatLevel(LOG_INFO.LevelId))
+	m.AddOutputs("buf2", NewWriterStdany(nil, &buf2, 0), WhatLevel(LOG_INFO.LevelId))
+
+	logger := New(m)
+	logger.Debug("test1")
+
+	assert.NilError(t, logger.SetOutputLevel("buf1", WhatLevel(LOG_DEBUG.LevelId)))
+	assert.Assert(t, logger.SetOutputLevel("unknown", WhatLevel(LOG_DEBUG.LevelId)) == ERROR_OUTPUT_NOT_FOUND)
+	logger.Debug("test2")
+
+	assert.NilError(t, logger.SetOutputLevel("buf1", WhatLevel(LOG_ERROR.LevelId)))
+	logger.Debug("test3")
+	logger.Warn("test4")
+
+	assert.Assert(t, buf1.String() == "DEBUG test2\n", fmt.Sprintf("%q", buf1.String()))
+	assert.Assert(t, buf2.String() == "WARN test4\n", fmt.Sprintf("%q", buf2.String()))
+}
+
+func TestAddOutputsUnique(t *testing.T) {
+	m := NewLevelMap()
+
+	var buf1, buf2 bytes.Buffer
+	assert.NilError(t, m.AddOutputsUnique("file", NewWriterStdany(nil, &buf1, 0), WhatLevel(LOG_INFO.LevelId)))
+	assert.Assert(t, m.AddOutputsUnique("file", NewWriterStdany(nil, &buf2, 0), WhatLevel(LOG_DEBUG.LevelId)) == ERROR_OUTPUT_EXISTS)
+
+	logger := New(m)
+	logger.Debug("test1")
+	logger.Info("test2")
+
+	assert.Assert(t, buf1.String() == "INFO test2\n", fmt.Sprintf("%q", buf1.String()))
+	assert.Assert(t, buf2.Len() == 0, fmt.Sprintf("%q", buf2.String()))
+
+	// AddOutputs extends levels of existing name
+	q := NewWriterStdany(nil, &buf1, 0)
+	m = NewLevelMap().AddOutputs("file", q, []Info_t{LOG_INFO}).AddOutputs("file", q, []Info_t{LOG_DEBUG})
+	assert.Assert(t, m[LOG_DEBUG.LevelId]["file"] == q && m[LOG_INFO.LevelId]["file"] == q, m)
+}
+
+func TestRemoveOutput(t *testing.T) {
+	m := NewLevelMap()
+
+	var buf1, buf2 bytes.Buffer
+	m.AddOutputs("buf1", NewWriterStdany(nil, &buf1, 0), WhatLevel(LOG_DEBUG.LevelId))
+	m.AddOutputs("buf2", NewWriterStdany(nil, &buf2, 0), WhatLevel(LOG_INFO.LevelId))
+
+	logger := New(m)
+	logger.Debug("test1")
+
+	ok, err := logger.RemoveOutput("buf1")
+	assert.NilError(t, err)
+	assert.Assert(t, ok)
+	ok, err = logger.RemoveOutput("buf1")
+	assert.NilError(t, err)
+	assert.Assert(t, !ok)
+
+	logger.Debug("test2")
+	logger.Info("test3")
+
+	_, writers := logger.CopyLevelMap().Outputs()
+	assert.Assert(t, len(writers) == 1, len(writers))
+	assert.Assert(t, buf1.String() == "DEBUG test1\n", fmt.Sprintf("%q", buf1.String()))
+	assert.Assert(t, buf2.String() == "INFO test3\n", fmt.Sprintf("%q", buf2.String()))
+}
+
+func TestOutputInfo(t *testing.T) {
+	m := NewLevelMap()
+
+	var buf1, buf2 bytes.Buffer
+	m.AddOutputs("buf2", NewWriterStdany(nil, &buf2, 0), WhatLevel(LOG_WARN.LevelId))
+	m.AddOutputs("buf1", NewWriterStdany(nil, &buf1, 0), []Info_t{LOG_DEBUG, LOG_ERROR})
+
+	info := New(m).OutputInfo()
+	assert.Assert(t, len(info) == 2, len(info))
+	assert.Assert(t, info[0].Name == "buf1" && info[1].Name == "buf2")
+	assert.Assert(t, len(info[0].Levels) == 2, len(info[0].Levels))
+	assert.Assert(t, info[0].Levels[0].LevelName == "ERROR" && info[0].Levels[1].LevelName == "DEBUG", info[0].Levels)
+	assert.Assert(t, len(info[1].Levels) == 2, len(info[1].Levels))
+	assert.Assert(t, info[1].Levels[0].LevelName == "ERROR" && info[1].Levels[1].LevelName == "WARN", info[1].Levels)
+}
+
+func TestLimitEllipsis(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(NewLevelMap().AddOutputs("buf", NewWriterStdany(nil, &buf, 12, LimitEllipsis("…")), WhatLevel(0)))
+	logger.Info("hello world")
+	logger.Info("ab привет")
+	logger.Info("short")
+	assert.Assert(t, buf.String() == "INFO hell…\nINFO ab …\nINFO short\n", fmt.Sprintf("%q", buf.String()))
+}
+
+func TestTextMultiline(t *testing.T) {
+	var buf1, buf2, buf3 bytes.Buffer
+	logger := New(NewLevelMap().
+		AddOutputs("buf1", NewWriterStdany(nil, &buf1, 0), WhatLevel(0)).
+		AddOutputs("buf2", NewWriterStdany(nil, &buf2, 0, WriteMessage(NewTextMessage(TextMultiline(MultilineEscape)))), WhatLevel(0)).
+		AddOutputs("buf3", NewWriterStdany(nil, &buf3, 0, WriteMessage(NewTextMessage(TextMultiline(MultilineIndent)))), WhatLevel(0)),
+	)
+	logger.With("stack", "a\nb").Error("line1\r\nline2")
+	assert.Assert(t, buf1.String() == "ERROR line1\r\nline2 stack=a\nb\n", fmt.Sprintf("%q", buf1.String()))
+	assert.Assert(t, buf2.String() == `ERROR line1\r\nline2 stack=a\nb`+"\n", fmt.Sprintf("%q", buf2.String()))
+	assert.Assert(t, buf3.String() == "ERROR line1\r\n\tline2 stack=a\n\tb\n", fmt.Sprintf("%q", buf3.String()))
+}
+
+func TestRecover(t *testing.T) {
+	buf := NewCaptureWriter()
+	logger := New(NewLevelMap().AddOutputs("buf", NewWriterStdanyQueue(16, 1, nil, buf, 0), WhatLevel(0)))
+	defer SetLogger(GetLogger())
+	defer logger.Close()
+	SetLogger(logger)
+
+	var recovered any
+	func() {
+		defer func() { recovered = recover() }()
+		defer Recover()
+		panic("boom")
+	}()
+	assert.Assert(t, recovered == "boom", recovered)
+	assert.Assert(t, strings.HasPrefix(buf.String(), "ERROR panic: boom stack=goroutine"), buf.String())
+
+	func() {
+		defer func() { LogPanic(recover()) }()
+		panic("boom2")
+	}()
+	assert.Assert(t, strings.Contains(buf.String(), "ERROR panic: boom2"), buf.String())
+}
+
+func TestFatal(t *testing.T) {
+	buf := NewCaptureWriter()
+	logger := New(NewLevelMap().AddOutputs("buf", NewWriterStdanyQueue(16, 1, nil, buf, 0), WhatLevel(0)))
+	defer SetLogger(GetLogger())
+	SetLogger(logger)
+
+	var code int
+	defer func(exit func(int)) { FatalExit = exit }(FatalExit)
+	FatalExit = func(in int) { code = in }
+	Fatal("fatal %v", 1)
+	assert.Assert(t, code == 1, code)
+	assert.Assert(t, buf.String() == "ERROR fatal 1\n", buf.String())
+}
+
+func TestContextWithLevel(t *testing.T) {
+	var buf1, buf2 bytes.Buffer
+	logger := New(NewLevelMap().
+		AddOutputs("buf1", NewWriterStdany(nil, &buf1, 0), WhatLevel(LOG_INFO.LevelId)).
+		AddOutputs("buf2", NewWriterStdany(nil, &buf2, 0), []Info_t{LOG_ERROR}),
+	)
+	ctx := ContextWithLevel(context.Background(), LOG_DEBUG)
+	logger.Debug("test1")
+	logger.DebugCtx(ctx, "test2")
+	logger.TraceCtx(ctx, "test3")
+	logger.InfoCtx(ctx, "test4")
+
+	assert.Assert(t, buf1.String() == "DEBUG test2\nINFO test4\n", fmt.Sprintf("%q", buf1.String()))
+	assert.Assert(t, buf2.Len() == 0, buf2.String())
+
+	assert.Assert(t, !logger.Enabled(LOG_DEBUG))
+	assert.Assert(t, !logger.EnabledContext(context.Background(), LOG_DEBUG))
+	assert.Assert(t, logger.EnabledContext(ctx, LOG_DEBUG))
+	assert.Assert(t, !logger.EnabledContext(ctx, LOG_TRACE))
+
+	// WARN has outputs, ERROR-only output gets nothing
+	logger.WarnCtx(ctx, "warn of flagged request")
+	assert.Assert(t, buf2.Len() == 0, buf2.String())
+
+	// level with outputs is not promoted
+	var buf3 bytes.Buffer
+	buf1.Reset()
+	logger = New(NewLevelMap().
+		AddOutputs("buf1", NewWriterStdany(nil, &buf1, 0), WhatLevel(LOG_INFO.LevelId)).
+		AddOutputs("buf3", NewWriterStdany(nil, &buf3, 0), WhatLevel(LOG_DEBUG.LevelId)),
+	)
+	logger.Debug("test5")
+	logger.DebugCtx(ctx, "test6")
+	logger.InfoCtx(ctx, "test7")
+	assert.Assert(t, buf1.String() == "INFO test7\n", fmt.Sprintf("%q", buf1.String()))
+	assert.Assert(t, buf3.String() == "DEBUG test5\nDEBUG test6\nINFO test7\n", fmt.Sprintf("%q", buf3.String()))
+}
+
+func TestSyslog(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer conn.Close()
+
+	w, err := NewWriterSyslog("udp", conn.LocalAddr().String(), SYSLOG_LOCAL0, nil, 0)
+	assert.NilError(t, err)
+	defer w.Close()
+
+	w.LogWrite(Msg_t{Info: Info_t{Ts: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), LevelName: "WARN", LevelId: LOG_WARN.LevelId}, Format: "test %v", Args: []any{1}})
+
+	var b [1024]byte
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(b[:])
+	assert.NilError(t, err)
+	assert.Assert(t, strings.HasPrefix(string(b[:n]), "<132>1 2024-01-02T03:04:05.000000Z "), string(b[:n]))
+	assert.Assert(t, strings.HasSuffix(string(b[:n]), " - - WARN test 1"), string(b[:n]))
+}
+
+func TestWriterQueueClose(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer conn.Close()
+	q, err := NewWriterSyslogQueue(10, 1, "udp", conn.LocalAddr().String(), SYSLOG_LOCAL0, nil, 0)
+	assert.NilError(t, err)
+	q.LogWrite(Msg_t{Info: LOG_INFO, Format: "message"})
+	assert.NilError(t, q.Close())
+	assert.Assert(t, q.(*WriterQueue_t).writer.(*WriterSyslog_t).conn == nil)
+
+	// backup is compressed before Close returns
+	filename := filepath.Join(t.TempDir(), "all.log")
+	ts := time.Now()
+	q, err = NewWriterFileBytesQueue(10, 1, ts, filename, nil, 10, 2, 0, CompressBackups())
+	assert.NilError(t, err)
+	for i := 0; i < 3; i++ {
+		q.LogWrite(Msg_t{Info: Info_t{Ts: ts, LevelName: "INFO"}, Format: "message %v", Args: []any{i}})
+	}
+	assert.NilError(t, q.Close())
+	assert.Assert(t, q.(*WriterQueue_t).writer.(*WriterFileBytes_t).out == nil)
+	files, _ := filepath.Glob(filename + "*")
+	for _, v := range files {
+		assert.Assert(t, !strings.HasSuffix(v, ".tmp"), files)
+	}
+	assert.Assert(t, len(files) == 3, files)
+}
+
+func TestGelf(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer conn.Close()
+
+	w, err := NewWriterGelf(conn.LocalAddr().String(), GelfChunkSize(100), GelfGzip(), GelfMessage(MessageGELF_t{Host: "test"}))
+	assert.NilError(t, err)
+	defer w.Close()
+
+	text := strings.Repeat("0123456789", 100)
+	_, err = w.LogWrite(Msg_t{Info: Info_t{Ts: time.Unix(1700000000, 0), LevelName: "ERROR", LevelId: LOG_ERROR.LevelId}, Format: "%s", Args: []any{text}})
+	assert.NilError(t, err)
+
+	var b [1024]byte
+	var body []byte
+	for {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(b[:])
+		assert.NilError(t, err)
+		if b[0] != 0x1e || b[1] != 0x0f {
+			body = append(body, b[:n]...)
+			break
+		}
+		assert.Assert(t, n <= 112, n)
+		body = append(body, b[12:n]...)
+		if b[10] == b[11]-1 {
+			break
+		}
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(body))
+	assert.NilError(t, err)
+	var res MessageGELF_t
+	assert.NilError(t, json.NewDecoder(r).Decode(&res))
+	assert.Assert(t, res.Version == "1.1" && res.Host == "test" && res.Level == 3 && res.Timestamp == 1700000000, res)
+	assert.Assert(t, res.ShortMessage == text, res.ShortMessage)
+}
+
+type kafka_test_t struct {
+	mx   sync.Mutex
+	msgs []KafkaMessage_t
+}
+
+func (self *kafka_test_t) WriteMessages(ctx context.Context, msgs ...KafkaMessage_t) error {
+	self.mx.Lock()
+	self.msgs = append(self.msgs, msgs...)
+	self.mx.Unlock()
+	return nil
+}
+
+func TestKafka(t *testing.T) {
+	producer := &kafka_test_t{}
+	q := NewKafka(100, 1, producer, "logs", NewJson(), KafkaKey(func(m Msg_t) []byte { return []byte(m.Info.LevelName) }))
+
+	m := NewLevelMap()
+	m.AddOutputs("kafka", q, WhatLevel(LOG_TRACE.LevelId))
+	logger := New(m)
+	for i := 0; i < 10; i++ {
+		logger.Info("test %v", i)
+	}
+	q.Close()
+
+	assert.Assert(t, len(producer.msgs) == 10, len(producer.msgs))
+	assert.Assert(t, producer.msgs[9].Topic == "logs" && string(producer.msgs[9].Key) == "INFO", producer.msgs[9])
+	assert.Assert(t, strings.HasSuffix(string(producer.msgs[9].Value), `"msg":"test 9"}`), string(producer.msgs[9].Value))
+	assert.Assert(t, q.Size().QueueRead == 10, q.Size())
+}
+
+func formatTG(t *testing.T, message MessageTG_t, format string, args ...any) string {
+	var buf bytes.Buffer
+	_, err := message.FormatMessage(&buf, Msg_t{Info: Info_t{File: "main.go", Line: 1}, Format: format, Args: args})
+	assert.NilError(t, err)
+	assert.NilError(t, json.Unmarshal(buf.Bytes(), &message))
+	return strings.TrimPrefix(message.Text, "main.go:1 ")
+}
+
+func TestTelegramThread(t *testing.T) {
+	var buf bytes.Buffer
+	_, err := MessageTG_t{ChatId: 1}.FormatMessage(&buf, Msg_t{Format: "test"})
+	assert.NilError(t, err)
+	assert.Assert(t, !strings.Contains(buf.String(), "message_thread_id"), buf.String())
+
+	buf.Reset()
+	_, err = MessageTG_t{ChatId: 1, MessageThreadId: 42}.FormatMessage(&buf, Msg_t{Format: "test"})
+	assert.NilError(t, err)
+	assert.Assert(t, strings.HasPrefix(buf.String(), `{"chat_id":1,"message_thread_id":42,`), buf.String())
+}
+
+func TestTelegramEscape(t *testing.T) {
+	text := "a*b_c[d](e) <f> & g.!"
+	assert.Equal(t, TelegramEscape("", text), text)
+	assert.Equal(t, TelegramEscape("MarkdownV2", text), `a\*b\_c\[d\]\(e\) <f\> & g\.\!`)
+	assert.Equal(t, TelegramEscape("Markdown", text), `a\*b\_c\[d](e) <f> & g.!`)
+	assert.Equal(t, TelegramEscape("HTML", text), "a*b_c[d](e) &lt;f&gt; &amp; g.!")
+	assert.Equal(t, TelegramEscape("MarkdownV2", `\`), `\\`)
+
+	res := formatTG(t, MessageTG_t{ParseMode: "HTML"}, "x < y")
+	assert.Equal(t, res, "x &lt; y\n")
+
+	// split before escaping, suffix is escaped too
+	var message MessageTG_t
+	body, err := MessageTG_t{ParseMode: "MarkdownV2", TextLimit: 20}.SplitMessage(Msg_t{Info: Info_t{File: "main.go", Line: 1}, Format: "0123456789_0123456789"})
+	assert.NilError(t, err)
+	assert.Assert(t, len(body) == 3, len(body))
+	assert.NilError(t, json.Unmarshal(body[0], &message))
+	assert.Equal(t, message.Text, `main\.go: \(1/3\)`)
+	assert.NilError(t, json.Unmarshal(body[1], &message))
+	assert.Equal(t, message.Text, `1 012345 \(2/3\)`)
+	// last message is not continued
+	assert.NilError(t, json.Unmarshal(body[2], &message))
+	assert.Equal(t, message.Text, "6789\\_0123456789\n")
+}
+
+func TestTruncate(t *testing.T) {
+	// prefix "main.go:1 " is 10 bytes
+	// exactly TextLimit bytes, trailing newline does not fit
+	res := formatTG(t, MessageTG_t{TextLimit: 20}, "0123456789")
+	assert.Assert(t, res == "0123456789", fmt.Sprintf("%q", res))
+
+	// 4-byte emoji straddling the limit
+	res = formatTG(t, MessageTG_t{TextLimit: 20}, "0123456\U0001F600")
+	assert.Assert(t, res == "0123456…", fmt.Sprintf("%q", res))
+
+	// emoji with skin tone modifier
+	res = formatTG(t, MessageTG_t{TextLimit: 22}, "0\U0001F44D\U0001F3FD8901234")
+	assert.Assert(t, res == "0\U0001F44D\U0001F3FD…", fmt.Sprintf("%q", res))
+	res = formatTG(t, MessageTG_t{TextLimit: 20}, "0\U0001F44D\U0001F3FD8901234")
+	assert.Assert(t, res == "0…", fmt.Sprintf("%q", res))
+
+	// combining mark
+	res = formatTG(t, MessageTG_t{TextLimit: 18}, "0123e\u0301xyz")
+	assert.Assert(t, res == "0123…", fmt.Sprintf("%q", res))
+
+	assert.Assert(t, TruncateBoundary([]byte("ab\U0001F600"), 5) == 2)
+	assert.Assert(t, TruncateBoundary([]byte("ab\U0001F600"), 6) == 6)
+}
+
+func TestSplitMessages(t *testing.T) {
+	var mx sync.Mutex
+	var texts []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var message MessageTG_t
+		json.NewDecoder(r.Body).Decode(&message)
+		mx.Lock()
+		texts = append(texts, message.Text)
+		mx.Unlock()
+	}))
+	defer srv.Close()
+
+	q := NewHttpQueue(10, 1, NewUrls(srv.URL), MessageTG_t{TextLimit: 32}, srv.Client(), SplitMessages(true), PostDelay(time.Millisecond))
+	q.LogWrite(Msg_t{Info: Info_t{File: "main.go", Line: 1, LevelName: "ERROR"}, Format: "%s", Args: []any{strings.Repeat("я", 20)}})
+	q.Close()
+
+	assert.Assert(t, len(texts) == 3, texts)
+	assert.Assert(t, texts[0] == "main.go:1 ERROR яя (1/3)", texts[0])
+	assert.Assert(t, texts[2] == "яяяяяяяя\n", texts[2])
+	var res string
+	for i, v := range texts {
+		assert.Assert(t, len(v) <= 32 && utf8.ValidString(v), v)
+		if i < len(texts)-1 {
+			v = v[:strings.LastIndex(v, " (")]
+		}
+		res += v
+	}
+	assert.Assert(t, res == "main.go:1 ERROR "+strings.Repeat("я", 20)+"\n", res)
+}
+
+func TestRetryPolicy(t *testing.T) {
+	var mx sync.Mutex
+	var requests, received int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mx.Lock()
+		defer mx.Unlock()
+		if requests++; requests <= 2 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		if r.URL.Path == "/bad" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received++
+	}))
+	defer srv.Close()
+
+	q := NewHttpQueue(10, 1, NewUrls(srv.URL), MessageKB_t{}, srv.Client(), RetryPolicy(3, time.Millisecond, 10*time.Millisecond))
+	q.LogWrite(Msg_t{Format: "test"})
+	q.Close()
+
+	assert.Assert(t, received == 1, received)
+	assert.Assert(t, q.Size().QueueRetry == 2 && q.Size().WriteErrorCnt == 0, q.Size())
+
+	q = NewHttpQueue(10, 1, NewUrls(srv.URL+"/bad"), MessageKB_t{}, srv.Client(), RetryPolicy(3, time.Millisecond, 10*time.Millisecond))
+	q.LogWrite(Msg_t{Format: "test"})
+	time.Sleep(100 * time.Millisecond)
+	q.Close()
+
+	assert.Assert(t, received == 1, received)
+	assert.Assert(t, q.Size().QueueRetry == 0 && q.Size().WriteErrorCnt == 1 && q.Size().WriteErrorMsg == "400 Bad Request", q.Size())
+}
+
+func TestRequeueLimit(t *testing.T) {
+	var mx sync.Mutex
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mx.Lock()
+		requests++
+		mx.Unlock()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	q := NewHttpQueue(10, 1, NewUrls(srv.URL), MessageKB_t{}, srv.Client(), BulkWrite(2), RetryPolicy(2, time.Millisecond, time.Millisecond), HttpOnError(func(error) {}))
+	q.LogWrite(Msg_t{Format: "test1"})
+	q.LogWrite(Msg_t{Format: "test2"})
+	for i := 0; i < 100 && q.Size().WriteErrorCnt == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	q.Close()
+
+	// batch is posted 3 times with 2 retries each, then counted once
+	assert.Assert(t, requests == 9, requests)
+	assert.Assert(t, q.Size().WriteErrorCnt == 2 && q.Size().QueueDrop == 0 && q.Size().QueueRetry == 6, q.Size())
+
+	// requeue to closed queue is counted as drop only
+	q = NewHttpQueue(10, 1, NewUrls(srv.URL), MessageKB_t{}, srv.Client(), BulkWrite(2), RetryPolicy(2, time.Millisecond, time.Millisecond), HttpOnError(func(error) {}))
+	q.LogWrite(Msg_t{Format: "test1"})
+	q.Close()
+	assert.Assert(t, q.Size().WriteErrorCnt+q.Size().QueueDrop == 1, q.Size())
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	ts := time.Now()
+	b := NewBreaker(2, time.Second)
+
+	assert.Assert(t, b.Allow(ts))
+	b.Failure(ts)
+	assert.Assert(t, b.Allow(ts) && b.State() == CIRCUIT_CLOSED)
+	b.Failure(ts)
+	assert.Assert(t, b.Allow(ts) == false && b.State() == CIRCUIT_OPEN)
+
+	ts = ts.Add(time.Second)
+	assert.Assert(t, b.Allow(ts) && b.State() == CIRCUIT_HALF_OPEN)
+	assert.Assert(t, b.Allow(ts) == false)
+	b.Failure(ts)
+	assert.Assert(t, b.Allow(ts) == false && b.State() == CIRCUIT_OPEN)
+
+	ts = ts.Add(time.Second)
+	assert.Assert(t, b.Allow(ts))
+	b.Success()
+	assert.Assert(t, b.Allow(ts) && b.Allow(ts) && b.State() == CIRCUIT_CLOSED)
+
+	q := NewHttpQueue(10, 1, NewUrls("http://127.0.0.1:1"), MessageKB_t{}, &http.Client{}, CircuitBreaker(1, time.Hour))
+	for i := 0; i < 3; i++ {
+		q.LogWrite(Msg_t{Format: "test"})
+	}
+	q.Close()
+
+	assert.Assert(t, q.Size().WriteErrorCnt == 1 && q.Size().CircuitDrop == 2 && q.Size().CircuitState == CIRCUIT_OPEN, q.Size())
+}
+
+type headers_test_t map[string]string
+
+func (self headers_test_t) Header(req *http.Request) error {
+	for k, v := range self {
+		req.Header.Set(k, v)
+	}
+	return nil
+}
+
+func TestGzip(t *testing.T) {
+	var body []byte
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ = io.ReadAll(gz)
+	}))
+	defer srv.Close()
+
+	q := NewHttpQueue(10, 1, NewUrls(srv.URL), MessageKB_t{}, srv.Client(), Gzip(gzip.BestSpeed), PostHeader(headers_test_t{"Content-Type": "application/x-ndjson"}))
+	q.LogWrite(Msg_t{Info: Info_t{LevelName: "INFO"}, Format: "test"})
+	q.Close()
+
+	assert.Assert(t, header.Get("Content-Encoding") == "gzip" && header.Get("Content-Type") == "application/x-ndjson", header)
+	assert.Assert(t, strings.Contains(string(body), `"Message":"test"`), string(body))
+	assert.Assert(t, q.Size().WriteErrorCnt == 0, q.Size())
+}
+
+func BenchmarkGzip(b *testing.B) {
+	var body bytes.Buffer
+	msg := make([]Msg_t, 64)
+	for i := range msg {
+		msg[i] = Msg_t{Info: Info_t{Ts: time.Now(), LevelName: "INFO", File: "main.go", Line: i}, Format: "request %v processed in %v", Args: []any{i, time.Millisecond}}
+	}
+	MessageKB_t{ApplicationName: "app", Environment: "prod"}.FormatMessage(&body, msg...)
+	gz := NewGzip(gzip.DefaultCompression)
+	b.SetBytes(int64(body.Len()))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		gz.Compress(body.Bytes())
+	}
+}
+
+func TestRangeOutputs(t *testing.T) {
+	m := NewLevelMap()
+	m.AddOutputs("b", NewWriterCounter(), WhatLevel(LOG_TRACE.LevelId))
+	m.AddOutputs("a", NewWriterCounter(), WhatLevel(LOG_WARN.LevelId))
+
+	logger := New(m)
+	logger.Info("test")
+	logger.Error("test")
+
+	var res []string
+	logger.RangeOutputs(func(writer_name string, size QueueSize_t) {
+		res = append(res, fmt.Sprintf("%v=%v", writer_name, size.QueueWrite))
+	})
+	assert.Assert(t, strings.Join(res, ",") == "a=1,b=2", res)
+}
+
+func TestSlogHandler(t *testing.T) {
+	m := NewLevelMap()
+
+	var buf bytes.Buffer
+	m.AddOutputs("buf", NewWriterStdany([]Formatter{NewFileLine()}, &buf, 0), WhatLevel(LOG_DEBUG.LevelId))
+
+	logger := slog.New(NewSlogHandler(New(m)))
+	logger.With("request_id", 1).WithGroup("http").Warn("done 100%", "status", 200, slog.Group("req", "method", "GET"))
+	logger.Log(context.Background(), slog.LevelDebug-4, "trace")
+	_, _, line, _ := runtime.Caller(0)
+
+	assert.Assert(t, buf.String() == fmt.Sprintf("log_test.go:%v WARN done 100%% request_id=1 http.status=200 http.req.method=GET\n", line-2), buf.String())
+}
+
+func TestSampler(t *testing.T) {
+	var buf bytes.Buffer
+	q := NewSampler(NewWriterStdany(nil, &buf, 0), 2, time.Second)
+
+	ts := time.Now()
+	for i := 0; i < 5; i++ {
+		q.LogWrite(Msg_t{Info: Info_t{Ts: ts, LevelName: "ERROR"}, Format: "error %v", Args: []any{i}})
+	}
+	q.LogWrite(Msg_t{Info: Info_t{Ts: ts, LevelName: "INFO"}, Format: "other"})
+	q.LogWrite(Msg_t{Info: Info_t{Ts: ts.Add(time.Second), LevelName: "ERROR"}, Format: "error %v", Args: []any{5}})
+	for i := 6; i < 9; i++ {
+		q.LogWrite(Msg_t{Info: Info_t{Ts: ts.Add(1500 * time.Millisecond), LevelName: "ERROR"}, Format: "error %v", Args: []any{i}})
+	}
+	q.Close()
+
+	assert.Assert(t, buf.String() == "ERROR error 0\nERROR error 1\nINFO other\nERROR message repeated 3 times: error 4\nERROR error 5\nERROR error 6\nERROR message repeated 2 times: error 8\n", buf.String())
+	assert.Assert(t, q.Size().QueueSampled == 5, q.Size())
+}
+
+func TestWith(t *testing.T) {
+	m := NewLevelMap()
+
+	var buf1, buf2 bytes.Buffer
+	m.AddOutputs("buf1", NewWriterStdany(nil, &buf1, 0), WhatLevel(LOG_TRACE.LevelId))
+	m.AddOutputs("buf2", NewWriterStdany(nil, &buf2, 0, WriteMessage(NewJson(JsonLayout("")))), WhatLevel(LOG_TRACE.LevelId))
+
+	logger := New(m)
+	child1 := logger.With("request_id", 1)
+	child2 := child1.With("user", "root", "msg", true)
+	child3 := child1.With("user", "guest")
+
+	logger.Info("test")
+	child2.Info("test")
+	child3.Info("test")
+
+	assert.Assert(t, buf1.String() == "INFO test\nINFO test request_id=1 user=root msg=true\nINFO test request_id=1 user=guest\n", buf1.String())
+	assert.Assert(t, strings.Contains(buf2.String(), `"msg":"test","request_id":1,"user":"root","fields.msg":true}`), buf2.String())
+
+	buf1.Reset()
+	MessageKB_t{}.FormatMessage(&buf1, Msg_t{Format: "test", Fields: []Field_t{{Key: "pod", Value: "app-1"}, {Key: "Level", Value: 1}}})
+	assert.Assert(t, strings.HasSuffix(buf1.String(), `"Message":"test","pod":"app-1","fields.Level":1}`+"\n"), buf1.String())
+}
+
+func TestKibanaExtraFields(t *testing.T) {
+	var buf bytes.Buffer
+	kb := MessageKB_t{ExtraFields: map[string]any{"region": "eu", "pod": "app-1", "Level": "x", "token": "secret"}, RedactKeys: []string{"token"}}
+	kb.FormatMessage(&buf, Msg_t{Format: "a"}, Msg_t{Format: "b", Fields: []Field_t{{Key: "pod", Value: "app-2"}, {Key: "user", Value: "root"}}})
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Assert(t, len(lines) == 2, buf.String())
+	assert.Assert(t, strings.HasSuffix(lines[0], `"Message":"a","fields.Level":"x","pod":"app-1","region":"eu","token":"***"}`), lines[0])
+	assert.Assert(t, strings.HasSuffix(lines[1], `"Message":"b","fields.Level":"x","pod":"app-1","region":"eu","token":"***","fields.pod":"app-2","user":"root"}`), lines[1])
+	var doc map[string]any
+	assert.NilError(t, json.Unmarshal([]byte(lines[1]), &doc))
+	assert.Equal(t, doc["Level"], "")
+}
+
+func TestContextWithFields(t *testing.T) {
+	m := NewLevelMap()
+
+	var buf bytes.Buffer
+	m.AddOutputs("buf", NewWriterStdany(nil, &buf, 0, WriteMessage(NewLogfmt(""))), WhatLevel(LOG_TRACE.LevelId))
+
+	ctx1 := ContextWithFields(context.Background(), "trace_id", "abc")
+	ctx2 := ContextWithFields(ctx1, "span_id", "def")
+
+	logger := New(m).With("service", "api")
+	logger.InfoCtx(ctx1, "test1")
+	logger.InfoCtx(ctx2, "test2")
+
+	assert.Assert(t, strings.Contains(buf.String(), `msg=test1 service=api trace_id=abc`+"\n"), buf.String())
+	assert.Assert(t, strings.HasSuffix(buf.String(), `msg=test2 service=api trace_id=abc span_id=def`+"\n"), buf.String())
+}
+
+func TestOtel(t *testing.T) {
+	m := NewLevelMap()
+
+	var buf bytes.Buffer
+	m.AddOutputs("buf", NewWriterStdany([]Formatter{NewOtel()}, &buf, 0), WhatLevel(LOG_TRACE.LevelId))
+
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:  trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+	})
+
+	logger := New(m)
+	logger.InfoCtx(trace.ContextWithSpanContext(context.Background(), sc), "test")
+	logger.InfoCtx(context.Background(), "test")
+
+	assert.Assert(t, buf.String() == "trace_id=4bf92f3577b34da6a3ce929d0e0e4736 span_id=00f067aa0ba902b7 INFO test\nINFO test\n", buf.String())
+}
+
+func TestCompressBackups(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "all.log")
+	os.WriteFile(filename+".1.20000101000000.gz", nil, 0644)
+
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	w, err := NewWriterFileBytes(ts, filename, nil, 10, 2, 0, CompressBackups())
+	assert.NilError(t, err)
+	for i := 0; i < 3; i++ {
+		w.LogWrite(Msg_t{Info: Info_t{Ts: ts.Add(time.Duration(i) * time.Second), LevelName: "INFO"}, Format: "message %v", Args: []any{i}})
+	}
+	w.Close()
+
+	files, _ := filepath.Glob(filename + "*")
+	for i := range files {
+		files[i] = filepath.Base(files[i])
+	}
+	assert.Assert(t, strings.Join(files, ",") == "all.log,all.log.1.20240102030406.gz,all.log.2.20240102030407.gz", files)
+
+	f, err := os.Open(filename + ".2.20240102030407.gz")
+	assert.NilError(t, err)
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	assert.NilError(t, err)
+	body, _ := io.ReadAll(r)
+	assert.Assert(t, string(body) == "INFO message 1\n", string(body))
+}
+
+func TestSyncEvery(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "all.log")
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	q, err := NewWriterFileBytes(ts, filename, nil, 1024, 0, 0, SyncEvery(time.Minute))
+	assert.NilError(t, err)
+	w := q.(*WriterFileBytes_t)
+	for i := 0; i < 3; i++ {
+		_, err = w.LogWrite(Msg_t{Info: Info_t{Ts: ts.Add(time.Duration(i) * time.Second), LevelName: "INFO"}, Format: "message %v", Args: []any{i}})
+		assert.NilError(t, err)
+	}
+	assert.Assert(t, w.last_sync.Equal(ts), w.last_sync)
+	w.LogWrite(Msg_t{Info: Info_t{Ts: ts.Add(time.Minute), LevelName: "INFO"}, Format: "message"})
+	assert.Assert(t, w.last_sync.Equal(ts.Add(time.Minute)), w.last_sync)
+
+	// sync on closed file is reported as write error
+	w.out.Close()
+	_, err = w.LogWrite(Msg_t{Info: Info_t{Ts: ts.Add(2 * time.Minute), LevelName: "INFO"}, Format: "message"})
+	assert.Assert(t, err != nil)
+	assert.Assert(t, w.Size().WriteErrorCnt > 0, w.Size())
+}
+
+func TestFileMode(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "a", "b", "all.log")
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	w, err := NewWriterFileBytes(ts, filename, nil, 10, 2, 0, FileMode(0600), DirMode(0700), FileGroup(os.Getgid()), CompressBackups())
+	assert.NilError(t, err)
+	w.LogWrite(Msg_t{Info: Info_t{Ts: ts, LevelName: "INFO"}, Format: "message"})
+	w.LogWrite(Msg_t{Info: Info_t{Ts: ts, LevelName: "INFO"}, Format: "message"})
+	w.Close()
+
+	st, err := os.Stat(filepath.Dir(filename))
+	assert.NilError(t, err)
+	assert.Assert(t, st.Mode().Perm() == 0700, st.Mode())
+	files, _ := filepath.Glob(filename + "*")
+	assert.Assert(t, len(files) == 2, files)
+	for _, v := range files {
+		st, err = os.Stat(v)
+		assert.NilError(t, err)
+		assert.Assert(t, st.Mode().Perm() == 0600, v, st.Mode())
+	}
+
+	opts := NewWriterOptions(FileOptions(Args_t{LogFileMode: 0600, LogFileGroup: 1000})...)
+	assert.Assert(t, opts.file_mode == 0600 && opts.file_group == 1000, opts)
+	opts = NewWriterOptions(FileOptions(Args_t{})...)
+	assert.Assert(t, opts.file_group == -1, opts)
+}
+
+func checkRotated(t *testing.T, filename string, count int) {
+	files, _ := filepath.Glob(filename + "*")
+	assert.Assert(t, len(files) > 2, files)
+	lines := map[string]int{}
+	for _, v := range files {
+		body, err := os.ReadFile(v)
+		assert.NilError(t, err)
+		assert.Assert(t, len(body) > 0 && body[len(body)-1] == '\n', v)
+		for _, line := range strings.Split(strings.TrimSuffix(string(body), "\n"), "\n") {
+			assert.Assert(t, strings.HasPrefix(line, "INFO message "), v, line)
+			lines[line]++
+		}
+	}
+	assert.Assert(t, len(lines) == count, len(lines))
+	for k, v := range lines {
+		assert.Assert(t, v == 1, k, v)
+	}
+}
+
+func TestRotateStress(t *testing.T) {
+	const goroutines, messages = 16, 500
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for _, name := range []string{"bytes", "time"} {
+		filename := filepath.Join(t.TempDir(), "all.log")
+		var q Queue
+		var err error
+		if name == "bytes" {
+			q, err = NewWriterFileBytesQueue(goroutines*messages, 4, ts, filename, nil, 4096, 1000, 0)
+		} else {
+			q, err = NewWriterFileTimeQueue(goroutines*messages, 4, ts, filename, nil, time.Second, 1000, 0)
+		}
+		assert.NilError(t, err)
+
+		var wg sync.WaitGroup
+		for i := 0; i < goroutines; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < messages; j++ {
+					q.LogWrite(Msg_t{Info: Info_t{Ts: ts.Add(time.Duration(j/50) * time.Second), LevelName: "INFO"}, Format: "message %v-%v", Args: []any{i, j}})
+				}
+			}(i)
+		}
+		wg.Wait()
+		q.Close()
+		assert.Assert(t, q.Size().WriteErrorCnt == 0, name, q.Size())
+		checkRotated(t, filename, goroutines*messages)
+	}
+}
+
+func TestRotateBeforeWrite(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "all.log")
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	w, err := NewWriterFileBytes(ts, filename, nil, 32, 10, 0)
+	assert.NilError(t, err)
+	// 15 bytes, 15 bytes, 46 bytes, 15 bytes
+	w.LogWrite(Msg_t{Info: Info_t{Ts: ts, LevelName: "INFO"}, Format: "message 1"})
+	w.LogWrite(Msg_t{Info: Info_t{Ts: ts, LevelName: "INFO"}, Format: "message 2"})
+	w.LogWrite(Msg_t{Info: Info_t{Ts: ts.Add(time.Second), LevelName: "INFO"}, Format: "message %s", Args: []any{strings.Repeat("x", 32)}})
+	w.LogWrite(Msg_t{Info: Info_t{Ts: ts.Add(2 * time.Second), LevelName: "INFO"}, Format: "message 3"})
+	w.Close()
+
+	read := func(name string) string {
+		body, _ := os.ReadFile(name)
+		return string(body)
+	}
+	assert.Assert(t, read(filename+".1.20240102030406") == "INFO message 1\nINFO message 2\n")
+	assert.Assert(t, read(filename+".2.20240102030407") == "INFO message "+strings.Repeat("x", 32)+"\n")
+	assert.Assert(t, read(filename) == "INFO message 3\n")
+	files, _ := filepath.Glob(filename + "*")
+	assert.Assert(t, len(files) == 3, files)
+}
+
+func TestAlignTo(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	filename := filepath.Join(t.TempDir(), "all.log")
+	// spring forward 2024-03-10, fall back 2024-11-03
+	for _, start := range []time.Time{time.Date(2024, 3, 9, 0, 30, 0, 0, loc), time.Date(2024, 11, 2, 0, 30, 0, 0, loc)} {
+		q, err := NewWriterFileTime(start, filename, nil, time.Hour, 0, 0, AlignTo(24*time.Hour, loc))
+		assert.NilError(t, err)
+		w := q.(*WriterFileTime_t)
+		days := map[string]int{}
+		end := time.Date(start.Year(), start.Month(), start.Day()+3, 0, 0, 0, 0, loc)
+		for ts := start; ts.Before(end); ts = ts.Add(30 * time.Minute) {
+			w.LogWrite(Msg_t{Info: Info_t{Ts: ts, LevelName: "INFO"}, Format: "message"})
+			days[w.last_date.Format(time.RFC3339)]++
+		}
+		w.Close()
+		assert.Assert(t, len(days) == 3, days)
+		assert.Assert(t, w.cycle == 2, w.cycle)
+		for k := range days {
+			ts, _ := time.Parse(time.RFC3339, k)
+			assert.Assert(t, ts.In(loc).Hour() == 0 && ts.In(loc).Minute() == 0, k)
+		}
+	}
+
+	w := &WriterFileTime_t{WriterOptions_t: NewWriterOptions(AlignTo(6*time.Hour, loc))}
+	// 07:30 EDT after spring forward is in 06:00 period
+	assert.Assert(t, w.__period(time.Date(2024, 3, 10, 7, 30, 0, 0, loc)).Equal(time.Date(2024, 3, 10, 6, 0, 0, 0, loc)))
+	w = &WriterFileTime_t{WriterOptions_t: NewWriterOptions(AlignTo(2*24*time.Hour, time.UTC))}
+	assert.Assert(t, w.__period(time.Date(1970, 1, 4, 5, 0, 0, 0, time.UTC)).Equal(time.Date(1970, 1, 3, 0, 0, 0, 0, time.UTC)))
+}
+
+func TestAsyncFlush(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "all.log")
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	out, err := NewWriterFileBytesQueue(1000, 4, ts, filename, nil, 1<<20, 0, 0)
+	assert.NilError(t, err)
+	q := NewAsync(out, 64, AsyncInterval(time.Hour))
+
+	for n := 1; n <= 2; n++ {
+		for i := 0; i < 100; i++ {
+			q.LogWrite(Msg_t{Info: Info_t{Ts: ts, LevelName: "INFO"}, Format: "message %v", Args: []any{i}})
+		}
+		assert.NilError(t, Flush(q))
+		body, _ := os.ReadFile(filename)
+		assert.Assert(t, strings.Count(string(body), "\n") == n*100, n, len(body))
+	}
+	assert.Assert(t, q.Size().QueueWrite == 200, q.Size())
+	q.Close()
+	assert.NilError(t, q.Close())
+
+	// zero interval is default
+	mem := NewMemory()
+	q = NewAsync(mem, 64, AsyncInterval(0))
+	assert.Assert(t, q.(*Async_t).interval == ASYNC_INTERVAL)
+	q.LogWrite(Msg_t{Info: Info_t{Ts: ts, LevelName: "INFO"}, Format: "message"})
+	q.Close()
+	assert.Assert(t, len(mem.Messages()) == 1, mem.Messages())
+}
+
+func TestCloseLossless(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "all.log")
+	out, err := NewWriterFileBytesQueue(10000, 4, time.Now(), filename, nil, 1<<30, 0, 0)
+	assert.NilError(t, err)
+	logger := New(NewLevelMap().AddOutputs("file", out, WhatLevel(0)))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				logger.Info("message %v-%v", i, j)
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.NilError(t, logger.Close())
+
+	body, err := os.ReadFile(filename)
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Count(string(body), "\n") == 10000, out.Size())
+	// closed logger has no outputs
+	logger.Info("after close")
+	assert.Assert(t, out.Size().QueueWrite == 10000, out.Size())
+}
+
+type closed_test_t struct {
+	closed atomic.Bool
+	late   atomic.Int64
+}
+
+func (self *closed_test_t) LogWrite(m Msg_t) (int, error) {
+	if self.closed.Load() {
+		self.late.Add(1)
+	}
+	return 0, nil
+}
+
+func (self *closed_test_t) Size() QueueSize_t {
+	return QueueSize_t{}
+}
+
+func (self *closed_test_t) Close() error {
+	self.closed.Store(true)
+	return nil
+}
+
+func TestCloseWhileLogging(t *testing.T) {
+	out := &closed_test_t{}
+	logger := New(NewLevelMap().AddOutputs("out", out, WhatLevel(0)))
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					logger.Info("message")
+				}
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	// Log calls never stop, old map is returned when calls in progress with it are done
+	outputs := []*closed_test_t{out}
+	for i := 0; i < 10; i++ {
+		next := &closed_test_t{}
+		outputs = append(outputs, next)
+		logger.SwapLevelMap(NewLevelMap().AddOutputs("out", next, WhatLevel(0))).Close()
+	}
+	assert.NilError(t, logger.Close())
+	close(done)
+	wg.Wait()
+	for _, v := range outputs {
+		assert.Assert(t, v.closed.Load() && v.late.Load() == 0, v.late.Load())
+	}
+}
+
+type blocked_test_t struct {
+	*Memory_t
+	entered chan struct{}
+	block   chan struct{}
+}
+
+func (self *blocked_test_t) LogWrite(m Msg_t) (int, error) {
+	close(self.entered)
+	<-self.block
+	return self.Memory_t.LogWrite(m)
+}
+
+func TestSwapBlockedOutput(t *testing.T) {
+	out := &blocked_test_t{Memory_t: NewMemory(), entered: make(chan struct{}), block: make(chan struct{})}
+	logger := New(NewLevelMap().AddOutputs("out", out, WhatLevel(0)))
+	go logger.Info("blocked")
+	<-out.entered
+
+	mem := NewMemory()
+	swapped := make(chan Level_map_t)
+	go func() {
+		swapped <- logger.SwapLevelMap(NewLevelMap().AddOutputs("mem", mem, WhatLevel(0)))
+	}()
+	for _, ok := logger.CopyLevelMap().GetOutput("mem"); !ok; _, ok = logger.CopyLevelMap().GetOutput("mem") {
+		time.Sleep(time.Millisecond)
+	}
+	// Log with current generation is not stalled by retire of old one
+	logger.Info("after")
+	assert.Assert(t, len(mem.Messages()) == 1, mem.Messages())
+	select {
+	case <-swapped:
+		t.Fatal("old generation retired with Log call in progress")
+	default:
+	}
+	close(out.block)
+	<-swapped
+	assert.Assert(t, len(out.Messages()) == 1, out.Messages())
+}
+
+func TestCloseContext(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	stuck := NewQueue(10)
+	stuck.WgAdd(1)
+	go func() {
+		defer stuck.WgDone()
+		stuck.LogRead(1)
+		<-block
+	}()
+	filename := filepath.Join(t.TempDir(), "all.log")
+	out, err := NewWriterFileBytesQueue(10, 1, time.Now(), filename, nil, 1<<20, 0, 0)
+	assert.NilError(t, err)
+	logger := New(NewLevelMap().AddOutputs("stuck", stuck, WhatLevel(0)).AddOutputs("file", out, WhatLevel(0)))
+	for i := 0; i < 5; i++ {
+		logger.Info("message %v", i)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = logger.CloseContext(ctx)
+	var close_err *CloseError_t
+	assert.Assert(t, errors.As(err, &close_err), err)
+	assert.Assert(t, errors.Is(err, context.DeadlineExceeded), err)
+	assert.DeepEqual(t, close_err.Outputs, []string{"stuck"})
+	assert.Assert(t, stuck.Size().QueueDrop+stuck.Size().QueueRead == 5, stuck.Size())
+
+	body, _ := os.ReadFile(filename)
+	assert.Assert(t, strings.Count(string(body), "\n") == 5, string(body))
+}
+
+// worker reads one message and blocks until block is closed
+func stuckQueue(block chan struct{}) *Queue_t {
+	q := NewQueue(10)
+	q.WgAdd(1)
+	go func() {
+		defer q.WgDone()
+		q.LogRead(1)
+		<-block
+	}()
+	return q
+}
+
+func TestWrapperCloseContext(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	for name, wrap := range map[string]func(Queue) Queue{
+		"async":         func(q Queue) Queue { return NewAsync(q, 10) },
+		"dedup":         func(q Queue) Queue { return NewDedup(q, time.Minute) },
+		"sampler":       func(q Queue) Queue { return NewSampler(q, 10, time.Minute) },
+		"level_sampler": func(q Queue) Queue { return NewLevelSampler(q, nil) },
+		"filter":        func(q Queue) Queue { return NewFilter(q, func(Msg_t) bool { return true }) },
+		"tee":           func(q Queue) Queue { return NewTee(NewMemory(), q) },
+		"rollup":        func(q Queue) Queue { return NewRollup(q, time.Minute) },
+		"ring":          func(q Queue) Queue { return NewRing(16, q) },
+	} {
+		stuck := stuckQueue(block)
+		q := wrap(stuck)
+		for i := 0; i < 3; i++ {
+			q.LogWrite(Msg_t{Info: Info_t{Ts: time.Now(), LevelId: 2}, Format: "message %v", Args: []any{i}})
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		err := CloseQueue(ctx, q)
+		cancel()
+		assert.Assert(t, errors.Is(err, context.DeadlineExceeded), name, err)
+		// queued messages of wrapped queue are dropped by its CloseContext
+		size := stuck.Size()
+		assert.Assert(t, size.QueueWrite > 0 && size.Size == 0 && size.QueueDrop+size.QueueRead == size.QueueWrite, name, size)
+	}
+}
+
+type reopen_test_t struct {
+	*Memory_t
+	reopened int
+}
+
+func (self *reopen_test_t) Reopen() error {
+	self.reopened++
+	return nil
+}
+
+func TestWrapperReopen(t *testing.T) {
+	for name, wrap := range map[string]func(Queue) Queue{
+		"async":         func(q Queue) Queue { return NewAsync(q, 10) },
+		"dedup":         func(q Queue) Queue { return NewDedup(q, time.Minute) },
+		"sampler":       func(q Queue) Queue { return NewSampler(q, 10, time.Minute) },
+		"level_sampler": func(q Queue) Queue { return NewLevelSampler(q, nil) },
+		"filter":        func(q Queue) Queue { return NewFilter(q, func(Msg_t) bool { return true }) },
+		"rollup":        func(q Queue) Queue { return NewRollup(q, time.Minute) },
+		"ring":          func(q Queue) Queue { return NewRing(16, q) },
+		"no_caller":     NoCaller,
+		"goroutine_id":  WithGoroutineId,
+	} {
+		r := &reopen_test_t{Memory_t: NewMemory()}
+		q := wrap(r)
+		assert.NilError(t, NewLevelMap().AddOutputs("out", q, WhatLevel(0)).Reopen())
+		assert.Assert(t, r.reopened == 1, name)
+		q.Close()
+	}
+
+	r1, r2 := &reopen_test_t{Memory_t: NewMemory()}, &reopen_test_t{Memory_t: NewMemory()}
+	assert.NilError(t, Reopen(NewTee(r1, r2)))
+	assert.Assert(t, r1.reopened == 1 && r2.reopened == 1)
+}
+
+func TestHttpCloseContext(t *testing.T) {
+	cancelled := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		<-r.Context().Done()
+		close(cancelled)
+	}))
+	defer srv.Close()
+
+	q := NewHttpQueue(10, 1, NewUrls(srv.URL), MessageKB_t{}, srv.Client(), RetryPolicy(10, time.Hour, time.Hour), HttpOnError(func(error) {}))
+	q.LogWrite(Msg_t{Format: "message 1"})
+	q.LogWrite(Msg_t{Format: "message 2"})
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.Assert(t, errors.Is(CloseQueue(ctx, q), context.DeadlineExceeded))
+	<-cancelled
+	// worker exits, both messages are dropped once
+	q.Close()
+	assert.Assert(t, time.Since(start) < time.Second)
+	assert.Assert(t, q.Size().QueueDrop == 2 && q.Size().WriteErrorCnt == 0, q.Size())
+}
+
+func TestErrorHandler(t *testing.T) {
+	var global, local []error
+	SetErrorHandler(func(err error) { global = append(global, err) })
+	defer SetErrorHandler(nil)
+
+	filename := filepath.Join(t.TempDir(), "all.log")
+	ts := time.Now()
+	w1, err := NewWriterFileBytes(ts, filename+".1", nil, 1<<20, 0, 0)
+	assert.NilError(t, err)
+	w2, err := NewWriterFileBytes(ts, filename+".2", nil, 1<<20, 0, 0, OnError(func(err error) { local = append(local, err) }))
+	assert.NilError(t, err)
+	w1.(*WriterFileBytes_t).out.Close()
+	w2.(*WriterFileBytes_t).out.Close()
+	w1.LogWrite(Msg_t{Info: Info_t{Ts: ts, LevelName: "INFO"}, Format: "message"})
+	w2.LogWrite(Msg_t{Info: Info_t{Ts: ts, LevelName: "INFO"}, Format: "message"})
+	assert.Assert(t, len(global) == 1 && len(local) == 1, global, local)
+
+	defer SetLogger(GetLogger())
+	SetupLogger(ts, []Args_t{{LogType: "file", LogFile: filepath.Join(filename, "\x00")}}, nil)
+	assert.Assert(t, len(global) == 2, global)
+}
+
+func TestReloadLogger(t *testing.T) {
+	dir := t.TempDir()
+	file1 := filepath.Join(dir, "file1.log")
+	file2 := filepath.Join(dir, "file2.log")
+	defer SetLogger(GetLogger())
+
+	ts := time.Now()
+	logger, err := SetupLogger(ts, []Args_t{
+		{LogType: "file", LogFile: file1, LogLevel: LOG_INFO.LevelId, LogSize: 1 << 20},
+		{LogType: "file", LogFile: file2, LogLevel: LOG_INFO.LevelId, LogSize: 1 << 20},
+	}, nil)
+	assert.NilError(t, err)
+	// added by user, not changed by ReloadLogger
+	user := &closed_test_t{}
+	assert.NilError(t, logger.UpdateLevelMap(func(m Level_map_t) error {
+		return m.AddOutputsUnique("user", user, WhatLevel(LOG_INFO.LevelId))
+	}))
+	before, _ := logger.CopyLevelMap().GetOutput(file1)
+	logger.Debug("test1")
+	logger.Info("test2")
+
+	_, err = ReloadLogger(ts, []Args_t{
+		{LogType: "file", LogFile: file1, LogLevel: LOG_DEBUG.LevelId, LogSize: 1 << 20},
+		{LogType: "file", LogFile: file2, LogLevel: LOG_INFO.LevelId, LogSize: 1 << 21},
+		{LogType: "stdout", LogLevel: LOG_ERROR.LevelId},
+	}, nil)
+	assert.NilError(t, err)
+	after, _ := logger.CopyLevelMap().GetOutput(file1)
+	assert.Assert(t, before == after)
+	logger.Debug("test3")
+	logger.Info("test4")
+	assert.Assert(t, !user.closed.Load())
+
+	names, _ := logger.CopyLevelMap().Outputs()
+	assert.DeepEqual(t, names, []string{file1, file2, "stdout", "user"})
+	assert.NilError(t, logger.Close())
+	assert.Assert(t, user.closed.Load())
+
+	buf1, err := os.ReadFile(file1)
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Count(string(buf1), "\n") == 3 && strings.Contains(string(buf1), "DEBUG test3"), string(buf1))
+	// replaced with new LogSize, old file is closed before new one is opened
+	buf2, err := os.ReadFile(file2)
+	assert.NilError(t, err)
+	assert.Assert(t, strings.HasSuffix(string(buf2), "INFO test4\n") && !strings.Contains(string(buf2), "test3"), string(buf2))
+}
+
+func TestValidateArgs(t *testing.T) {
+	assert.NilError(t, ValidateArgs([]Args_t{
+		{LogType: "stdout"},
+		{LogType: "file", LogFile: "test.log", LogSize: 1024},
+		{LogType: "filetimequeue", LogFile: "test.log", LogDuration: time.Hour, LogQueue: 10, LogWriters: 1},
+	}))
+
+	err := ValidateArgs([]Args_t{
+		{LogType: "unknown"},
+		{LogType: "stdout"},
+		{LogType: "file", LogSize: 0},
+		{LogType: "filetime", LogFile: "test.log"},
+		{LogType: "stdoutqueue", LogQueue: 1, LogWriters: 2},
+	})
+	var errs []*ArgsError_t
+	for _, v := range err.(interface{ Unwrap() []error }).Unwrap() {
+		var temp *ArgsError_t
+		assert.Assert(t, errors.As(v, &temp), v)
+		errs = append(errs, temp)
+	}
+	assert.Assert(t, len(errs) == 5, err)
+	assert.Assert(t, errs[0].Index == 0 && errs[1].Index == 2 && errs[2].Index == 2 && errs[3].Index == 3 && errs[4].Index == 4, err)
+	assert.Assert(t, strings.Contains(err.Error(), "LogWriters=2 > LogQueue=1"), err)
+}
+
+func TestEnvLevels(t *testing.T) {
+	logs := []Args_t{
+		{LogType: "stdout", LogLevel: LOG_ERROR.LevelId},
+		{LogType: "file", LogFile: "/var/log/app.log", LogLevel: LOG_ERROR.LevelId},
+	}
+
+	res, err := EnvLevels(logs)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, res, logs)
+
+	t.Setenv("LOG_LEVEL", "debug")
+	t.Setenv("LOG_LEVEL__VAR_LOG_APP_LOG", "Warn")
+	res, err = EnvLevels(logs)
+	assert.NilError(t, err)
+	assert.Assert(t, res[0].LogLevel == LOG_DEBUG.LevelId && res[1].LogLevel == LOG_WARN.LevelId, res)
+	assert.Assert(t, logs[0].LogLevel == LOG_ERROR.LevelId)
+
+	t.Setenv("LOG_LEVEL_STDOUT", "verbose")
+	_, err = EnvLevels(logs)
+	assert.ErrorContains(t, err, "LOG_LEVEL_STDOUT=verbose: unknown level")
+}
+
+func TestArgsJson(t *testing.T) {
+	var logs []Args_t
+	err := json.Unmarshal([]byte(`[{"LogType":"filetime","LogFile":"app.log","LogLevel":2,"LogDuration":"24h","LogMaxAge":1000000000}]`), &logs)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, logs, []Args_t{{LogType: "filetime", LogFile: "app.log", LogLevel: 2, LogDuration: 24 * time.Hour, LogMaxAge: time.Second}})
+
+	buf, err := json.Marshal(logs[0])
+	assert.NilError(t, err)
+	assert.Assert(t, strings.Contains(string(buf), `"LogDuration":"24h0m0s"`), string(buf))
+	var temp Args_t
+	assert.NilError(t, json.Unmarshal(buf, &temp))
+	assert.DeepEqual(t, temp, logs[0])
+
+	assert.Assert(t, json.Unmarshal([]byte(`{"LogDuration":"1 day"}`), &temp) != nil)
+}
+
+func TestRegisterLevel(t *testing.T) {
+	defer func(levels []Info_t) { __levels = levels }(__levels)
+	assert.DeepEqual(t, WhatLevel(3), []Info_t{LOG_ERROR, LOG_WARN})
+	assert.DeepEqual(t, WhatLevel(5), WhatLevel(0))
+	security := RegisterLevel("SECURITY", 10)
+	assert.DeepEqual(t, WhatLevel(3), []Info_t{security, LOG_ERROR, LOG_WARN})
+	assert.DeepEqual(t, WhatLevel(5), []Info_t{security})
+
+	var app, audit bytes.Buffer
+	logger := New(NewLevelMap().
+		AddOutputs("app", NewWriterStdany(nil, &app, 0), WhatLevel(2)).
+		AddOutputs("audit", NewWriterStdany(nil, &audit, 0), []Info_t{security}))
+	logger.Log(context.Background(), security, "login %v", "user")
+	logger.Debug("debug")
+	logger.Info("info")
+	assert.Assert(t, app.String() == "SECURITY login user\nINFO info\n", app.String())
+	assert.Assert(t, audit.String() == "SECURITY login user\n", audit.String())
+}
+
+type client_test_t struct {
+	mx   sync.Mutex
+	hits map[string]int
+}
+
+func (self *client_test_t) Do(req *http.Request) (*http.Response, error) {
+	self.mx.Lock()
+	self.hits[req.URL.Host]++
+	self.mx.Unlock()
+	if req.URL.Host == "bad" {
+		return nil, errors.New("connection refused")
+	}
+	return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Body: io.NopCloser(strings.NewReader(""))}, nil
+}
+
+func TestUrlsFailover(t *testing.T) {
+	urls := NewUrls("http://a", "http://b", "http://c")
+	assert.DeepEqual(t, urls.Range(), []string{"http://a", "http://b", "http://c"})
+	assert.DeepEqual(t, urls.Range(), []string{"http://b", "http://c", "http://a"})
+
+	client := &client_test_t{hits: map[string]int{}}
+	q := NewHttpQueue(10, 1, NewUrlsFailover(1, time.Hour, "http://bad", "http://good"), MessageKB_t{}, client)
+	for i := 0; i < 4; i++ {
+		q.LogWrite(Msg_t{Format: "test"})
+		Flush(q)
+	}
+	q.Close()
+	assert.Assert(t, client.hits["bad"] == 1 && client.hits["good"] == 4, client.hits)
+	assert.Assert(t, q.Size().WriteErrorCnt == 0, q.Size())
+
+	// all down, still tried
+	urls = NewUrlsFailover(1, time.Hour, "http://a", "http://b")
+	urls.Failure("http://a", time.Now())
+	urls.Failure("http://b", time.Now())
+	assert.DeepEqual(t, urls.Range(), []string{"http://a", "http://b"})
+	urls.Success("http://a")
+	assert.DeepEqual(t, urls.Range(), []string{"http://a", "http://b"})
+}
+
+func TestAuthProvider(t *testing.T) {
+	var mx sync.Mutex
+	var calls int
+	var auth []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mx.Lock()
+		auth = append(auth, r.Header.Get("Authorization"))
+		mx.Unlock()
+		if r.Header.Get("Authorization") == "Bearer 1" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+	provider := func(ctx context.Context) (string, error) {
+		mx.Lock()
+		defer mx.Unlock()
+		if calls++; calls == 1 {
+			return "", errors.New("token service unavailable")
+		}
+		return fmt.Sprintf("Bearer %d", calls-1), nil
+	}
+	q := NewHttpQueue(10, 1, NewUrls(srv.URL), MessageKB_t{}, srv.Client(), AuthProvider(provider, time.Hour), RetryPolicy(1, time.Millisecond, time.Millisecond), HttpOnError(func(error) {}))
+	for i := 0; i < 3; i++ {
+		q.LogWrite(Msg_t{Format: "test"})
+		Flush(q)
+	}
+	q.Close()
+	// provider error is retried, 401 invalidates cached token and request is sent again with new one
+	assert.DeepEqual(t, auth, []string{"Bearer 1", "Bearer 2", "Bearer 2", "Bearer 2"})
+	assert.Assert(t, q.Size().QueueRetry == 1 && q.Size().WriteErrorCnt == 0, q.Size())
+}
+
+func TestHec(t *testing.T) {
+	var path, auth string
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, auth = r.URL.Path, r.Header.Get("Authorization")
+		temp, _ := io.ReadAll(r.Body)
+		body = append(body, temp...)
+	}))
+	defer srv.Close()
+
+	q := NewHec(10, 1, srv.URL, "secret", MessageHEC_t{Host: "host1", SourceType: "_json"}, srv.Client(), BulkWrite(2))
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.UTC)
+	q.LogWrite(Msg_t{Info: Info_t{Ts: ts, LevelName: "INFO", File: "main.go", Line: 1}, Format: "message %v", Args: []any{1}, Fields: []Field_t{{"user", 42}}})
+	q.LogWrite(Msg_t{Info: Info_t{Ts: ts, LevelName: "WARN", File: "main.go", Line: 2}, Format: "json", Args: []any{map[string]int{"a": 1}}})
+	q.Close()
+
+	assert.Assert(t, path == "/services/collector/event" && auth == "Splunk secret", path, auth)
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	assert.Assert(t, len(lines) == 2, string(body))
+	assert.Assert(t, lines[0] == `{"time":1704164645.123,"host":"host1","sourcetype":"_json","event":"message 1","fields":{"level":"INFO","location":"main.go:1 ","user":"42"}}`, lines[0])
+	assert.Assert(t, lines[1] == `{"time":1704164645.123,"host":"host1","sourcetype":"_json","event":[{"a":1}],"fields":{"level":"WARN","location":"main.go:2 "}}`, lines[1])
+}
+
+func TestLoki(t *testing.T) {
+	var path, content string
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, content = r.URL.Path, r.Header.Get("Content-Type")
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.UTC)
+	in := []Msg_t{
+		{Info: Info_t{Ts: ts, LevelName: "INFO", File: "main.go", Line: 1}, Format: "message %v", Args: []any{1}, Fields: []Field_t{{"user", "a b"}}},
+		{Info: Info_t{Ts: ts.Add(time.Nanosecond), LevelName: "ERROR", File: "main.go", Line: 2}, Format: "message 2"},
+		{Info: Info_t{Ts: ts.Add(2 * time.Nanosecond), LevelName: "INFO", File: "main.go", Line: 3}, Format: "message 3"},
+	}
+	var buf bytes.Buffer
+	_, err := MessageLoki_t{Labels: map[string]string{"job": "app"}}.FormatMessage(&buf, in...)
+	assert.NilError(t, err)
+	assert.Assert(t, buf.String() == `{"streams":[`+
+		`{"stream":{"job":"app","level":"INFO"},"values":[["1704164645123456789","main.go:1 message 1 user=\"a b\""],["1704164645123456791","main.go:3 message 3"]]},`+
+		`{"stream":{"job":"app","level":"ERROR"},"values":[["1704164645123456790","main.go:2 message 2"]]}]}`, buf.String())
+
+	q := NewLoki(10, 1, srv.URL, MessageLoki_t{}, srv.Client())
+	q.LogWrite(in[0])
+	q.Close()
+	assert.Assert(t, path == "/loki/api/v1/push" && content == "application/json", path, content)
+	assert.Assert(t, strings.HasPrefix(string(body), `{"streams":[{"stream":{"level":"INFO"}`), string(body))
+	assert.Assert(t, q.Size().WriteErrorCnt == 0, q.Size())
+}
+
+func TestMaxBytes(t *testing.T) {
+	var mx sync.Mutex
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mx.Lock()
+		bodies = append(bodies, string(body))
+		mx.Unlock()
+	}))
+	defer srv.Close()
+
+	q := NewHttpQueue(100, 1, NewUrls(srv.URL), NewTextMessage(), srv.Client(), BulkWrite(100), MaxBytes(32))
+	for i := 0; i < 10; i++ {
+		q.LogWrite(Msg_t{Info: Info_t{LevelName: "INFO"}, Format: "message %v", Args: []any{i}})
+	}
+	q.LogWrite(Msg_t{Info: Info_t{LevelName: "INFO"}, Format: strings.Repeat("x", 40)})
+	q.LogWrite(Msg_t{Info: Info_t{LevelName: "INFO"}, Format: "message 10"})
+	q.Close()
+
+	var lines int
+	for _, v := range bodies {
+		n := strings.Count(v, "\n")
+		assert.Assert(t, len(v) <= 32 || n == 1, v)
+		assert.Assert(t, strings.HasSuffix(v, "\n"), v)
+		lines += n
+	}
+	assert.Assert(t, lines == 12, bodies)
+	assert.Assert(t, q.Size().WriteErrorCnt == 0, q.Size())
+}
+
+type capture_test_t struct {
+	mx  sync.Mutex
+	msg []Msg_t
+}
+
+func (self *capture_test_t) LogWrite(m Msg_t) (int, error) {
+	self.mx.Lock()
+	self.msg = append(self.msg, m)
+	self.mx.Unlock()
+	return 0, nil
+}
+
+func (self *capture_test_t) Size() QueueSize_t {
+	return QueueSize_t{}
+}
+
+func (self *capture_test_t) Close() error {
+	return nil
+}
+
+func BenchmarkCaller(b *testing.B) {
+	c := &capture_test_t{}
+	for _, v := range []struct {
+		name   string
+		logger Logger
+	}{
+		{"caller", New(NewLevelMap().AddOutputs("c", c, WhatLevel(0)))},
+		{"no_caller", New(NewLevelMap().AddOutputs("c", NoCaller(c), WhatLevel(0)))},
+	} {
+		b.Run(v.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				v.logger.Info("message")
+				c.msg = c.msg[:0]
+			}
+		})
+	}
+}
+
+func BenchmarkDisabledLevel(b *testing.B) {
+	logger := New(NewLevelMap().AddOutputs("c", &capture_test_t{}, WhatLevel(2)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		logger.Debug("message")
+	}
+}
+
+func TestEnabled(t *testing.T) {
+	logger := New(NewLevelMap().AddOutputs("c", &capture_test_t{}, WhatLevel(2)))
+	assert.Assert(t, logger.Enabled(LOG_INFO) && logger.Enabled(LOG_ERROR))
+	assert.Assert(t, !logger.Enabled(LOG_DEBUG) && !logger.Enabled(LOG_TRACE))
+	h := NewSlogHandler(logger)
+	assert.Assert(t, h.Enabled(context.Background(), slog.LevelInfo) && !h.Enabled(context.Background(), slog.LevelDebug))
+
+	var i int
+	allocs := testing.AllocsPerRun(100, func() {
+		if logger.Enabled(LOG_DEBUG) {
+			logger.Debug("message %v", i)
+		}
+		i++
+	})
+	assert.Assert(t, allocs == 0, allocs)
+}
+
+func BenchmarkEnabled(b *testing.B) {
+	logger := New(NewLevelMap().AddOutputs("c", &capture_test_t{}, WhatLevel(2)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if logger.Enabled(LOG_DEBUG) {
+			logger.Debug("message %v %v", i, b.N)
+		}
+	}
+}
+
+func BenchmarkFormatKB(b *testing.B) {
+	m := Msg_t{Info: Info_t{Ts: time.Now(), LevelName: "INFO", File: "main.go", Line: 1}, Format: "message %v %v", Args: []any{1, "a"}, Fields: []Field_t{{"user", 42}}}
+	j := Msg_t{Info: m.Info, Format: "json", Args: []any{map[string]int{"a": 1}}}
+	kb := MessageKB_t{ApplicationName: "app", Environment: "dev"}
+	js := NewJson()
+	b.Run("kb", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			kb.FormatMessage(io.Discard, m, j)
+		}
+	})
+	b.Run("json", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			js.FormatMessage(io.Discard, m, j)
+		}
+	})
+}
+
+func BenchmarkFormatBatch(b *testing.B) {
+	msg := make([]Msg_t, 64)
+	for i := range msg {
+		msg[i] = Msg_t{Info: Info_t{Ts: time.Now(), LevelName: "INFO", File: "main.go", Line: 1}, Format: "message %v", Args: []any{i}, Fields: []Field_t{{"user", 42}}}
+	}
+	for _, v := range []struct {
+		name    string
+		message Formatter
+	}{{"kb", MessageKB_t{ApplicationName: "app"}}, {"hec", MessageHEC_t{}}, {"gelf", MessageGELF_t{Host: "host"}}} {
+		b.Run(v.name+"-single", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				for _, m := range msg {
+					v.message.FormatMessage(io.Discard, m)
+				}
+			}
+		})
+		b.Run(v.name+"-batch", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				v.message.FormatMessage(io.Discard, msg...)
+			}
+		})
+	}
+}
+
+func TestRing(t *testing.T) {
+	mem := NewMemory()
+	q := NewRing(60, mem)
+	assert.Assert(t, q.Size().Limit == 64, q.Size())
+
+	var wg sync.WaitGroup
+	for p := 0; p < 8; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				for {
+					if _, err := q.LogWrite(Msg_t{Format: "%v %v", Args: []any{p, i}}); err == nil {
+						break
+					}
+					runtime.Gosched()
+				}
+			}
+		}(p)
+	}
+	wg.Wait()
+	assert.NilError(t, Flush(q))
+	assert.NilError(t, q.Close())
+
+	next := make([]int, 8)
+	for _, m := range mem.Messages() {
+		p, i := m.Args[0].(int), m.Args[1].(int)
+		assert.Assert(t, next[p] == i, "producer %v: want %v, got %v", p, next[p], i)
+		next[p]++
+	}
+	for p, v := range next {
+		assert.Assert(t, v == 1000, "producer %v: %v", p, v)
+	}
+	_, err := q.LogWrite(Msg_t{})
+	assert.Assert(t, err == ERROR_OVERFLOW, err)
+}
+
+func TestRingClose(t *testing.T) {
+	for n := 0; n < 20; n++ {
+		mem := NewMemory()
+		q := NewRing(1024, mem)
+		var written atomic.Int64
+		var wg sync.WaitGroup
+		for p := 0; p < 8; p++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 200; i++ {
+					if _, err := q.LogWrite(Msg_t{Format: "message"}); err == nil {
+						written.Add(1)
+					}
+				}
+			}()
+		}
+		time.Sleep(time.Duration(n) * 10 * time.Microsecond)
+		assert.NilError(t, q.Close())
+		wg.Wait()
+		// accepted messages are not lost
+		assert.Assert(t, int64(len(mem.Messages())) == written.Load(), "%v %v", len(mem.Messages()), written.Load())
+		assert.NilError(t, q.Close())
+	}
+}
+
+func BenchmarkRing(b *testing.B) {
+	for _, producers := range []int{1, 4, 16} {
+		for _, v := range []struct {
+			name string
+			q    func() Queue
+		}{
+			{"queue", func() Queue { return NewWriterStdanyQueue(1<<16, 1, nil, io.Discard, 0) }},
+			{"ring", func() Queue { return NewRing(1<<16, NewWriterStdany(nil, io.Discard, 0)) }},
+		} {
+			b.Run(fmt.Sprintf("%v-%v", v.name, producers), func(b *testing.B) {
+				q := v.q()
+				b.ReportAllocs()
+				b.ResetTimer()
+				var wg sync.WaitGroup
+				for p := 0; p < producers; p++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						for i := 0; i < b.N/producers; i++ {
+							q.LogWrite(Msg_t{Info: LOG_INFO, Format: "message"})
+						}
+					}()
+				}
+				wg.Wait()
+				q.Close()
+			})
+		}
+	}
+}
+
+func TestRollup(t *testing.T) {
+	mem := NewMemory()
+	q := NewRollup(mem, 50*time.Millisecond)
+	for i := 0; i < 3; i++ {
+		q.LogWrite(Msg_t{Info: LOG_ERROR, Format: "db error %v", Args: []any{i}})
+	}
+	q.LogWrite(Msg_t{Info: LOG_ERROR, Format: "other"})
+	assert.DeepEqual(t, mem.Lines(), []string{"ERROR db error 0", "ERROR other"})
+
+	for start := time.Now(); len(mem.Lines()) < 3 && time.Since(start) < time.Second; {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.DeepEqual(t, mem.Lines()[2:], []string{"ERROR 2 more occurrences of db error 2 in last 50ms"})
+
+	// key expired after period without messages
+	for start := time.Now(); time.Since(start) < 150*time.Millisecond; {
+		time.Sleep(5 * time.Millisecond)
+	}
+	q.LogWrite(Msg_t{Info: LOG_ERROR, Format: "db error %v", Args: []any{3}})
+	q.LogWrite(Msg_t{Info: LOG_ERROR, Format: "db error %v", Args: []any{4}})
+	assert.NilError(t, q.Close())
+	assert.DeepEqual(t, mem.Lines()[3:], []string{"ERROR db error 3", "ERROR 1 more occurrences of db error 4 in last 50ms"})
+	assert.Assert(t, q.Size().QueueSampled == 3, q.Size())
+	assert.NilError(t, q.Close())
+
+	q = NewRollup(NewMemory(), 0)
+	assert.Assert(t, q.(*Rollup_t).period == ROLLUP_PERIOD)
+	q.Close()
+}
+
+func TestDedup(t *testing.T) {
+	var buf bytes.Buffer
+	q := NewDedup(NewWriterStdany(nil, &buf, 0), time.Minute)
+	ts := time.Now()
+	for i := 0; i < 3; i++ {
+		q.LogWrite(Msg_t{Info: Info_t{Ts: ts, LevelName: "INFO", LevelId: 2}, Format: "disk %v full", Args: []any{"sda"}})
+	}
+	q.LogWrite(Msg_t{Info: Info_t{Ts: ts, LevelName: "INFO", LevelId: 2}, Format: "disk %v full", Args: []any{"sdb"}})
+	q.LogWrite(Msg_t{Info: Info_t{Ts: ts, LevelName: "INFO", LevelId: 2}, Format: "disk %v full", Args: []any{"sdb"}})
+	// window elapsed
+	q.LogWrite(Msg_t{Info: Info_t{Ts: ts.Add(time.Minute), LevelName: "INFO", LevelId: 2}, Format: "disk %v full", Args: []any{"sdb"}})
+	q.LogWrite(Msg_t{Info: Info_t{Ts: ts.Add(time.Minute), LevelName: "INFO", LevelId: 2}, Format: "disk %v full", Args: []any{"sdb"}})
+	q.Close()
+	assert.Assert(t, buf.String() == "INFO disk sda full\nINFO disk sda full (repeated 2 times)\n"+
+		"INFO disk sdb full\nINFO disk sdb full (repeated 1 times)\n"+
+		"INFO disk sdb full\nINFO disk sdb full (repeated 1 times)\n", buf.String())
+	assert.Assert(t, q.Size().QueueSampled == 4, q.Size())
+}
+
+func TestDedupWindow(t *testing.T) {
+	mem := NewMemory()
+	q := NewDedup(mem, 50*time.Millisecond)
+	for i := 0; i < 3; i++ {
+		q.LogWrite(Msg_t{Info: Info_t{Ts: time.Now(), LevelName: "INFO", LevelId: 2}, Format: "disk full"})
+	}
+	for i := 0; i < 100 && len(mem.Messages()) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	msg := mem.Messages()
+	assert.Assert(t, len(msg) == 2, len(msg))
+	assert.Assert(t, fmt.Sprintf(msg[1].Format, msg[1].Args...) == "disk full (repeated 2 times)", msg[1])
+	q.Close()
+	assert.Assert(t, len(mem.Messages()) == 2, mem.Messages())
+}
+
+func TestDedupWindowTs(t *testing.T) {
+	mem := NewMemory()
+	q := NewDedup(mem, 50*time.Millisecond)
+	defer q.Close()
+	// rest of window is measured from message Ts, not from wall clock
+	ts := time.Now().Add(time.Hour)
+	q.LogWrite(Msg_t{Info: Info_t{Ts: ts, LevelName: "INFO", LevelId: 2}, Format: "disk full"})
+	q.LogWrite(Msg_t{Info: Info_t{Ts: ts.Add(10 * time.Millisecond), LevelName: "INFO", LevelId: 2}, Format: "disk full"})
+	for i := 0; i < 100 && len(mem.Messages()) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	msg := mem.Messages()
+	assert.Assert(t, len(msg) == 2, len(msg))
+	assert.Assert(t, fmt.Sprintf(msg[1].Format, msg[1].Args...) == "disk full (repeated 1 times)", msg[1])
+}
+
+func TestColor(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	assert.NilError(t, err)
+	defer f.Close()
+	_, ok := NewColor(f).(*TextMessage_t)
+	assert.Assert(t, ok, "not a terminal")
+
+	var buf bytes.Buffer
+	(&ColorMessage_t{}).FormatMessage(&buf, Msg_t{Info: LOG_ERROR, Format: "disk %v", Args: []any{"full"}, Fields: []Field_t{{"a", 1}}})
+	assert.Assert(t, buf.String() == "\x1b[31mERROR\x1b[0m disk full a=1", buf.String())
+}
+
+func TestHostnamePid(t *testing.T) {
+	var buf bytes.Buffer
+	hostname, _ := os.Hostname()
+	w := NewWriterStdany([]Formatter{NewHostname(), NewPid()}, &buf, 0)
+	w.LogWrite(Msg_t{Info: LOG_INFO, Format: "message"})
+	assert.Assert(t, buf.String() == fmt.Sprintf("%s %d INFO message\n", hostname, os.Getpid()), buf.String())
+}
+
+func TestGoroutineId(t *testing.T) {
+	var wg sync.WaitGroup
+	var buf [2]bytes.Buffer
+	for i := range buf {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			NewGoroutineId().FormatMessage(&buf[i], Msg_t{})
+		}(i)
+	}
+	wg.Wait()
+	assert.Assert(t, strings.HasPrefix(buf[0].String(), "gid="), buf[0].String())
+	assert.Assert(t, buf[0].String() != "gid=0 ", buf[0].String())
+	assert.Assert(t, buf[0].String() != buf[1].String(), buf[0].String())
+
+	// queue worker formats messages of both goroutines
+	var out bytes.Buffer
+	q := NewWriterStdanyQueue(10, 1, []Formatter{NewGoroutineId()}, &out, 0)
+	logger := New(NewLevelMap().AddOutputs("q", WithGoroutineId(q), WhatLevel(0)))
+	gids := make([]int64, 2)
+	for i := range gids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			gids[i] = GoroutineId()
+			logger.Info("message %v", i)
+		}(i)
+	}
+	wg.Wait()
+	q.Close()
+	for i, v := range gids {
+		assert.Assert(t, strings.Contains(out.String(), fmt.Sprintf("gid=%v INFO message %v\n", v, i)), out.String())
+	}
+}
+
+func TestLevelSampler(t *testing.T) {
+	var buf bytes.Buffer
+	q := NewLevelSampler(NewWriterStdany(nil, &buf, 0), map[int64]int{LOG_INFO.LevelId: 3})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 30; j++ {
+				q.LogWrite(Msg_t{Info: LOG_INFO, Format: "info"})
+				q.LogWrite(Msg_t{Info: LOG_ERROR, Format: "error"})
+			}
+		}()
+	}
+	wg.Wait()
+	q.Close()
+
+	assert.Assert(t, strings.Count(buf.String(), "ERROR error\n") == 300)
+	assert.Assert(t, strings.Count(buf.String(), "INFO info\n") == 100)
+	assert.Assert(t, q.Size().QueueSampled == 200, q.Size())
+}
+
+type close_error_test_t struct {
+	capture_test_t
+	closed bool
+}
+
+func (self *close_error_test_t) Close() error {
+	self.closed = true
+	return errors.New("close failed")
+}
+
+func TestTee(t *testing.T) {
+	var buf1, buf2 bytes.Buffer
+	c := &close_error_test_t{}
+	q := NewTee(NewWriterStdany(nil, &buf1, 0), c, NewWriterStdany(nil, &buf2, 0))
+	q.LogWrite(Msg_t{Info: LOG_INFO, Format: "message"})
+	err := q.Close()
+
+	assert.Assert(t, buf1.String() == "INFO message\n", buf1.String())
+	assert.Assert(t, buf2.String() == "INFO message\n", buf2.String())
+	assert.Assert(t, len(c.msg) == 1 && c.closed)
+	assert.Assert(t, err != nil && err.Error() == "close failed", err)
+}
+
+type error_writer_t struct{}
+
+type count_stringer_t struct {
+	count *int
+}
+
+func (self count_stringer_t) String() string {
+	*self.count++
+	return strconv.Itoa(*self.count)
+}
+
+func (error_writer_t) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestTeeWriter(t *testing.T) {
+	var buf1, buf2 bytes.Buffer
+	var count int
+	q := NewWriterStdany(nil, NewTeeWriter(&buf1, error_writer_t{}, &buf2), 0, OnError(func(error) {}))
+	_, err := q.LogWrite(Msg_t{Info: LOG_INFO, Format: "message %v", Args: []any{count_stringer_t{count: &count}}})
+	assert.Assert(t, err != nil && err.Error() == "write failed", err)
+	// formatted once
+	assert.Assert(t, count == 1, count)
+	assert.Assert(t, buf1.String() == "INFO message 1\n", buf1.String())
+	assert.Assert(t, buf2.String() == buf1.String(), buf2.String())
+}
+
+func TestFilter(t *testing.T) {
+	var buf bytes.Buffer
+	q := NewFilter(NewWriterStdany(nil, &buf, 0), func(m Msg_t) bool { return strings.Contains(m.Format, "payment") })
+	q.LogWrite(Msg_t{Info: LOG_INFO, Format: "payment %v", Args: []any{1}})
+	q.LogWrite(Msg_t{Info: LOG_INFO, Format: "other"})
+	q.Close()
+	assert.Assert(t, buf.String() == "INFO payment 1\n", buf.String())
+}
+
+func TestRedact(t *testing.T) {
+	var buf bytes.Buffer
+	q := NewWriterStdany(nil, &buf, 0, WriteMessage(NewRedact([]*regexp.Regexp{RedactCardNumber, RedactBearerToken}, "***")))
+	q.LogWrite(Msg_t{Info: LOG_INFO, Format: "card %v header %v", Args: []any{"4111 1111 1111 1111", "Authorization: Bearer abc.DEF-123"}, Fields: []Field_t{{Key: "order", Value: 12345}}})
+	assert.Assert(t, buf.String() == "INFO card *** header Authorization: *** order=12345\n", buf.String())
+
+	buf.Reset()
+	q = NewWriterStdany(nil, &buf, 0, WriteMessage(NewRedact([]*regexp.Regexp{regexp.MustCompile(`(secret)=\w+`)}, "$1=$$")))
+	q.LogWrite(Msg_t{Info: LOG_INFO, Format: "secret=abc"})
+	assert.Assert(t, buf.String() == "INFO $1=$$\n", buf.String())
+}
+
+func BenchmarkRedact(b *testing.B) {
+	f := NewRedact([]*regexp.Regexp{RedactCardNumber, RedactBearerToken}, "***")
+	m := Msg_t{Info: LOG_INFO, Format: "request %v from %v", Args: []any{"/api/v1/payments", "192.168.1.1"}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		f.FormatMessage(io.Discard, m)
+	}
+}
+
+func TestRedactKeys(t *testing.T) {
+	var buf bytes.Buffer
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	args := []any{map[string]any{"user": "bob", "Password": "secret", "card": map[string]any{"ssn": "123-45-6789", "last4": 1234}}}
+	fields := []Field_t{{Key: "password", Value: "secret"}, {Key: "req", Value: map[string]any{"ssn": "1"}}}
+
+	MessageKB_t{RedactKeys: []string{"password", "ssn"}}.FormatMessage(&buf, Msg_t{Info: Info_t{Ts: ts, LevelName: "INFO"}, Format: "json", Args: args, Fields: fields})
+	assert.Assert(t, strings.Contains(buf.String(), `"Data":[{"Password":"***","card":{"last4":1234,"ssn":"***"},"user":"bob"}]`), buf.String())
+	assert.Assert(t, strings.Contains(buf.String(), `"password":"***","req":{"ssn":"***"}`), buf.String())
+	assert.Assert(t, !strings.Contains(buf.String(), "secret"), buf.String())
+
+	buf.Reset()
+	NewJson(JsonRawArgs(), JsonRedactKeys("password", "ssn")).FormatMessage(&buf, Msg_t{Info: Info_t{Ts: ts, LevelName: "INFO"}, Format: "json", Args: args, Fields: fields})
+	assert.Assert(t, strings.Contains(buf.String(), `"args":[{"Password":"***","card":{"last4":1234,"ssn":"***"},"user":"bob"}],"password":"***","req":{"ssn":"***"}`), buf.String())
+}
+
+func TestBatchEncoder(t *testing.T) {
+	var mx sync.Mutex
+	var bodies [][]byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mx.Lock()
+		bodies = append(bodies, body)
+		mx.Unlock()
+	}))
+	defer srv.Close()
+
+	q := NewHttpQueue(100, 1, NewUrls(srv.URL), nil, srv.Client(), BulkWrite(100), Batch(NewArray(NewJson())))
+	for i := 0; i < 5; i++ {
+		q.LogWrite(Msg_t{Info: Info_t{LevelName: "INFO"}, Format: "message %v", Args: []any{i}})
+	}
+	q.Close()
+
+	var count int
+	for _, v := range bodies {
+		var res []map[string]any
+		assert.NilError(t, json.Unmarshal(v, &res), string(v))
+		for _, doc := range res {
+			assert.Assert(t, doc["msg"] == fmt.Sprintf("message %v", count), doc)
+			count++
+		}
+	}
+	assert.Assert(t, count == 5, count)
+
+	bodies = nil
+	q = NewHttpQueue(100, 1, NewUrls(srv.URL), nil, srv.Client(), BulkWrite(100), Batch(NewLengthPrefix(NewTextMessage())))
+	for i := 0; i < 5; i++ {
+		q.LogWrite(Msg_t{Info: Info_t{LevelName: "INFO"}, Format: "message %v", Args: []any{i}})
+	}
+	q.Close()
+
+	count = 0
+	for _, v := range bodies {
+		for len(v) > 0 {
+			size := int(binary.BigEndian.Uint32(v))
+			assert.Assert(t, string(v[4:4+size]) == fmt.Sprintf("INFO message %v", count), string(v))
+			v = v[4+size:]
+			count++
+		}
+	}
+	assert.Assert(t, count == 5, count)
+}
+
+func TestElastic(t *testing.T) {
+	var mx sync.Mutex
+	var lines []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Assert(t, r.URL.Path == "/_bulk", r.URL.Path)
+		assert.Assert(t, r.Header.Get("Content-Type") == "application/x-ndjson")
+		body, _ := io.ReadAll(r.Body)
+		mx.Lock()
+		lines = append(lines, strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")...)
+		mx.Unlock()
+		io.WriteString(w, `{"took":3,"errors":true,"items":[{"index":{"status":201}},{"index":{"status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse"}}}]}`)
+	}))
+	defer srv.Close()
+
+	var errs []error
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	q := NewElastic(10, 1, srv.URL, "logs-2006.01.02", MessageKB_t{}, srv.Client(), BulkWrite(10), HttpOnError(func(err error) { errs = append(errs, err) }))
+	q.LogWrite(Msg_t{Info: Info_t{Ts: ts, LevelName: "INFO"}, Format: "message"})
+	q.Close()
+
+	assert.Assert(t, len(lines) == 2, lines)
+	assert.Assert(t, lines[0] == `{"index":{"_index":"logs-2024.01.02"}}`, lines[0])
+	assert.Assert(t, strings.Contains(lines[1], `"Message":"message"`), lines[1])
+	assert.Assert(t, q.Size().WriteErrorCnt == 1, q.Size())
+	assert.Assert(t, q.Size().QueueRetry == 0, q.Size())
+	assert.Assert(t, len(errs) == 1, errs)
+	assert.Assert(t, errs[0].Error() == "partial failure: 1: mapper_parsing_exception: failed to parse", errs[0])
+}
+
+func TestElasticDataStream(t *testing.T) {
+	var buf bytes.Buffer
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	index := MessageIndexKB_t{Action: "create", Index: MessageIndexNameKB_t{Index: "logs"}}
+	MessageKB_t{Index: index}.FormatMessage(&buf, Msg_t{Info: Info_t{Ts: ts}, Format: "a"}, Msg_t{Info: Info_t{Ts: ts}, Format: "b"})
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Assert(t, len(lines) == 4, buf.String())
+	assert.Equal(t, lines[0], `{"create":{"_index":"logs"}}`)
+	assert.Equal(t, lines[2], `{"create":{"_index":"logs"}}`)
+
+	buf.Reset()
+	index = MessageIndexKB_t{Action: "create", Index: MessageIndexNameKB_t{Format: "logs-2006.01"}}
+	MessageKB_t{Index: index}.FormatMessage(&buf, Msg_t{Info: Info_t{Ts: ts}, Format: "a"})
+	assert.Assert(t, strings.HasPrefix(buf.String(), `{"create":{"_index":"logs-2024.01"}}`+"\n"), buf.String())
+
+	body, err := json.Marshal(MessageIndexKB_t{Index: MessageIndexNameKB_t{Index: "logs", Type: "_doc"}})
+	assert.NilError(t, err)
+	assert.Equal(t, string(body), `{"index":{"_index":"logs","_type":"_doc"}}`)
+
+	// no action line without index
+	buf.Reset()
+	MessageKB_t{}.FormatMessage(&buf, Msg_t{Info: Info_t{Ts: ts}, Format: "a"})
+	assert.Assert(t, strings.HasPrefix(buf.String(), `{"timestamp":`), buf.String())
+}
+
+func TestKibanaBulkResponse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"took":3,"errors":true,"items":[{"index":{"status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse"}}}]}`)
+	}))
+	defer srv.Close()
+
+	q := NewHttpQueue(10, 1, NewUrls(srv.URL), MessageKB_t{}, srv.Client(), HttpOnError(func(error) {}))
+	q.LogWrite(Msg_t{Format: "message"})
+	q.Close()
+	assert.Assert(t, q.Size().WriteErrorCnt == 1, q.Size())
+
+	q = NewHttpQueue(10, 1, NewUrls(srv.URL), MessageKB_t{}, srv.Client(), ReadResponse(nil))
+	q.LogWrite(Msg_t{Format: "message"})
+	q.Close()
+	assert.Assert(t, q.Size().WriteErrorCnt == 0, q.Size())
+}
+
+func TestElasticBulkResponse(t *testing.T) {
+	var body strings.Builder
+	body.WriteString(`{"took":3,"errors":true,"items":[`)
+	for i := 0; i < 1000; i++ {
+		if i > 0 {
+			body.WriteString(",")
+		}
+		if i%10 == 5 {
+			fmt.Fprintf(&body, `{"create":{"_id":"%v","status":400,"error":{"type":"type_%v","reason":"reason %v"}}}`, i, i, i)
+		} else {
+			fmt.Fprintf(&body, `{"index":{"_id":"%v","status":201}}`, i)
+		}
+	}
+	body.WriteString(`]}`)
+
+	err := ElasticBulkResponse(&http.Response{Body: io.NopCloser(strings.NewReader(body.String()))})
+	var partial_err *PartialError_t
+	assert.Assert(t, errors.As(err, &partial_err), err)
+	assert.Assert(t, partial_err.Failed == 100, partial_err)
+	assert.Assert(t, partial_err.Reason == "type_5: reason 5", partial_err)
+
+	err = ElasticBulkResponse(&http.Response{Body: io.NopCloser(strings.NewReader(`{"took":3,"errors":false,"items":[{"index":{"status":201}}]}`))})
+	assert.NilError(t, err)
+}
+
+func TestOverflowBlockContext(t *testing.T) {
+	q := NewQueue(1)
+	SetOverflow(q, Block, 0)
+	q.LogWrite(Msg_t{Format: "1"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := q.LogWrite(Msg_t{Ctx: ctx, Format: "2"})
+	assert.Assert(t, err == context.Canceled, err)
+	assert.Assert(t, time.Since(start) >= 20*time.Millisecond)
+
+	_, err = q.LogWrite(Msg_t{Ctx: ctx, Format: "3"})
+	assert.Assert(t, err == context.Canceled, err)
+	res := q.Size()
+	assert.Assert(t, res.Size == 1 && res.QueueOverflow == 2, res)
+}
+
+func TestOnDrop(t *testing.T) {
+	var dropped []string
+	q := NewQueue(2)
+	assert.Assert(t, SetOnDrop(q, func(m Msg_t) { dropped = append(dropped, m.Format) }))
+	q.LogWrite(Msg_t{Format: "1"})
+	q.LogWrite(Msg_t{Format: "2"})
+	q.LogWrite(Msg_t{Format: "3"})
+	assert.Assert(t, len(dropped) == 0, dropped)
+
+	msg, ok := q.LogRead(1)
+	assert.Assert(t, ok && msg[0].Format == "1", msg)
+	assert.DeepEqual(t, dropped, []string{"3"})
+
+	SetOverflow(q, DropOldest, 0)
+	q.LogWrite(Msg_t{Format: "4"})
+	q.LogWrite(Msg_t{Format: "5"})
+	msg, _ = q.LogRead(2)
+	assert.Assert(t, len(msg) == 2 && msg[0].Format == "4", msg)
+	assert.DeepEqual(t, dropped, []string{"3", "2"})
+}
+
+func TestOverflowBlock(t *testing.T) {
+	q := NewQueue(2)
+	assert.Assert(t, SetOverflow(q, Block, 50*time.Millisecond))
+	q.LogWrite(Msg_t{Format: "1"})
+	q.LogWrite(Msg_t{Format: "2"})
+	start := time.Now()
+	_, err := q.LogWrite(Msg_t{Format: "3"})
+	assert.Assert(t, err == ERROR_OVERFLOW, err)
+	assert.Assert(t, time.Since(start) >= 50*time.Millisecond)
+
+	var res []string
+	SetOverflow(q, Block, 0)
+	q.WgAdd(1)
+	go func() {
+		defer q.WgDone()
+		for {
+			msg, ok := q.LogRead(1)
+			if !ok {
+				return
+			}
+			time.Sleep(time.Millisecond)
+			res = append(res, msg[0].Format)
+		}
+	}()
+	for i := 3; i < 10; i++ {
+		_, err = q.LogWrite(Msg_t{Format: strconv.Itoa(i)})
+		assert.NilError(t, err)
+	}
+	q.Close()
+	assert.Assert(t, strings.Join(res, "") == "123456789", res)
+	assert.Assert(t, q.Size().QueueOverflow == 1, q.Size())
+}
+
+func TestOverflowDropOldest(t *testing.T) {
+	q := NewQueue(4)
+	SetOverflow(q, DropOldest, 0)
+	for i := 0; i < 8; i++ {
+		_, err := q.LogWrite(Msg_t{Format: strconv.Itoa(i)})
+		assert.NilError(t, err)
+	}
+	size := q.Size()
+	assert.Assert(t, size.QueueWrite == 8 && size.Size == 4 && size.QueueOverflow == 4, size)
+
+	msg, ok := q.LogRead(10)
+	assert.Assert(t, ok && len(msg) == 4, msg)
+	for i, v := range msg {
+		assert.Assert(t, v.Format == strconv.Itoa(i+4), msg)
+	}
+	size = q.Size()
+	assert.Assert(t, size.QueueRead == 4 && size.Size == 0, size)
+	assert.Assert(t, size.QueueWrite == size.QueueRead+size.Size+size.QueueOverflow, size)
+}
+
+func TestHighWater(t *testing.T) {
+	q := NewQueue(10)
+	for i := 0; i < 5; i++ {
+		q.LogWrite(Msg_t{})
+	}
+	q.LogRead(4)
+	q.LogWrite(Msg_t{})
+	assert.Assert(t, q.Size().HighWater == 5, q.Size())
+	assert.Assert(t, q.ResetHighWater() == 5)
+	assert.Assert(t, q.Size().HighWater == 2, q.Size())
+}
+
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "all.log")
+	q, err := NewWriterFileTimeQueue(10, 1, time.Now(), filename, nil, time.Hour, 1, 0)
+	assert.NilError(t, err)
+	logger := New(NewLevelMap().AddOutputs("file", q, WhatLevel(0)))
+	stop := ReopenOnSignal(logger)
+	defer stop()
+
+	logger.Info("before")
+	Flush(q)
+	assert.NilError(t, os.Rename(filename, filename+".1"))
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	for i := 0; i < 100; i++ {
+		if _, err = os.Stat(filename); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	logger.Info("after")
+	logger.Close()
+
+	moved, _ := os.ReadFile(filename + ".1")
+	reopened, _ := os.ReadFile(filename)
+	assert.Assert(t, string(moved) == "INFO before\n", string(moved))
+	assert.Assert(t, string(reopened) == "INFO after\n", string(reopened))
+}
+
+func TestMaxTotalSize(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "all.log")
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	w, err := NewWriterFileBytes(ts, filename, nil, 10, 10, 0, MaxTotalSize(30))
+	assert.NilError(t, err)
+	for i := 0; i < 6; i++ {
+		w.LogWrite(Msg_t{Info: Info_t{Ts: ts.Add(time.Duration(i) * time.Second), LevelName: "INFO"}, Format: "message %v", Args: []any{i}})
+	}
+	w.Close()
+
+	files, _ := filepath.Glob(filename + "*")
+	for i := range files {
+		files[i] = filepath.Base(files[i])
+	}
+	assert.Assert(t, strings.Join(files, ",") == "all.log,all.log.4.20240102030409,all.log.5.20240102030410", files)
+}
+
+func TestMaxAge(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "all.log")
+	ts := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	for i, v := range []time.Time{ts.Add(-40 * 24 * time.Hour), ts.Add(-20 * 24 * time.Hour), ts.Add(24 * time.Hour)} {
+		backup := fmt.Sprintf("%s.%d.%s", filename, i+1, v.Format(FileTime))
+		os.WriteFile(backup, nil, 0644)
+		os.Chtimes(backup, v, v)
+	}
+	w, err := NewWriterFileTime(ts, filename, nil, 24*time.Hour, 10, 0, MaxAge(30*24*time.Hour))
+	assert.NilError(t, err)
+	w.Close()
+
+	files, _ := filepath.Glob(filename + ".*")
+	assert.Assert(t, len(files) == 2, files)
+	assert.Assert(t, strings.Contains(files[0], ".2.") && strings.Contains(files[1], ".3."), files)
+}
+
+func TestCurrentSymlink(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "all.log")
+	current := filepath.Join(dir, "current")
+	os.Symlink("stale.log", current)
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	w, err := NewWriterFileTime(ts, filename, nil, time.Hour, 10, 0, CurrentSymlink(current))
+	assert.NilError(t, err)
+	w.LogWrite(Msg_t{Info: Info_t{Ts: ts, LevelName: "INFO"}, Format: "test1"})
+	w.LogWrite(Msg_t{Info: Info_t{Ts: ts.Add(time.Hour), LevelName: "INFO"}, Format: "test2"})
+	w.Close()
+
+	target, err := os.Readlink(current)
+	assert.NilError(t, err)
+	assert.Assert(t, target == "all.log", target)
+	buf, err := os.ReadFile(current)
+	assert.NilError(t, err)
+	assert.Assert(t, string(buf) == "INFO test2\n", string(buf))
+	_, err = os.Lstat(current + ".tmp")
+	assert.Assert(t, os.IsNotExist(err), err)
+
+	// symlink in other directory points to absolute path
+	other := filepath.Join(t.TempDir(), "current")
+	w, err = NewWriterFileTime(ts, filename, nil, time.Hour, 10, 0, CurrentSymlink(other))
+	assert.NilError(t, err)
+	w.Close()
+	target, err = os.Readlink(other)
+	assert.NilError(t, err)
+	assert.Assert(t, target == filename, target)
+
+	// symlink error is reported, output keeps working
+	var errs []error
+	w, err = NewWriterFileTime(ts, filename, nil, time.Hour, 10, 0, CurrentSymlink(filepath.Join(dir, "missing", "current")), OnError(func(err error) { errs = append(errs, err) }))
+	assert.NilError(t, err)
+	_, err = w.LogWrite(Msg_t{Info: Info_t{Ts: ts, LevelName: "INFO"}, Format: "test3"})
+	assert.NilError(t, err)
+	w.Close()
+	assert.Assert(t, len(errs) == 1, errs)
+}
+
+func TestJournal(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "journal.socket")
+	conn, err := net.ListenPacket("unixgram", socket)
+	assert.NilError(t, err)
+	defer conn.Close()
+
+	q := NewWriterJournal(socket, nil, 0)
+	q.LogWrite(Msg_t{Info: Info_t{LevelName: "ERROR", LevelId: LOG_ERROR.LevelId, File: "main.go", Line: 42}, Format: "line1\nline2", Fields: []Field_t{{Key: "request-id", Value: 7}}})
+	q.Close()
+
+	buf := make([]byte, 4096)
+	n, _, err := conn.ReadFrom(buf)
+	assert.NilError(t, err)
+	var expected bytes.Buffer
+	JournalField(&expected, "PRIORITY", "3")
+	JournalField(&expected, "SYSLOG_IDENTIFIER", filepath.Base(os.Args[0]))
+	JournalField(&expected, "MESSAGE", "ERROR line1\nline2 request-id=7")
+	expected.WriteString("CODE_FILE=main.go\nCODE_LINE=42\nREQUEST_ID=7\n")
+	assert.Assert(t, bytes.Equal(buf[:n], expected.Bytes()), string(buf[:n]))
+	assert.Assert(t, bytes.Contains(buf[:n], []byte("MESSAGE\n\x1e\x00\x00\x00\x00\x00\x00\x00ERROR line1\n")))
+
+	assert.Assert(t, JournalKey("_a.b-c1") == "A_B_C1", JournalKey("_a.b-c1"))
+}
+
+type cloudwatch_test_t struct {
+	mx       sync.Mutex
+	created  map[string]bool
+	token    string
+	fail     int
+	accepted int
+	batches  [][]CloudWatchEvent_t
+}
+
+func (self *cloudwatch_test_t) CreateLogGroup(ctx context.Context, group string) error {
+	self.created[group] = true
+	return nil
+}
+
+func (self *cloudwatch_test_t) CreateLogStream(ctx context.Context, group string, stream string) error {
+	self.created[group+"/"+stream] = true
+	return nil
+}
+
+func (self *cloudwatch_test_t) PutLogEvents(ctx context.Context, group string, stream string, token string, events []CloudWatchEvent_t) (string, error) {
+	self.mx.Lock()
+	defer self.mx.Unlock()
+	if self.fail > 0 {
+		self.fail--
+		return "", errors.New("ThrottlingException")
+	}
+	if token != self.token {
+		return "", fmt.Errorf("InvalidSequenceTokenException: The given sequenceToken is invalid. The next expected sequenceToken is: %v", self.token)
+	}
+	self.token = strconv.Itoa(len(self.batches))
+	self.batches = append(self.batches, append([]CloudWatchEvent_t{}, events...))
+	// response is lost, batch is stored
+	if self.accepted > 0 {
+		self.accepted--
+		return "", fmt.Errorf("DataAlreadyAcceptedException: The given batch of log events has already been accepted. The next batch can be sent with sequenceToken: %v", self.token)
+	}
+	return self.token, nil
+}
+
+func TestCloudWatch(t *testing.T) {
+	client := &cloudwatch_test_t{created: map[string]bool{}, fail: 1}
+	q := NewCloudWatch(100, client, "group", "stream", NewTextMessage(), CloudWatchRetry(3, time.Millisecond, time.Millisecond), CloudWatchBulkWrite(100))
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		q.LogWrite(Msg_t{Info: Info_t{Ts: ts.Add(-time.Duration(i) * time.Second), LevelName: "INFO"}, Format: "message %v", Args: []any{i}})
+	}
+	q.Close()
+
+	assert.Assert(t, client.created["group"] && client.created["group/stream"])
+	var events []CloudWatchEvent_t
+	for _, v := range client.batches {
+		events = append(events, v...)
+		for i := 1; i < len(v); i++ {
+			assert.Assert(t, v[i-1].Timestamp <= v[i].Timestamp, v)
+		}
+	}
+	assert.Assert(t, len(events) == 5, events)
+	assert.Assert(t, q.Size().WriteErrorCnt == 0 && q.Size().QueueRetry == 1, q.Size())
+
+	var big []CloudWatchEvent_t
+	for i := 0; i < 12000; i++ {
+		big = append(big, CloudWatchEvent_t{Timestamp: int64(i), Message: "x"})
+	}
+	big = append(big, CloudWatchEvent_t{Timestamp: int64(12000) + CLOUDWATCH_MAX_SPAN.Milliseconds(), Message: "x"})
+	batches := CloudWatchBatches(big)
+	assert.Assert(t, len(batches) == 3 && len(batches[0]) == 10000 && len(batches[1]) == 2000 && len(batches[2]) == 1, len(batches))
+}
+
+func TestCloudWatchToken(t *testing.T) {
+	client := &cloudwatch_test_t{created: map[string]bool{}, token: "49590", accepted: 1}
+	q := NewCloudWatch(100, client, "group", "stream", NewTextMessage(), CloudWatchRetry(3, time.Millisecond, time.Millisecond))
+	for i := 0; i < 3; i++ {
+		q.LogWrite(Msg_t{Info: Info_t{Ts: time.Now(), LevelName: "INFO"}, Format: "message %v", Args: []any{i}})
+		Flush(q)
+	}
+	q.Close()
+
+	assert.Assert(t, len(client.batches) == 3, client.batches)
+	assert.Assert(t, q.Size().WriteErrorCnt == 0 && q.Size().QueueRetry == 0, q.Size())
+
+	token, ok := cloudwatch_token(errors.New("InvalidSequenceTokenException: The given sequenceToken is invalid. The next expected sequenceToken is: null"))
+	assert.Assert(t, ok && token == "", token)
+	_, ok = cloudwatch_token(errors.New("ThrottlingException"))
+	assert.Assert(t, !ok)
+}
+
+type s3_test_t struct {
+	mx      sync.Mutex
+	objects map[string]string
+}
+
+func (self *s3_test_t) PutObject(ctx context.Context, bucket string, key string, body []byte) error {
+	r, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	text, _ := io.ReadAll(r)
+	self.mx.Lock()
+	self.objects[bucket+"/"+key] = string(text)
+	self.mx.Unlock()
+	return nil
+}
+
+func (self *s3_test_t) Len() int {
+	self.mx.Lock()
+	defer self.mx.Unlock()
+	return len(self.objects)
+}
+
+func TestS3(t *testing.T) {
+	client := &s3_test_t{objects: map[string]string{}}
+	q := NewS3(100, client, "bucket", "logs/", 40, 0, NewTextMessage())
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := 0; i < 7; i++ {
+		q.LogWrite(Msg_t{Info: Info_t{Ts: ts.Add(time.Duration(i) * time.Second), LevelName: "INFO"}, Format: "message %v", Args: []any{i}})
+	}
+	q.Close()
+
+	assert.Assert(t, client.Len() == 3, client.objects)
+	assert.Assert(t, client.objects["bucket/logs/20240102T030405.000Z_20240102T030407.000Z_1.log.gz"] == "INFO message 0\nINFO message 1\nINFO message 2\n", client.objects)
+	assert.Assert(t, client.objects["bucket/logs/20240102T030411.000Z_20240102T030411.000Z_3.log.gz"] == "INFO message 6\n", client.objects)
+
+	client = &s3_test_t{objects: map[string]string{}}
+	q = NewS3(100, client, "bucket", "logs/", 0, 20*time.Millisecond, NewTextMessage())
+	q.LogWrite(Msg_t{Info: Info_t{Ts: ts, LevelName: "INFO"}, Format: "message"})
+	for i := 0; i < 100 && client.Len() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Assert(t, client.Len() == 1, client.objects)
+	q.Close()
+	assert.Assert(t, client.Len() == 1, client.objects)
+}
+
+type nats_test_t struct {
+	mx  sync.Mutex
+	msg map[string][]string
+}
+
+func (self *nats_test_t) Publish(subject string, data []byte) error {
+	self.mx.Lock()
+	self.msg[subject] = append(self.msg[subject], string(data))
+	self.mx.Unlock()
+	return nil
+}
+
+func TestNats(t *testing.T) {
+	conn := &nats_test_t{msg: map[string][]string{}}
+	q := NewNats(10, 2, conn, "logs", NewTextMessage())
+	q.LogWrite(Msg_t{Info: LOG_ERROR, Format: "error"})
+	q.LogWrite(Msg_t{Info: LOG_INFO, Format: "info"})
+	q.Close()
+	assert.DeepEqual(t, conn.msg, map[string][]string{"logs.error": {"ERROR error"}, "logs.info": {"INFO info"}})
+}
+
+func TestSocket(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	address := ln.Addr().String()
+	for framing, expected := range map[Framing_t]string{FramingNewline: "INFO a\nINFO b\n", FramingNull: "INFO a\x00INFO b\x00", FramingLength: "\x00\x00\x00\x06INFO a\x00\x00\x00\x06INFO b"} {
+		q, err := NewWriterSocket("tcp", address, framing, nil, 0)
+		assert.NilError(t, err)
+		q.LogWrite(Msg_t{Info: LOG_INFO, Format: "a"})
+		q.LogWrite(Msg_t{Info: LOG_INFO, Format: "b"})
+		q.Close()
+		conn, err := ln.Accept()
+		assert.NilError(t, err)
+		body, _ := io.ReadAll(conn)
+		conn.Close()
+		assert.Assert(t, string(body) == expected, "%q", body)
+	}
+
+	// listener is gone, writes fail until listener is back
+	ln.Close()
+	q, err := NewWriterSocketQueue(100, 1, "tcp", address, FramingNewline, nil, 0)
+	assert.NilError(t, err)
+	for i := 0; i < 20; i++ {
+		q.LogWrite(Msg_t{Info: LOG_INFO, Format: "message %v", Args: []any{i}})
+		time.Sleep(5 * time.Millisecond)
+	}
+	ln, err = net.Listen("tcp", address)
+	assert.NilError(t, err)
+	defer ln.Close()
+	conn, err := ln.Accept()
+	assert.NilError(t, err)
+	defer conn.Close()
+	Flush(q)
+	q.Close()
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	body, _ := io.ReadAll(conn)
+	assert.Assert(t, strings.HasSuffix(string(body), "INFO message 19\n"), string(body))
+	assert.Assert(t, q.Size().QueueRetry > 0, q.Size())
+}
+
+func TestSocketClose(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	address := ln.Addr().String()
+	ln.Close()
+
+	q, err := NewWriterSocketQueue(100, 1, "tcp", address, FramingNewline, nil, 0)
+	assert.NilError(t, err)
+	for i := 0; i < 5; i++ {
+		q.LogWrite(Msg_t{Info: LOG_INFO, Format: "message %v", Args: []any{i}})
+	}
+	q.Close()
+	size := q.Size()
+	assert.Assert(t, size.Size == 0 && size.QueueDrop == 5, size)
+}
+
+func TestStdanyPipe(t *testing.T) {
+	r, w, err := os.Pipe()
+	assert.NilError(t, err)
+	q1 := NewWriterStdanyQueue(2000, 8, []Formatter{NewDt("2006-01-02 15:04:05.000")}, w, 0)
+	q2 := NewWriterStdanyQueue(2000, 8, []Formatter{NewDt("2006-01-02 15:04:05.000")}, w, 0)
+	done := make(chan []byte)
+	go func() {
+		body, _ := io.ReadAll(r)
+		done <- body
+	}()
+	payload := strings.Repeat("x", 200)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				q1.LogWrite(Msg_t{Info: Info_t{Ts: time.Now(), LevelName: "INFO"}, Format: "%v %v %v", Args: []any{i, j, payload}})
+				q2.LogWrite(Msg_t{Info: Info_t{Ts: time.Now(), LevelName: "WARN"}, Format: "%v %v %v", Args: []any{i, j, payload}})
+			}
+		}(i)
+	}
+	wg.Wait()
+	q1.Close()
+	q2.Close()
+	w.Close()
+
+	lines := strings.Split(strings.TrimSuffix(string(<-done), "\n"), "\n")
+	assert.Assert(t, len(lines) == 8*200*2, len(lines))
+	for _, v := range lines {
+		assert.Assert(t, len(v) > 24 && (v[24:29] == "INFO " || v[24:29] == "WARN ") && strings.HasSuffix(v, " "+payload), v)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	mem := NewMemory()
+	logger := New(NewLevelMap().AddOutputs("mem", mem, WhatLevel(0)))
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			logger.With("id", i).Info("request %v done", i)
+		}(i)
+	}
+	wg.Wait()
+	logger.Error("payment failed")
+
+	assert.Assert(t, len(mem.Messages()) == 11)
+	assert.Assert(t, mem.Contains("INFO", "request 3 done id=3"), mem.Lines())
+	assert.Assert(t, mem.Contains("ERROR", "payment"))
+	assert.Assert(t, !mem.Contains("INFO", "payment"))
+	mem.Reset()
+	assert.Assert(t, len(mem.Lines()) == 0)
+}
+
+func TestCaptureWriter(t *testing.T) {
+	capture := NewCaptureWriter()
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	q := NewWriterStdany([]Formatter{NewDt("2006-01-02 15:04:05")}, capture, 0, WriteMessage(NewJson()))
+	q.LogWrite(Msg_t{Info: Info_t{Ts: ts, LevelName: "INFO", File: "main.go", Line: 1}, Format: "message"})
+	assert.Assert(t, capture.String() == `2024-01-02 03:04:05 {"ts":"2024-01-02T03:04:05Z","level":"INFO","file":"main.go","line":1,"msg":"message"}`+"\n", capture.String())
+	capture.Reset()
+	assert.Assert(t, len(capture.Bytes()) == 0)
+}
+
+func TestSetClock(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	SetClock(func() time.Time { return ts })
+	defer SetClock(nil)
+
+	capture := NewCaptureWriter()
+	logger := New(NewLevelMap().AddOutputs("capture", NewWriterStdany([]Formatter{NewDt("2006-01-02 15:04:05")}, capture, 0), WhatLevel(0)))
+	logger.Info("message")
+	assert.Assert(t, capture.String() == "2024-01-02 03:04:05 INFO message\n", capture.String())
+	SetClock(nil)
+	assert.Assert(t, Now().After(ts))
+}
+
+func TestSetLevelName(t *testing.T) {
+	SetLevelName(LOG_WARN, "WRN")
+	defer SetLevelName(LOG_WARN, "")
+
+	capture := NewCaptureWriter()
+	logger := New(NewLevelMap().AddOutputs("capture", NewWriterStdany(nil, capture, 0, WriteMessage(NewJson())), WhatLevel(LOG_WARN.LevelId)))
+	logger.Warn("warn")
+	logger.Info("info")
+	logger.Error("error")
+	assert.Assert(t, strings.Contains(capture.String(), `"level":"WRN"`), capture.String())
+	assert.Assert(t, strings.Contains(capture.String(), `"level":"ERROR"`), capture.String())
+	assert.Assert(t, strings.Count(capture.String(), "\n") == 2, capture.String())
+}
+
+func TestTextPad(t *testing.T) {
+	capture := NewCaptureWriter()
+	q := NewWriterStdany(nil, capture, 0, WriteMessage(NewTextMessage(TextPad(5))))
+	q.LogWrite(Msg_t{Info: LOG_INFO, Format: "info"})
+	q.LogWrite(Msg_t{Info: LOG_ERROR, Format: "error"})
+	assert.Assert(t, capture.String() == "INFO  info\nERROR error\n", capture.String())
+}
+
+func TestMessageLayout(t *testing.T) {
+	var buf bytes.Buffer
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	m := Msg_t{Info: Info_t{Ts: ts, LevelName: "INFO"}, Format: "message"}
+
+	MessageKB_t{}.FormatMessage(&buf, m)
+	assert.Assert(t, strings.Contains(buf.String(), `"timestamp":"2024-01-02T03:04:05.000+00:00"`), buf.String())
+
+	buf.Reset()
+	MessageKB_t{Ts: Timestamp(time.RFC3339, nil)}.FormatMessage(&buf, m)
+	assert.Assert(t, strings.Contains(buf.String(), `"timestamp":"2024-01-02T03:04:05Z"`), buf.String())
+
+	buf.Reset()
+	MessageTG_t{Ts: Timestamp("15:04:05", nil)}.FormatMessage(&buf, m)
+	assert.Assert(t, strings.Contains(buf.String(), `"text":"03:04:05 `), buf.String())
+
+	// one value for all outputs
+	layout := Timestamp(time.RFC3339, time.FixedZone("MSK", 3*3600))
+	buf.Reset()
+	NewDt("", DtTimestamp(layout)).FormatMessage(&buf, m)
+	assert.Assert(t, buf.String() == "2024-01-02T06:04:05+03:00 ", buf.String())
+	buf.Reset()
+	MessageKB_t{Ts: layout}.FormatMessage(&buf, m)
+	assert.Assert(t, strings.Contains(buf.String(), `"timestamp":"2024-01-02T06:04:05+03:00"`), buf.String())
+	buf.Reset()
+	MessageTG_t{Ts: layout}.FormatMessage(&buf, m)
+	assert.Assert(t, strings.Contains(buf.String(), `"text":"2024-01-02T06:04:05+03:00 `), buf.String())
+}
+
+func TestDtPrecision(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.UTC)
+	for f, expected := range map[Formatter]string{
+		DtSeconds():     "2024-01-02 03:04:05 ",
+		DtMillis():      "2024-01-02 03:04:05.123 ",
+		DtMicros():      "2024-01-02 03:04:05.123456 ",
+		DtNanos():       "2024-01-02 03:04:05.123456789 ",
+		DtRFC3339Nano(): "2024-01-02T03:04:05.123456789Z ",
+	} {
+		var buf bytes.Buffer
+		f.FormatMessage(&buf, Msg_t{Info: Info_t{Ts: ts}})
+		assert.Assert(t, buf.String() == expected, buf.String())
+	}
+}
+
+func TestTimestampLocation(t *testing.T) {
+	var buf bytes.Buffer
+	zone := time.FixedZone("MSK", 3*3600)
+	m := Msg_t{Info: Info_t{Ts: time.Date(2024, 1, 2, 3, 4, 5, 0, zone), LevelName: "INFO"}, Format: "message"}
+
+	DtSeconds(DtUTC()).FormatMessage(&buf, m)
+	assert.Assert(t, buf.String() == "2024-01-02 00:04:05 ", buf.String())
+
+	buf.Reset()
+	DtSeconds().FormatMessage(&buf, m)
+	assert.Assert(t, buf.String() == "2024-01-02 03:04:05 ", buf.String())
+
+	buf.Reset()
+	NewJson(JsonLocation(time.UTC)).FormatMessage(&buf, m)
+	assert.Assert(t, strings.Contains(buf.String(), `"ts":"2024-01-02T00:04:05Z"`), buf.String())
+
+	buf.Reset()
+	NewLogfmt(time.RFC3339, LogfmtLocation(time.UTC)).FormatMessage(&buf, m)
+	assert.Assert(t, strings.HasPrefix(buf.String(), "ts=2024-01-02T00:04:05Z "), buf.String())
+
+	buf.Reset()
+	MessageKB_t{Ts: Timestamp("", time.UTC)}.FormatMessage(&buf, m)
+	assert.Assert(t, strings.Contains(buf.String(), `"timestamp":"2024-01-02T00:04:05.000+00:00"`), buf.String())
+}
+
+func TestElapsed(t *testing.T) {
+	for d, expected := range map[time.Duration]string{
+		1234567890 * time.Nanosecond:                         "1.235s",
+		5*time.Minute + 3*time.Second + 400*time.Millisecond: "5m3s",
+		1500 * time.Microsecond:                              "1.5ms",
+		1234 * time.Nanosecond:                               "1.234µs",
+	} {
+		assert.Assert(t, Elapsed_t(d).String() == expected, Elapsed_t(d).String())
+	}
+	start := time.Now()
+	capture := NewCaptureWriter()
+	q := NewWriterStdany(nil, capture, 0)
+	q.LogWrite(Msg_t{Info: LOG_INFO, Format: "done in %v", Args: []any{Elapsed(start)}})
+	assert.Assert(t, strings.HasPrefix(capture.String(), "INFO done in "), capture.String())
+	body, _ := json.Marshal(Elapsed_t(1500 * time.Millisecond))
+	assert.Assert(t, string(body) == `"1.5s"`, string(body))
+}
@@ -0,0 +1,80 @@
+//
+// io.Writer adapter, i.e. log.New(logger.Writer(LOG_INFO), "", 0)
+//
+
+package log
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+type LogWriter_t struct {
+	mx     sync.Mutex
+	logger Logger
+	level  Info_t
+	buf    []byte
+}
+
+func NewLogWriter(logger Logger, level Info_t) *LogWriter_t {
+	return &LogWriter_t{logger: logger, level: level}
+}
+
+// each line is logged separately, partial line is kept until next Write or Close
+func (self *LogWriter_t) Write(p []byte) (n int, err error) {
+	self.mx.Lock()
+	defer self.mx.Unlock()
+	self.buf = append(self.buf, p...)
+	level := self.level
+	for {
+		i := bytes.IndexByte(self.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if len(level.File) == 0 {
+			level.File, level.Line = WriterCaller()
+		}
+		self.logger.Log(context.Background(), level, "%s", bytes.TrimSuffix(self.buf[:i], []byte("\r")))
+		self.buf = self.buf[i+1:]
+	}
+	if len(self.buf) == 0 {
+		self.buf = nil
+	}
+	return len(p), nil
+}
+
+func (self *LogWriter_t) Close() error {
+	self.mx.Lock()
+	defer self.mx.Unlock()
+	if len(self.buf) > 0 {
+		level := self.level
+		if len(level.File) == 0 {
+			level.File, level.Line = WriterCaller()
+		}
+		self.logger.Log(context.Background(), level, "%s", self.buf)
+		self.buf = nil
+	}
+	return nil
+}
+
+// first frame outside this package and stdlib log, fmt, io
+func WriterCaller() (file string, line int) {
+	var pc [32]uintptr
+	frames := runtime.CallersFrames(pc[:runtime.Callers(2, pc[:])])
+	for {
+		frame, more := frames.Next()
+		if !InPackage(frame) &&
+			!strings.HasPrefix(frame.Function, "log.") &&
+			!strings.HasPrefix(frame.Function, "fmt.") &&
+			!strings.HasPrefix(frame.Function, "io.") &&
+			!strings.HasPrefix(frame.Function, "bufio.") {
+			return frame.File, frame.Line
+		}
+		if !more {
+			return
+		}
+	}
+}
@@ -6,7 +6,9 @@ package log
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -17,11 +19,48 @@ type Info_t struct {
 	File      string    `json:"file"`
 	Line      int       `json:"line"`
 	LevelId   int64     `json:"level"`
+	// caller program counter, function name for NewFileLine(FileLineFunc())
+	Pc uintptr `json:"-"`
+	// goroutine of Log caller for NewGoroutineId, set if output needs it, see WithGoroutineId
+	Gid int64 `json:"-"`
 }
 
+var __clock atomic.Pointer[func() time.Time]
+
+// Ts of messages, nil restores time.Now
+func SetClock(fn func() time.Time) {
+	if fn == nil {
+		__clock.Store(nil)
+	} else {
+		__clock.Store(&fn)
+	}
+}
+
+func Now() time.Time {
+	if fn := __clock.Load(); fn != nil {
+		return (*fn)()
+	}
+	return time.Now()
+}
+
+// Ts and File already set are kept, i.e. by slog handler
 func (self *Info_t) Set(ts time.Time) {
-	self.Ts = ts
-	self.File, self.Line = FileLine(1, 32)
+	self.SetSkip(ts, 0)
+}
+
+// caller_skip frames above first caller outside this package, see FileLineSkip
+func (self *Info_t) SetSkip(ts time.Time, caller_skip int) {
+	if self.Ts.IsZero() {
+		self.Ts = ts
+	}
+	if len(self.File) == 0 {
+		self.Pc, self.File, self.Line = PcFileLineSkip(1, 32+caller_skip, caller_skip)
+	}
+}
+
+type Field_t struct {
+	Key   string `json:"key"`
+	Value any    `json:"value"`
 }
 
 type Msg_t struct {
@@ -29,16 +68,25 @@ type Msg_t struct {
 	Info   Info_t          `json:"info"`
 	Format string          `json:"format"`
 	Args   []any           `json:"args"`
+	Fields []Field_t       `json:"fields,omitempty"`
+	// times returned to queue after failed write
+	requeued int
 }
 
 type QueueSize_t struct {
 	Limit         int
 	Size          int
+	HighWater     int // peak Size since start or ResetHighWater()
 	Readers       int
 	Writers       int
 	QueueWrite    int
 	QueueRead     int
 	QueueOverflow int
+	QueueRetry    int
+	QueueDrop     int
+	QueueSampled  int
+	CircuitDrop   int
+	CircuitState  string
 	WriteErrorCnt int
 	WriteErrorMsg string
 }
@@ -49,12 +97,147 @@ type Queue interface {
 	Close() error
 }
 
+// Queue that can wait until buffered messages are written
+type Flusher interface {
+	Flush() error
+}
+
+// Queue that can stop waiting for queued messages when ctx is done
+type ContextCloser interface {
+	CloseContext(ctx context.Context) error
+}
+
+// close q with CloseContext if implemented, otherwise stop waiting for Close when ctx is done
+func CloseQueue(ctx context.Context, q Queue) error {
+	if c, ok := q.(ContextCloser); ok {
+		return c.CloseContext(ctx)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- q.Close()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// output that may not use Info_t.File and Info_t.Line, outputs without it need caller
+type CallerUser interface {
+	NeedCaller() bool
+}
+
+// true if any writer needs caller
+func NeedCaller(writers Queue_map_t) bool {
+	for _, v := range writers {
+		if c, ok := v.(CallerUser); !ok || c.NeedCaller() {
+			return true
+		}
+	}
+	return false
+}
+
+type NoCaller_t struct {
+	Queue
+}
+
+// output does not use file and line, caller is not resolved for levels with only such outputs
+func NoCaller(q Queue) Queue {
+	return NoCaller_t{Queue: q}
+}
+
+func (NoCaller_t) NeedCaller() bool {
+	return false
+}
+
+func (self NoCaller_t) Flush() error {
+	return Flush(self.Queue)
+}
+
+func (self NoCaller_t) CloseContext(ctx context.Context) error {
+	return CloseQueue(ctx, self.Queue)
+}
+
+func (self NoCaller_t) Reopen() error {
+	return Reopen(self.Queue)
+}
+
+// output that needs goroutine id of Log caller in Info_t.Gid
+type GoroutineIdUser interface {
+	NeedGoroutineId() bool
+}
+
+// true if any writer needs goroutine id
+func NeedGoroutineId(writers Queue_map_t) bool {
+	for _, v := range writers {
+		if g, ok := v.(GoroutineIdUser); ok && g.NeedGoroutineId() {
+			return true
+		}
+	}
+	return false
+}
+
+type WithGoroutineId_t struct {
+	Queue
+}
+
+// goroutine id is captured by Log for output with NewGoroutineId formatter behind a queue,
+// i.e. WithGoroutineId(NewWriterStdanyQueue(..., []Formatter{NewGoroutineId()}, ...))
+func WithGoroutineId(q Queue) Queue {
+	return WithGoroutineId_t{Queue: q}
+}
+
+func (WithGoroutineId_t) NeedGoroutineId() bool {
+	return true
+}
+
+func (self WithGoroutineId_t) NeedCaller() bool {
+	c, ok := self.Queue.(CallerUser)
+	return !ok || c.NeedCaller()
+}
+
+func (self WithGoroutineId_t) Flush() error {
+	return Flush(self.Queue)
+}
+
+func (self WithGoroutineId_t) CloseContext(ctx context.Context) error {
+	return CloseQueue(ctx, self.Queue)
+}
+
+func (self WithGoroutineId_t) Reopen() error {
+	return Reopen(self.Queue)
+}
+
+// file outputs reopen filename, i.e. after logrotate moved it
+type Reopener interface {
+	Reopen() error
+}
+
+func Reopen(q Queue) error {
+	if r, ok := q.(Reopener); ok {
+		return r.Reopen()
+	}
+	return nil
+}
+
+// flush q if it implements Flusher
+func Flush(q Queue) error {
+	if f, ok := q.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
 type Formatter interface {
 	FormatMessage(out io.Writer, in ...Msg_t) (int, error)
 }
 
 type Logger interface {
 	Log(ctx context.Context, level Info_t, format string, args ...any)
+	Enabled(level Info_t) bool
+	EnabledContext(ctx context.Context, level Info_t) bool
 
 	Trace(format string, args ...any)
 	Debug(format string, args ...any)
@@ -70,33 +253,193 @@ type Logger interface {
 
 	SwapLevelMap(Level_map_t) Level_map_t
 	CopyLevelMap() Level_map_t
+	UpdateLevelMap(fn func(Level_map_t) error) error
+	SetOutputLevel(writer_name string, levels []Info_t) error
+	RemoveOutput(writer_name string) (ok bool, err error)
 
 	Range(fn func(level_id int64, writer_name string, writer Queue) bool)
+	RangeOutputs(fn func(writer_name string, size QueueSize_t))
+	OutputInfo() []OutputInfo_t
+
+	Writer(level Info_t) io.WriteCloser
+
+	Flush() error
+
+	With(args ...any) Logger
+	WithCallerSkip(caller_skip int) Logger
+
+	Close() error
+	CloseContext(ctx context.Context) error
+}
+
+// level map with count of Log calls in progress, no lock is held while outputs are written.
+// Log calls after retire go to current generation, so blocked output delays only retire of its generation
+type level_gen_t struct {
+	levels  Level_map_t
+	active  atomic.Int64
+	retired atomic.Bool
+	once    sync.Once
+	done    chan struct{}
+}
+
+func new_level_gen(in Level_map_t) *level_gen_t {
+	return &level_gen_t{levels: in.Copy(Level_map_t{}), done: make(chan struct{})}
 }
 
 type log_t struct {
-	level_map atomic.Pointer[Level_map_t]
+	mx          *sync.Mutex
+	level_map   *atomic.Pointer[level_gen_t]
+	fields      []Field_t
+	caller_skip int
+	no_caller   bool
+	stack_level int64
+	stack_depth int
+}
+
+type LoggerOption func(self *log_t)
+
+// report caller_skip frames above caller, for wrappers over logger
+func CallerSkip(caller_skip int) LoggerOption {
+	return func(self *log_t) {
+		self.caller_skip = caller_skip
+	}
+}
+
+// do not resolve Info_t.File and Info_t.Line, see also NoCaller()
+func DisableCaller() LoggerOption {
+	return func(self *log_t) {
+		self.no_caller = true
+	}
+}
+
+// attach "stack" field with depth frames above caller to messages with LevelId >= level.LevelId, see CallerStack
+func StackTrace(level Info_t, depth int) LoggerOption {
+	return func(self *log_t) {
+		self.stack_level = level.LevelId
+		self.stack_depth = depth
+	}
 }
 
 // use NewLevelMap()
-func New(in Level_map_t) Logger {
-	self := &log_t{}
-	temp := in.Copy(Level_map_t{})
-	self.level_map.Store(&temp)
+func New(in Level_map_t, opts ...LoggerOption) Logger {
+	self := &log_t{
+		mx:        &sync.Mutex{},
+		level_map: &atomic.Pointer[level_gen_t]{},
+	}
+	for _, opt := range opts {
+		opt(self)
+	}
+	self.level_map.Store(new_level_gen(in))
 	return self
 }
 
+// current generation, caller releases it.
+// active is increased before retired is checked, so retire either sees the call or the call sees retired
+func (self *log_t) acquire() (gen *level_gen_t) {
+	for {
+		gen = self.level_map.Load()
+		gen.active.Add(1)
+		if !gen.retired.Load() {
+			return
+		}
+		gen.release()
+	}
+}
+
+func (self *level_gen_t) release() {
+	if self.active.Add(-1) == 0 && self.retired.Load() {
+		self.once.Do(func() { close(self.done) })
+	}
+}
+
+// wait for Log calls in progress with generation
+func (self *level_gen_t) retire() {
+	self.retire_context(context.Background())
+}
+
+// as retire but gives up when ctx is done
+func (self *level_gen_t) retire_context(ctx context.Context) error {
+	self.retired.Store(true)
+	if self.active.Load() == 0 {
+		self.once.Do(func() { close(self.done) })
+	}
+	select {
+	case <-self.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// copy of current level map is changed by fn and swapped unless fn fails, old generation is returned not retired.
+// updates are serialized with mx
+func (self *log_t) update(fn func(Level_map_t) error) (old *level_gen_t, err error) {
+	self.mx.Lock()
+	defer self.mx.Unlock()
+	old = self.level_map.Load()
+	temp := new_level_gen(old.levels)
+	if err = fn(temp.levels); err != nil {
+		return
+	}
+	self.level_map.Store(temp)
+	return
+}
+
+func (self *log_t) swap(in Level_map_t) (old *level_gen_t) {
+	self.mx.Lock()
+	defer self.mx.Unlock()
+	return self.level_map.Swap(new_level_gen(in))
+}
+
+// returns old level map when Log calls in progress with it are done, outputs of old map may be closed then.
+// do not call from LogWrite of output.
+// changes made between CopyLevelMap and SwapLevelMap are lost, see UpdateLevelMap
 func (self *log_t) SwapLevelMap(in Level_map_t) Level_map_t {
-	temp := in.Copy(Level_map_t{})
-	return *self.level_map.Swap(&temp)
+	old := self.swap(in)
+	old.retire()
+	return old.levels
+}
+
+// fn changes copy of current level map, copy is swapped unless fn returns error.
+// concurrent updates are applied one by one, none is lost.
+// outputs detached by fn may be closed after UpdateLevelMap returns, do not call from fn or LogWrite
+func (self *log_t) UpdateLevelMap(fn func(Level_map_t) error) (err error) {
+	old, err := self.update(fn)
+	if err == nil {
+		old.retire()
+	}
+	return
 }
 
 func (self *log_t) CopyLevelMap() (out Level_map_t) {
-	return (*self.level_map.Load()).Copy(Level_map_t{})
+	return self.level_map.Load().levels.Copy(Level_map_t{})
+}
+
+func (self *log_t) SetOutputLevel(writer_name string, levels []Info_t) (err error) {
+	return self.UpdateLevelMap(func(temp Level_map_t) error {
+		return temp.SetOutputLevel(writer_name, levels)
+	})
+}
+
+// detach writer_name from all levels, wait for Log calls in progress, then close it
+func (self *log_t) RemoveOutput(writer_name string) (ok bool, err error) {
+	var writer Queue
+	old, _ := self.update(func(temp Level_map_t) error {
+		if writer, ok = temp.RemoveOutput(writer_name); !ok {
+			return ERROR_OUTPUT_NOT_FOUND
+		}
+		return nil
+	})
+	if !ok {
+		return
+	}
+	old.retire()
+	err = writer.Close()
+	return
 }
 
 func (self *log_t) Range(fn func(level_id int64, writer_name string, writer Queue) bool) {
-	for level_id, level := range *self.level_map.Load() {
+	for level_id, level := range self.level_map.Load().levels {
 		for writer_name, writer := range level {
 			if fn(level_id, writer_name, writer) == false {
 				return
@@ -105,11 +448,123 @@ func (self *log_t) Range(fn func(level_id int64, writer_name string, writer Queu
 	}
 }
 
+// each writer once, sorted by name
+func (self *log_t) RangeOutputs(fn func(writer_name string, size QueueSize_t)) {
+	names, writers := self.level_map.Load().levels.Outputs()
+	for _, v := range names {
+		fn(v, writers[v].Size())
+	}
+}
+
+// snapshot of current outputs, see Level_map_t.OutputInfo()
+func (self *log_t) OutputInfo() []OutputInfo_t {
+	return self.level_map.Load().levels.OutputInfo()
+}
+
+// child logger shares outputs and adds key/value pairs to every message
+func (self *log_t) With(args ...any) Logger {
+	temp := *self
+	temp.fields = AppendFields(self.fields[:len(self.fields):len(self.fields)], args...)
+	return &temp
+}
+
+// child logger shares outputs and fields, reports caller_skip more frames above caller
+func (self *log_t) WithCallerSkip(caller_skip int) Logger {
+	temp := *self
+	temp.caller_skip += caller_skip
+	return &temp
+}
+
+// key/value pairs, non-string key is formatted with %v, missing value is nil
+func AppendFields(fields []Field_t, args ...any) []Field_t {
+	for i := 0; i < len(args); i += 2 {
+		var field Field_t
+		if key, ok := args[i].(string); ok {
+			field.Key = key
+		} else {
+			field.Key = fmt.Sprint(args[i])
+		}
+		if i+1 < len(args) {
+			field.Value = args[i+1]
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+func (self *log_t) Writer(level Info_t) io.WriteCloser {
+	return NewLogWriter(self, level)
+}
+
+// any output for level, guard for expensive arguments
+func (self *log_t) Enabled(level Info_t) bool {
+	return len(self.level_map.Load().levels[level.LevelId]) > 0
+}
+
+// as Enabled but level of ContextWithLevel is honored
+func (self *log_t) EnabledContext(ctx context.Context, level Info_t) bool {
+	levels := self.level_map.Load().levels
+	if len(levels[level.LevelId]) > 0 {
+		return true
+	}
+	if verbose, ok := GetContextLevel(ctx); ok && level.LevelId >= verbose.LevelId {
+		return len(levels.Above(level.LevelId)) > 0
+	}
+	return false
+}
+
 func (self *log_t) Log(ctx context.Context, level Info_t, format string, args ...any) {
-	level.Set(time.Now())
-	for _, writer := range (*self.level_map.Load())[level.LevelId] {
-		writer.LogWrite(Msg_t{Ctx: ctx, Info: level, Format: format, Args: args})
+	gen := self.acquire()
+	defer gen.release()
+	level_map := gen.levels
+	writers := level_map[level.LevelId]
+	// before level filter, message of flagged request without outputs at its level goes to outputs of nearest level above
+	if verbose, ok := GetContextLevel(ctx); ok && level.LevelId >= verbose.LevelId {
+		writers = level_map.Elevated(level.LevelId)
+	}
+	if len(writers) == 0 {
+		return
+	}
+	if self.no_caller || !NeedCaller(writers) {
+		if level.Ts.IsZero() {
+			level.Ts = Now()
+		}
+	} else {
+		level.SetSkip(Now(), self.caller_skip)
+	}
+	if level.Gid == 0 && NeedGoroutineId(writers) {
+		level.Gid = GoroutineId()
 	}
+	level.LevelName = LevelName(level)
+	fields := self.fields
+	if temp := GetContextFields(ctx); len(temp) > 0 {
+		fields = append(fields[:len(fields):len(fields)], temp...)
+	}
+	if self.stack_depth > 0 && level.LevelId >= self.stack_level {
+		fields = append(fields[:len(fields):len(fields)], Field_t{Key: "stack", Value: CallerStack(0, self.stack_depth, self.caller_skip)})
+	}
+	for _, writer := range writers {
+		writer.LogWrite(Msg_t{Ctx: ctx, Info: level, Format: format, Args: args, Fields: fields})
+	}
+}
+
+// wait until queued messages of all outputs are written
+func (self *log_t) Flush() error {
+	return self.level_map.Load().levels.Flush()
+}
+
+// remove all outputs, wait for Log calls in progress, then close outputs.
+// queued outputs write all queued messages before Close returns
+func (self *log_t) Close() error {
+	return self.SwapLevelMap(Level_map_t{}).Close()
+}
+
+// as Close but gives up when ctx is done, queued messages of unfinished outputs are dropped.
+// returns *CloseError_t with names of unfinished outputs
+func (self *log_t) CloseContext(ctx context.Context) error {
+	old := self.swap(Level_map_t{})
+	old.retire_context(ctx)
+	return old.levels.CloseContext(ctx)
 }
 
 func (self *log_t) Error(format string, args ...any) {
@@ -192,6 +647,26 @@ func TraceCtx(ctx context.Context, format string, args ...any) {
 	__std.TraceCtx(ctx, format, args...)
 }
 
+func Enabled(level Info_t) bool {
+	return __std.Enabled(level)
+}
+
+func EnabledContext(ctx context.Context, level Info_t) bool {
+	return __std.EnabledContext(ctx, level)
+}
+
+func With(args ...any) Logger {
+	return __std.With(args...)
+}
+
+func Close() error {
+	return __std.Close()
+}
+
+func CloseContext(ctx context.Context) error {
+	return __std.CloseContext(ctx)
+}
+
 func SetLogger(in Logger) Logger {
 	__std = in
 	return __std
@@ -0,0 +1,139 @@
+//
+//
+//
+
+package log
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// interval of NewAsync if not positive
+const ASYNC_INTERVAL = time.Second
+
+type Async_t struct {
+	mx          sync.Mutex
+	write_mx    sync.Mutex
+	next        Queue
+	buf         []Msg_t
+	buffer_size int
+	interval    time.Duration
+	queue_write int
+	closed      atomic.Bool
+	done        chan struct{}
+	wg          sync.WaitGroup
+}
+
+type AsyncOption func(self *Async_t)
+
+func AsyncInterval(interval time.Duration) AsyncOption {
+	return func(self *Async_t) {
+		self.interval = interval
+	}
+}
+
+// buffer up to buffer_size messages, pass them to next every interval (default ASYNC_INTERVAL), when buffer is full and on Flush.
+// Flush blocks until next has written messages if next implements Flusher, i.e. NewQueue based writers
+func NewAsync(next Queue, buffer_size int, opts ...AsyncOption) Queue {
+	self := &Async_t{
+		next:        next,
+		buffer_size: buffer_size,
+		interval:    ASYNC_INTERVAL,
+		done:        make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(self)
+	}
+	if self.interval <= 0 {
+		self.interval = ASYNC_INTERVAL
+	}
+	self.buf = make([]Msg_t, 0, self.buffer_size)
+	self.wg.Add(1)
+	go self.ticker()
+	return self
+}
+
+func (self *Async_t) ticker() {
+	defer self.wg.Done()
+	t := time.NewTicker(self.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-self.done:
+			return
+		case <-t.C:
+			self.__write()
+		}
+	}
+}
+
+func (self *Async_t) LogWrite(m Msg_t) (n int, err error) {
+	self.mx.Lock()
+	self.queue_write++
+	self.buf = append(self.buf, m)
+	full := len(self.buf) >= self.buffer_size
+	self.mx.Unlock()
+	if full {
+		self.__write()
+	}
+	return
+}
+
+// write_mx keeps order of buffers passed to next
+func (self *Async_t) __write() {
+	self.write_mx.Lock()
+	defer self.write_mx.Unlock()
+	self.mx.Lock()
+	buf := self.buf
+	self.buf = make([]Msg_t, 0, self.buffer_size)
+	self.mx.Unlock()
+	for _, v := range buf {
+		self.next.LogWrite(v)
+	}
+}
+
+func (self *Async_t) Flush() error {
+	self.__write()
+	return Flush(self.next)
+}
+
+func (self *Async_t) Reopen() error {
+	return Reopen(self.next)
+}
+
+func (self *Async_t) Size() (res QueueSize_t) {
+	res = self.next.Size()
+	self.mx.Lock()
+	res.Size += len(self.buf)
+	res.QueueWrite = self.queue_write
+	self.mx.Unlock()
+	return
+}
+
+func (self *Async_t) Close() error {
+	if !self.stop() {
+		return nil
+	}
+	return self.next.Close()
+}
+
+func (self *Async_t) CloseContext(ctx context.Context) error {
+	if !self.stop() {
+		return nil
+	}
+	return CloseQueue(ctx, self.next)
+}
+
+// buffered messages are passed to next after ticker is stopped, false if already stopped
+func (self *Async_t) stop() bool {
+	if self.closed.Swap(true) {
+		return false
+	}
+	close(self.done)
+	self.wg.Wait()
+	self.__write()
+	return true
+}
@@ -0,0 +1,232 @@
+//
+// CloudWatch Logs PutLogEvents
+//
+
+package log
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	CLOUDWATCH_MAX_EVENTS   = 10000
+	CLOUDWATCH_MAX_BYTES    = 1048576
+	CLOUDWATCH_EVENT_HEADER = 26
+	CLOUDWATCH_MAX_SPAN     = 24 * time.Hour
+)
+
+type CloudWatchEvent_t struct {
+	Timestamp int64 // milliseconds
+	Message   string
+}
+
+// adapter for CloudWatch Logs client, i.e. aws-sdk-go-v2 cloudwatchlogs.Client.
+// CreateLogGroup and CreateLogStream return nil if resource already exists.
+// PutLogEvents returns next sequence token, empty if not used.
+// InvalidSequenceToken and DataAlreadyAccepted errors should keep AWS message with expected token
+type CloudWatchClient interface {
+	CreateLogGroup(ctx context.Context, group string) error
+	CreateLogStream(ctx context.Context, group string, stream string) error
+	PutLogEvents(ctx context.Context, group string, stream string, token string, events []CloudWatchEvent_t) (string, error)
+}
+
+type CloudWatch_t struct {
+	ErrorHandler_t
+	client     CloudWatchClient
+	group      string
+	stream     string
+	message    Formatter
+	post_ctx   PostContext
+	retry      Retry_t
+	created    bool
+	token      string
+	bulk_write int
+}
+
+type CloudWatchOption func(self *CloudWatch_t)
+
+// override SetErrorHandler for this output
+func CloudWatchOnError(fn func(error)) CloudWatchOption {
+	return func(self *CloudWatch_t) {
+		self.on_error = fn
+	}
+}
+
+func CloudWatchTimeout(timeout time.Duration) CloudWatchOption {
+	return func(self *CloudWatch_t) {
+		self.post_ctx = &Timeout_t{timeout: timeout}
+	}
+}
+
+// failed PutLogEvents is retried with backoff, then batch is dropped and counted as write error
+func CloudWatchRetry(max_retries int, base time.Duration, max time.Duration) CloudWatchOption {
+	return func(self *CloudWatch_t) {
+		self.retry = Retry_t{max_retries: max_retries, base: base, max: max}
+	}
+}
+
+func CloudWatchBulkWrite(bulk_write int) CloudWatchOption {
+	return func(self *CloudWatch_t) {
+		if bulk_write > 0 {
+			self.bulk_write = bulk_write
+		}
+	}
+}
+
+// single writer, sequence token is kept between batches.
+// group and stream are created on first write and after failed put
+func NewCloudWatch(queue_size int, client CloudWatchClient, group string, stream string, message Formatter, opts ...CloudWatchOption) Queue {
+	self := &CloudWatch_t{
+		client:     client,
+		group:      group,
+		stream:     stream,
+		message:    message,
+		post_ctx:   NoTimeout_t{},
+		retry:      Retry_t{max_retries: 3, base: 100 * time.Millisecond, max: 5 * time.Second},
+		bulk_write: 1024,
+	}
+
+	for _, opt := range opts {
+		opt(self)
+	}
+
+	q := NewQueue(queue_size)
+	q.WgAdd(1)
+	go self.writer(q)
+
+	return q
+}
+
+func (self *CloudWatch_t) writer(q *Queue_t) (err error) {
+	defer q.WgDone()
+
+	var body bytes.Buffer
+	var events []CloudWatchEvent_t
+	for {
+		msg, ok := q.LogRead(self.bulk_write)
+		if !ok {
+			return
+		}
+		events = events[:0]
+		for _, v := range msg {
+			body.Reset()
+			if _, err = self.message.FormatMessage(&body, v); err != nil {
+				q.WriteError(1, err.Error())
+				self.handle_error(err)
+				continue
+			}
+			events = append(events, CloudWatchEvent_t{
+				Timestamp: v.Info.Ts.UnixMilli(),
+				Message:   string(bytes.TrimSuffix(body.Bytes(), []byte("\n"))),
+			})
+		}
+		sort.SliceStable(events, func(i, j int) bool { return events[i].Timestamp < events[j].Timestamp })
+		for _, batch := range CloudWatchBatches(events) {
+			if err = self.put(q, batch); err != nil {
+				q.WriteError(len(batch), err.Error())
+				self.handle_error(err)
+			}
+		}
+	}
+}
+
+// sorted events split by count, size and time span limits of PutLogEvents
+func CloudWatchBatches(events []CloudWatchEvent_t) (res [][]CloudWatchEvent_t) {
+	var start, size int
+	for i, v := range events {
+		event_size := len(v.Message) + CLOUDWATCH_EVENT_HEADER
+		if i > start && (i-start >= CLOUDWATCH_MAX_EVENTS || size+event_size > CLOUDWATCH_MAX_BYTES ||
+			v.Timestamp-events[start].Timestamp >= CLOUDWATCH_MAX_SPAN.Milliseconds()) {
+			res = append(res, events[start:i])
+			start, size = i, 0
+		}
+		size += event_size
+	}
+	if start < len(events) {
+		res = append(res, events[start:])
+	}
+	return
+}
+
+func (self *CloudWatch_t) put(q *Queue_t, events []CloudWatchEvent_t) (err error) {
+	for attempt := 0; ; attempt++ {
+		if !self.created {
+			err = self.create()
+		}
+		if self.created {
+			if err = self.put_events(events); err == nil {
+				return
+			}
+			self.created = false
+		}
+		if attempt >= self.retry.max_retries {
+			return
+		}
+		q.Retry(1)
+		time.Sleep(self.retry.Backoff(attempt, err))
+	}
+}
+
+// InvalidSequenceToken is retried once with expected token from error,
+// DataAlreadyAccepted is not an error since batch is stored already
+func (self *CloudWatch_t) put_events(events []CloudWatchEvent_t) (err error) {
+	for attempt := 0; ; attempt++ {
+		if err = self.put_token(events); err == nil {
+			return
+		}
+		token, ok := cloudwatch_token(err)
+		if !ok {
+			return
+		}
+		self.token = token
+		if strings.Contains(err.Error(), "DataAlreadyAccepted") {
+			return nil
+		}
+		if attempt > 0 || !strings.Contains(err.Error(), "InvalidSequenceToken") {
+			return
+		}
+	}
+}
+
+func (self *CloudWatch_t) put_token(events []CloudWatchEvent_t) error {
+	ctx, cancel := self.post_ctx.WithTimeout(context.Background())
+	defer cancel()
+	token, err := self.client.PutLogEvents(ctx, self.group, self.stream, self.token, events)
+	if err == nil {
+		self.token = token
+	}
+	return err
+}
+
+// "The next expected sequenceToken is: 4959..." or "The next batch can be sent with sequenceToken: 4959...",
+// "null" is empty token of new stream
+var __cloudwatch_token = regexp.MustCompile(`sequenceToken(?: is)?: (\S+)`)
+
+func cloudwatch_token(err error) (token string, ok bool) {
+	m := __cloudwatch_token.FindStringSubmatch(err.Error())
+	if m == nil {
+		return
+	}
+	if m[1] == "null" {
+		return "", true
+	}
+	return m[1], true
+}
+
+func (self *CloudWatch_t) create() (err error) {
+	ctx, cancel := self.post_ctx.WithTimeout(context.Background())
+	defer cancel()
+	if err = self.client.CreateLogGroup(ctx, self.group); err != nil {
+		return
+	}
+	if err = self.client.CreateLogStream(ctx, self.group, self.stream); err != nil {
+		return
+	}
+	self.created = true
+	return
+}
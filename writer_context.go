@@ -20,6 +20,12 @@ import (
 // &log_ctx used for ctx.Value
 var log_ctx = 1
 
+// &log_fields used for ctx.Value
+var log_fields = 1
+
+// &log_level used for ctx.Value
+var log_level = 1
+
 type RangeFn_t = func(ts time.Time, file string, line int, level_name string, level_id int64, format string, args ...any) bool
 
 type LogContext interface {
@@ -34,10 +40,43 @@ func SetLogContext(ctx context.Context, value LogContext) context.Context {
 }
 
 func GetLogContext(ctx context.Context) (value LogContext) {
+	if ctx == nil {
+		return
+	}
 	value, _ = ctx.Value(&log_ctx).(LogContext)
 	return
 }
 
+// key/value pairs added to every message logged with ctx
+func ContextWithFields(ctx context.Context, args ...any) context.Context {
+	fields := GetContextFields(ctx)
+	return context.WithValue(ctx, &log_fields, AppendFields(fields[:len(fields):len(fields)], args...))
+}
+
+func GetContextFields(ctx context.Context) (fields []Field_t) {
+	if ctx == nil {
+		return
+	}
+	fields, _ = ctx.Value(&log_fields).([]Field_t)
+	return
+}
+
+// messages logged with ctx and LevelId >= level.LevelId are written to outputs of their level,
+// level without outputs is promoted to nearest higher level with outputs, i.e. DEBUG of request marked with LOG_TRACE
+// goes where INFO goes. messages of levels with outputs are not written to outputs of higher levels.
+// see Logger.EnabledContext
+func ContextWithLevel(ctx context.Context, level Info_t) context.Context {
+	return context.WithValue(ctx, &log_level, level)
+}
+
+func GetContextLevel(ctx context.Context) (level Info_t, ok bool) {
+	if ctx == nil {
+		return
+	}
+	level, ok = ctx.Value(&log_level).(Info_t)
+	return
+}
+
 type LogContext_t struct {
 	mx    sync.Mutex
 	name  string
@@ -0,0 +1,131 @@
+//
+//
+//
+
+package log
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type Dedup_t struct {
+	mx       sync.Mutex
+	next     Queue
+	window   time.Duration
+	rendered []byte
+	level    int64
+	start    time.Time
+	repeated int
+	last     Msg_t
+	dropped  int
+	streak   int
+	timer    *time.Timer
+	closed   bool
+}
+
+// identical consecutive messages (level, format and rendered args) within window are written once,
+// then "(repeated N times)" summary when streak ends or window elapses.
+// summary is written by subsequent LogWrite, by timer when window elapses and by Close
+func NewDedup(next Queue, window time.Duration) Queue {
+	return &Dedup_t{next: next, window: window}
+}
+
+func (self *Dedup_t) LogWrite(m Msg_t) (n int, err error) {
+	buf := GetBuffer()
+	defer PutBuffer(buf)
+	buf.WriteString(m.Format)
+	buf.WriteByte(0)
+	fmt.Fprintf(buf, m.Format, m.Args...)
+
+	self.mx.Lock()
+	// window is measured by message Ts only, timer is armed for rest of window
+	if elapsed := m.Info.Ts.Sub(self.start); m.Info.LevelId == self.level && bytes.Equal(buf.Bytes(), self.rendered) && elapsed < self.window {
+		if self.repeated++; self.repeated == 1 && !self.closed {
+			streak := self.streak
+			self.timer = time.AfterFunc(self.window-elapsed, func() { self.expire(streak) })
+		}
+		self.dropped++
+		self.last = m
+		self.mx.Unlock()
+		return
+	}
+	summary, ok := self.__summary()
+	self.rendered = append(self.rendered[:0], buf.Bytes()...)
+	self.level = m.Info.LevelId
+	self.start = m.Info.Ts
+	self.streak++
+	self.mx.Unlock()
+	if ok {
+		self.next.LogWrite(summary)
+	}
+	return self.next.LogWrite(m)
+}
+
+// summary of streak is written under lock, so it is not written after Close
+func (self *Dedup_t) expire(streak int) {
+	self.mx.Lock()
+	defer self.mx.Unlock()
+	if self.streak != streak || self.closed {
+		return
+	}
+	if summary, ok := self.__summary(); ok {
+		self.next.LogWrite(summary)
+	}
+}
+
+func (self *Dedup_t) __summary() (m Msg_t, ok bool) {
+	if self.timer != nil {
+		self.timer.Stop()
+		self.timer = nil
+	}
+	if self.repeated == 0 {
+		return
+	}
+	m = self.last
+	m.Format += " (repeated %d times)"
+	m.Args = append(m.Args[:len(m.Args):len(m.Args)], self.repeated)
+	self.repeated = 0
+	self.last = Msg_t{}
+	return m, true
+}
+
+func (self *Dedup_t) Size() (res QueueSize_t) {
+	res = self.next.Size()
+	self.mx.Lock()
+	res.QueueSampled += self.dropped
+	self.mx.Unlock()
+	return
+}
+
+func (self *Dedup_t) Flush() error {
+	return Flush(self.next)
+}
+
+func (self *Dedup_t) Reopen() error {
+	return Reopen(self.next)
+}
+
+func (self *Dedup_t) Close() error {
+	self.stop()
+	return self.next.Close()
+}
+
+func (self *Dedup_t) CloseContext(ctx context.Context) error {
+	self.stop()
+	return CloseQueue(ctx, self.next)
+}
+
+func (self *Dedup_t) stop() {
+	self.mx.Lock()
+	summary, ok := self.__summary()
+	self.rendered = self.rendered[:0]
+	self.closed = true
+	self.mx.Unlock()
+	if ok {
+		self.next.LogWrite(summary)
+	}
+}
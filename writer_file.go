@@ -0,0 +1,133 @@
+//
+// Rotated files
+//
+
+package log
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// existing "filename.N.TS" and "filename.N.TS.gz" sorted oldest first
+func ScanBackups(filename string) (res []string) {
+	type file_t struct {
+		name string
+		info os.FileInfo
+	}
+	var files []file_t
+	re := regexp.MustCompile(`^` + regexp.QuoteMeta(filepath.Base(filename)) + `\.\d+\.[^/]+$`)
+	entries, _ := os.ReadDir(filepath.Dir(filename))
+	for _, v := range entries {
+		if v.IsDir() || !re.MatchString(v.Name()) || strings.HasSuffix(v.Name(), ".tmp") {
+			continue
+		}
+		if info, err := v.Info(); err == nil {
+			files = append(files, file_t{name: filepath.Join(filepath.Dir(filename), v.Name()), info: info})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].info.ModTime().Equal(files[j].info.ModTime()) {
+			return files[i].name < files[j].name
+		}
+		return files[i].info.ModTime().Before(files[j].info.ModTime())
+	})
+	for _, v := range files {
+		res = append(res, v.name)
+	}
+	return
+}
+
+// oldest files over backup_count are removed,
+// source of backup being compressed is removed too
+func PruneBackups(files []string, backup_count int) []string {
+	for len(files) > backup_count {
+		os.Remove(files[0])
+		if strings.HasSuffix(files[0], ".gz") {
+			os.Remove(strings.TrimSuffix(files[0], ".gz"))
+		}
+		files = files[1:]
+	}
+	return files
+}
+
+// files modified before ts - max_age are removed.
+// files from future, i.e. clock moved backward, are kept
+func PruneBackupsAge(files []string, ts time.Time, max_age time.Duration) (res []string) {
+	for _, v := range files {
+		st, err := os.Stat(v)
+		if err != nil {
+			st, err = os.Stat(strings.TrimSuffix(v, ".gz"))
+		}
+		if err == nil && ts.Sub(st.ModTime()) > max_age {
+			os.Remove(v)
+			if strings.HasSuffix(v, ".gz") {
+				os.Remove(strings.TrimSuffix(v, ".gz"))
+			}
+			continue
+		}
+		res = append(res, v)
+	}
+	return
+}
+
+// oldest files are removed while total size is over max_total
+func PruneBackupsSize(files []string, max_total int) []string {
+	var total int64
+	sizes := make([]int64, len(files))
+	for i, v := range files {
+		if st, err := os.Stat(v); err == nil {
+			sizes[i] = st.Size()
+		} else if st, err := os.Stat(strings.TrimSuffix(v, ".gz")); err == nil {
+			sizes[i] = st.Size()
+		}
+		total += sizes[i]
+	}
+	for len(files) > 0 && total > int64(max_total) {
+		os.Remove(files[0])
+		if strings.HasSuffix(files[0], ".gz") {
+			os.Remove(strings.TrimSuffix(files[0], ".gz"))
+		}
+		total -= sizes[0]
+		files, sizes = files[1:], sizes[1:]
+	}
+	return files
+}
+
+// filename -> filename.gz, mx is writer lock held by PruneBackups
+func (self *WriterOptions_t) CompressBackup(filename string, mx sync.Locker) (err error) {
+	in, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	out, err := self.OpenFile(filename+".gz.tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return
+	}
+	gz := gzip.NewWriter(out)
+	if _, err = io.Copy(gz, in); err == nil {
+		err = gz.Close()
+	}
+	if err2 := out.Close(); err == nil {
+		err = err2
+	}
+	mx.Lock()
+	defer mx.Unlock()
+	// pruned while compressing
+	if _, err2 := os.Stat(filename); err != nil || err2 != nil {
+		os.Remove(filename + ".gz.tmp")
+		return
+	}
+	if err = os.Rename(filename+".gz.tmp", filename+".gz"); err == nil {
+		os.Remove(filename)
+	}
+	return
+}
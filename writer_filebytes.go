@@ -5,6 +5,7 @@
 package log
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"os"
@@ -15,11 +16,15 @@ import (
 var FileBytesFormat = "20060102150405"
 
 type WriterFileBytes_t struct {
+	WriterOptions_t
 	mx              sync.Mutex
 	prefix          []Formatter
 	out             *os.File
+	buf             bytes.Buffer
 	filename        string
 	files           []string
+	compress_wg     sync.WaitGroup
+	last_sync       time.Time
 	bytes_limit     int
 	bytes_count     int
 	backup_count    int
@@ -31,25 +36,29 @@ type WriterFileBytes_t struct {
 	bulk_write      int
 }
 
-func NewWriterFileBytes(ts time.Time, filename string, prefix []Formatter, bytes_limit int, backup_count int, log_limit int) (Queue, error) {
+func NewWriterFileBytes(ts time.Time, filename string, prefix []Formatter, bytes_limit int, backup_count int, log_limit int, opts ...WriterOption) (Queue, error) {
 	self := &WriterFileBytes_t{
-		prefix:       prefix,
-		filename:     filename,
-		bytes_limit:  bytes_limit,
-		backup_count: backup_count,
-		log_limit:    log_limit,
+		WriterOptions_t: NewWriterOptions(opts...),
+		prefix:          prefix,
+		filename:        filename,
+		files:           ScanBackups(filename),
+		bytes_limit:     bytes_limit,
+		backup_count:    backup_count,
+		log_limit:       log_limit,
 	}
 	return self, self.__cycle(ts)
 }
 
-func NewWriterFileBytesQueue(queue_size int, writers int, ts time.Time, filename string, prefix []Formatter, bytes_limit int, backup_count int, log_limit int) (Queue, error) {
+func NewWriterFileBytesQueue(queue_size int, writers int, ts time.Time, filename string, prefix []Formatter, bytes_limit int, backup_count int, log_limit int, opts ...WriterOption) (Queue, error) {
 	self := &WriterFileBytes_t{
-		prefix:       prefix,
-		filename:     filename,
-		bytes_limit:  bytes_limit,
-		backup_count: backup_count,
-		log_limit:    log_limit,
-		bulk_write:   16,
+		WriterOptions_t: NewWriterOptions(opts...),
+		prefix:          prefix,
+		filename:        filename,
+		files:           ScanBackups(filename),
+		bytes_limit:     bytes_limit,
+		backup_count:    backup_count,
+		log_limit:       log_limit,
+		bulk_write:      16,
 	}
 
 	err := self.__cycle(ts)
@@ -58,11 +67,12 @@ func NewWriterFileBytesQueue(queue_size int, writers int, ts time.Time, filename
 	}
 
 	q := NewQueue(queue_size)
+	q.reopen = self.Reopen
 	for i := 0; i < writers; i++ {
 		q.WgAdd(1)
 		go self.writer(q)
 	}
-	return q, err
+	return &WriterQueue_t{Queue_t: q, writer: self}, err
 }
 
 func (self *WriterFileBytes_t) writer(q *Queue_t) (err error) {
@@ -80,35 +90,40 @@ func (self *WriterFileBytes_t) writer(q *Queue_t) (err error) {
 	}
 }
 
+// message is formatted first, file is rotated before write if message does not fit into bytes_limit.
+// message is never split across files, message larger than bytes_limit goes to separate file.
 func (self *WriterFileBytes_t) LogWrite(m Msg_t) (n int, err error) {
 	self.mx.Lock()
 	defer self.mx.Unlock()
 	self.queue_write++
+	self.buf.Reset()
 	var w io.Writer
 	if self.log_limit > 0 {
-		w = &LimitWriter_t{Buf: self.out, Limit: self.log_limit}
+		w = &LimitWriter_t{Buf: &self.buf, Limit: self.log_limit, Ellipsis: self.ellipsis}
 	} else {
-		w = self.out
+		w = &self.buf
 	}
 	for _, v := range self.prefix {
-		n, err = v.FormatMessage(w, m)
-		self.bytes_count += n
+		v.FormatMessage(w, m)
 	}
-	n, err = io.WriteString(w, m.Info.LevelName)
-	self.bytes_count += n
-	n, err = io.WriteString(w, " ")
-	self.bytes_count += n
-	n, err = fmt.Fprintf(w, m.Format, m.Args...)
-	self.bytes_count += n
-	n, err = io.WriteString(self.out, "\n")
-	self.bytes_count += n
-	if self.bytes_count >= self.bytes_limit {
+	_, err = self.message.FormatMessage(w, m)
+	self.buf.WriteString("\n")
+	if self.bytes_count > 0 && self.bytes_count+self.buf.Len() > self.bytes_limit {
 		self.__cycle(m.Info.Ts)
 		self.bytes_count = 0
 	}
+	n, err2 := self.out.Write(self.buf.Bytes())
+	self.bytes_count += n
+	if err2 != nil {
+		err = err2
+	}
+	if err2 = self.__sync(m.Info.Ts); err2 != nil {
+		err = err2
+	}
 	if err != nil {
 		self.write_error_cnt++
 		self.write_error_msg = err.Error()
+		self.handle_error(err)
 	}
 	return
 }
@@ -125,11 +140,40 @@ func (self *WriterFileBytes_t) Size() (res QueueSize_t) {
 func (self *WriterFileBytes_t) Close() (err error) {
 	self.mx.Lock()
 	if self.out != nil {
+		if self.sync {
+			self.out.Sync()
+		}
 		if err = self.out.Close(); err == nil {
 			self.out = nil
 		}
 	}
 	self.mx.Unlock()
+	self.compress_wg.Wait()
+	return
+}
+
+// close and open filename for append, no rotation
+func (self *WriterFileBytes_t) Reopen() (err error) {
+	self.mx.Lock()
+	defer self.mx.Unlock()
+	if self.out != nil {
+		self.out.Close()
+	}
+	if self.out, err = self.OpenFile(self.filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND); err != nil {
+		return
+	}
+	self.bytes_count = 0
+	if st, err := self.out.Stat(); err == nil {
+		self.bytes_count = int(st.Size())
+	}
+	return
+}
+
+func (self *WriterFileBytes_t) __sync(ts time.Time) (err error) {
+	if self.sync && (self.sync_every == 0 || ts.Sub(self.last_sync) >= self.sync_every) {
+		err = self.out.Sync()
+		self.last_sync = ts
+	}
 	return
 }
 
@@ -139,12 +183,23 @@ func (self *WriterFileBytes_t) __cycle(ts time.Time) (err error) {
 		backlog_file := fmt.Sprintf("%s.%d.%s", self.filename, self.cycle, ts.Format(FileBytesFormat))
 		self.out.Close()
 		os.Rename(self.filename, backlog_file)
+		if self.compress {
+			self.compress_wg.Add(1)
+			go func(filename string) {
+				defer self.compress_wg.Done()
+				self.CompressBackup(filename, &self.mx)
+			}(backlog_file)
+			backlog_file += ".gz"
+		}
 		self.files = append(self.files, backlog_file)
 	}
-	if len(self.files) > self.backup_count {
-		os.Remove(self.files[0])
-		self.files = self.files[1:]
+	self.files = PruneBackups(self.files, self.backup_count)
+	if self.max_age > 0 {
+		self.files = PruneBackupsAge(self.files, ts, self.max_age)
+	}
+	if self.max_total > 0 {
+		self.files = PruneBackupsSize(self.files, self.max_total)
 	}
-	self.out, err = os.OpenFile(self.filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC /*|os.O_APPEND*/, 0644)
+	self.out, err = self.OpenFile(self.filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC /*|os.O_APPEND*/)
 	return
 }
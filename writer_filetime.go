@@ -15,12 +15,15 @@ import (
 var FileTime = "20060102150405"
 
 type WriterFileTime_t struct {
+	WriterOptions_t
 	mx              sync.Mutex
 	last_date       time.Time
 	prefix          []Formatter
 	out             *os.File
 	filename        string
 	files           []string
+	compress_wg     sync.WaitGroup
+	last_sync       time.Time
 	truncate        time.Duration
 	backup_count    int
 	cycle           int
@@ -31,41 +34,48 @@ type WriterFileTime_t struct {
 	bulk_write      int
 }
 
-func NewWriterFileTime(ts time.Time, filename string, prefix []Formatter, truncate time.Duration, backup_count int, log_limit int) (Queue, error) {
+// active file always keeps filename, rotated files are renamed to "filename.N.TS"
+// so "tail -F filename" follows rotation without symlink, CurrentSymlink adds one if needed
+func NewWriterFileTime(ts time.Time, filename string, prefix []Formatter, truncate time.Duration, backup_count int, log_limit int, opts ...WriterOption) (Queue, error) {
 	self := &WriterFileTime_t{
-		prefix:       prefix,
-		filename:     filename,
-		truncate:     truncate,
-		backup_count: backup_count,
-		last_date:    ts,
-		log_limit:    log_limit,
-	}
-	return self, self.__cycle(self.last_date)
+		WriterOptions_t: NewWriterOptions(opts...),
+		prefix:          prefix,
+		filename:        filename,
+		files:           ScanBackups(filename),
+		truncate:        truncate,
+		backup_count:    backup_count,
+		log_limit:       log_limit,
+	}
+	self.last_date = self.__period(ts)
+	return self, self.__cycle(ts)
 }
 
-func NewWriterFileTimeQueue(queue_size, writers int, ts time.Time, filename string, prefix []Formatter, truncate time.Duration, backup_count int, log_limit int) (Queue, error) {
+func NewWriterFileTimeQueue(queue_size, writers int, ts time.Time, filename string, prefix []Formatter, truncate time.Duration, backup_count int, log_limit int, opts ...WriterOption) (Queue, error) {
 	self := &WriterFileTime_t{
-		prefix:       prefix,
-		filename:     filename,
-		truncate:     truncate,
-		backup_count: backup_count,
-		last_date:    ts,
-		log_limit:    log_limit,
-		bulk_write:   16,
+		WriterOptions_t: NewWriterOptions(opts...),
+		prefix:          prefix,
+		filename:        filename,
+		files:           ScanBackups(filename),
+		truncate:        truncate,
+		backup_count:    backup_count,
+		log_limit:       log_limit,
+		bulk_write:      16,
 	}
 
-	err := self.__cycle(self.last_date)
+	self.last_date = self.__period(ts)
+	err := self.__cycle(ts)
 	if err != nil {
 		return nil, err
 	}
 
 	q := NewQueue(queue_size)
+	q.reopen = self.Reopen
 	for i := 0; i < writers; i++ {
 		q.WgAdd(1)
 		go self.writer(q)
 	}
 
-	return q, err
+	return &WriterQueue_t{Queue_t: q, writer: self}, err
 }
 
 func (self *WriterFileTime_t) writer(q *Queue_t) (err error) {
@@ -87,26 +97,29 @@ func (self *WriterFileTime_t) LogWrite(m Msg_t) (n int, err error) {
 	self.mx.Lock()
 	defer self.mx.Unlock()
 	self.queue_write++
+	// rotate before taking self.out
+	if tr := self.__period(m.Info.Ts); !self.last_date.Equal(tr) {
+		self.__cycle(m.Info.Ts)
+		self.last_date = tr
+	}
 	var w io.Writer
 	if self.log_limit > 0 {
-		w = &LimitWriter_t{Buf: self.out, Limit: self.log_limit}
+		w = &LimitWriter_t{Buf: self.out, Limit: self.log_limit, Ellipsis: self.ellipsis}
 	} else {
 		w = self.out
 	}
-	if tr := m.Info.Ts.Truncate(self.truncate); !self.last_date.Equal(tr) {
-		self.__cycle(m.Info.Ts)
-		self.last_date = tr
-	}
 	for _, v := range self.prefix {
 		v.FormatMessage(w, m)
 	}
-	io.WriteString(w, m.Info.LevelName)
-	io.WriteString(w, " ")
-	n, err = fmt.Fprintf(w, m.Format, m.Args...)
+	n, err = self.message.FormatMessage(w, m)
 	io.WriteString(self.out, "\n")
+	if err2 := self.__sync(m.Info.Ts); err2 != nil {
+		err = err2
+	}
 	if err != nil {
 		self.write_error_cnt++
 		self.write_error_msg = err.Error()
+		self.handle_error(err)
 	}
 	return
 }
@@ -123,26 +136,85 @@ func (self *WriterFileTime_t) Size() (res QueueSize_t) {
 func (self *WriterFileTime_t) Close() (err error) {
 	self.mx.Lock()
 	if self.out != nil {
+		if self.sync {
+			self.out.Sync()
+		}
 		if err = self.out.Close(); err == nil {
 			self.out = nil
 		}
 	}
 	self.mx.Unlock()
+	self.compress_wg.Wait()
 	return
 }
 
+// close and open filename for append, no rotation
+func (self *WriterFileTime_t) Reopen() (err error) {
+	self.mx.Lock()
+	defer self.mx.Unlock()
+	if self.out != nil {
+		self.out.Close()
+	}
+	self.out, err = self.OpenFile(self.filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND)
+	return
+}
+
+func (self *WriterFileTime_t) __sync(ts time.Time) (err error) {
+	if self.sync && (self.sync_every == 0 || ts.Sub(self.last_sync) >= self.sync_every) {
+		err = self.out.Sync()
+		self.last_sync = ts
+	}
+	return
+}
+
+// start of period containing ts
+func (self *WriterFileTime_t) __period(ts time.Time) time.Time {
+	if self.align_loc == nil || self.align <= 0 {
+		return ts.Truncate(self.truncate)
+	}
+	const day = 24 * time.Hour
+	t := ts.In(self.align_loc)
+	year, month, mday := t.Date()
+	if self.align >= day {
+		days := time.Date(year, month, mday, 0, 0, 0, 0, time.UTC).Unix() / 86400
+		days -= days % int64(self.align/day)
+		year, month, mday = time.Unix(days*86400, 0).UTC().Date()
+		return time.Date(year, month, mday, 0, 0, 0, 0, self.align_loc)
+	}
+	hour, min, sec := t.Clock()
+	wall := time.Duration(hour)*time.Hour + time.Duration(min)*time.Minute + time.Duration(sec)*time.Second
+	wall -= wall % self.align
+	return time.Date(year, month, mday, 0, 0, int(wall/time.Second), 0, self.align_loc)
+}
+
 func (self *WriterFileTime_t) __cycle(ts time.Time) (err error) {
 	if self.out != nil {
 		self.cycle++
 		self.out.Close()
 		backlog_file := fmt.Sprintf("%s.%d.%s", self.filename, self.cycle, ts.Format(FileTime))
 		os.Rename(self.filename, backlog_file)
+		if self.compress {
+			self.compress_wg.Add(1)
+			go func(filename string) {
+				defer self.compress_wg.Done()
+				self.CompressBackup(filename, &self.mx)
+			}(backlog_file)
+			backlog_file += ".gz"
+		}
 		self.files = append(self.files, backlog_file)
 	}
-	if len(self.files) > self.backup_count {
-		os.Remove(self.files[0])
-		self.files = self.files[1:]
+	self.files = PruneBackups(self.files, self.backup_count)
+	if self.max_age > 0 {
+		self.files = PruneBackupsAge(self.files, ts, self.max_age)
+	}
+	if self.max_total > 0 {
+		self.files = PruneBackupsSize(self.files, self.max_total)
+	}
+	if self.out, err = self.OpenFile(self.filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC /*|os.O_APPEND*/); err != nil {
+		return
+	}
+	if err2 := self.update_symlink(self.filename); err2 != nil {
+		self.handle_error(err2)
 	}
-	self.out, err = os.OpenFile(self.filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC /*|os.O_APPEND*/, 0644)
 	return
 }
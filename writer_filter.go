@@ -0,0 +1,45 @@
+//
+//
+//
+
+package log
+
+import "context"
+
+type Filter_t struct {
+	next Queue
+	pred func(m Msg_t) bool
+}
+
+// pred is called on write path for every message, keep it fast.
+// messages with pred false are not counted as sampled
+func NewFilter(next Queue, pred func(m Msg_t) bool) Queue {
+	return &Filter_t{next: next, pred: pred}
+}
+
+func (self *Filter_t) LogWrite(m Msg_t) (n int, err error) {
+	if self.pred(m) {
+		return self.next.LogWrite(m)
+	}
+	return
+}
+
+func (self *Filter_t) Size() QueueSize_t {
+	return self.next.Size()
+}
+
+func (self *Filter_t) Flush() error {
+	return Flush(self.next)
+}
+
+func (self *Filter_t) Reopen() error {
+	return Reopen(self.next)
+}
+
+func (self *Filter_t) Close() error {
+	return self.next.Close()
+}
+
+func (self *Filter_t) CloseContext(ctx context.Context) error {
+	return CloseQueue(ctx, self.next)
+}
@@ -0,0 +1,274 @@
+//
+// GELF 1.1
+//
+
+package log
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ERROR_GELF_CHUNKS = errors.New("GELF TOO MANY CHUNKS")
+
+const (
+	GELF_NONE = 0
+	GELF_GZIP = 1
+	GELF_ZLIB = 2
+
+	// max 8192 datagram minus 12 bytes chunk header
+	GELF_CHUNK_SIZE = 8180
+	GELF_CHUNK_MAX  = 128
+)
+
+type MessageGELF_t struct {
+	Version      string  `json:"version"`
+	Host         string  `json:"host"`
+	ShortMessage string  `json:"short_message"`
+	Timestamp    float64 `json:"timestamp"`
+	Level        int     `json:"level"`
+	File         string  `json:"_file,omitempty"`
+	Line         int     `json:"_line,omitempty"`
+	Context      string  `json:"_ctx,omitempty"`
+	TextLimit    int     `json:"-"`
+}
+
+func (self MessageGELF_t) FormatMessage(out io.Writer, in ...Msg_t) (n int, err error) {
+	var buf strings.Builder
+
+	if self.TextLimit == 0 {
+		self.TextLimit = math.MaxInt
+	}
+
+	w := &LimitWriter_t{Buf: &buf, Limit: self.TextLimit}
+	doc := GetBuffer()
+	defer PutBuffer(doc)
+	enc := json.NewEncoder(doc)
+
+	self.Version = "1.1"
+	for _, v := range in {
+		buf.Reset()
+		w.Limit = self.TextLimit
+		fmt.Fprintf(w, v.Format, v.Args...)
+		self.ShortMessage = buf.String()
+		self.Timestamp = float64(v.Info.Ts.UnixMilli()) / 1000
+		self.Level = SyslogSeverity(v.Info.LevelId)
+		self.File = v.Info.File
+		self.Line = v.Info.Line
+		self.Context = ""
+		if c := GetLogContext(v.Ctx); c != nil {
+			self.Context = c.ContextName()
+		}
+		doc.Reset()
+		if err = enc.Encode(self); err != nil {
+			return
+		}
+		if len(v.Fields) > 0 {
+			doc.Truncate(bytes.LastIndexByte(doc.Bytes(), '}'))
+			JsonFields(doc, "_", v.Fields, "_id", "_file", "_line", "_ctx")
+			doc.WriteString("}\n")
+		}
+		if _, err = out.Write(doc.Bytes()); err != nil {
+			return
+		}
+	}
+	return
+}
+
+type WriterGelf_t struct {
+	ErrorHandler_t
+	mx              sync.Mutex
+	conn            net.Conn
+	message         MessageGELF_t
+	compress        int
+	chunk_size      int
+	msg_id          uint64
+	queue_write     int
+	write_error_cnt int
+	write_error_msg string
+	bulk_write      int
+}
+
+type GelfOption func(self *WriterGelf_t)
+
+// override SetErrorHandler for this output
+func GelfOnError(fn func(error)) GelfOption {
+	return func(self *WriterGelf_t) {
+		self.on_error = fn
+	}
+}
+
+func GelfGzip() GelfOption {
+	return func(self *WriterGelf_t) {
+		self.compress = GELF_GZIP
+	}
+}
+
+func GelfZlib() GelfOption {
+	return func(self *WriterGelf_t) {
+		self.compress = GELF_ZLIB
+	}
+}
+
+// use 1420 for WAN
+func GelfChunkSize(chunk_size int) GelfOption {
+	return func(self *WriterGelf_t) {
+		if chunk_size > 0 {
+			self.chunk_size = chunk_size
+		}
+	}
+}
+
+func GelfMessage(message MessageGELF_t) GelfOption {
+	return func(self *WriterGelf_t) {
+		self.message = message
+	}
+}
+
+// address is graylog udp input, i.e. "graylog:12201"
+func NewWriterGelf(address string, opts ...GelfOption) (Queue, error) {
+	self, err := newWriterGelf(address, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return self, err
+}
+
+func NewWriterGelfQueue(queue_size int, writers int, address string, opts ...GelfOption) (Queue, error) {
+	self, err := newWriterGelf(address, opts...)
+	if err != nil {
+		return nil, err
+	}
+	self.bulk_write = 16
+
+	q := NewQueue(queue_size)
+	for i := 0; i < writers; i++ {
+		q.WgAdd(1)
+		go self.writer(q)
+	}
+	return &WriterQueue_t{Queue_t: q, writer: self}, err
+}
+
+func newWriterGelf(address string, opts ...GelfOption) (self *WriterGelf_t, err error) {
+	self = &WriterGelf_t{
+		chunk_size: GELF_CHUNK_SIZE,
+		msg_id:     uint64(time.Now().UnixNano()),
+	}
+	for _, opt := range opts {
+		opt(self)
+	}
+	if len(self.message.Host) == 0 {
+		self.message.Host, _ = os.Hostname()
+	}
+	self.conn, err = net.Dial("udp", address)
+	return
+}
+
+func (self *WriterGelf_t) writer(q *Queue_t) (err error) {
+	defer q.WgDone()
+	for {
+		msg, ok := q.LogRead(self.bulk_write)
+		if !ok {
+			return
+		}
+		for i := 0; i < len(msg); i++ {
+			if _, err = self.LogWrite(msg[i]); err != nil {
+				q.WriteError(1, err.Error())
+			}
+		}
+	}
+}
+
+func (self *WriterGelf_t) LogWrite(m Msg_t) (n int, err error) {
+	var buf bytes.Buffer
+	self.mx.Lock()
+	defer self.mx.Unlock()
+	self.queue_write++
+	if err = self.__compress(&buf, m); err == nil {
+		n, err = self.__send(buf.Bytes())
+	}
+	if err != nil {
+		self.write_error_cnt++
+		self.write_error_msg = err.Error()
+		self.handle_error(err)
+	}
+	return
+}
+
+func (self *WriterGelf_t) __compress(buf *bytes.Buffer, m Msg_t) (err error) {
+	var w io.WriteCloser
+	switch self.compress {
+	case GELF_GZIP:
+		w = gzip.NewWriter(buf)
+	case GELF_ZLIB:
+		w = zlib.NewWriter(buf)
+	default:
+		if _, err = self.message.FormatMessage(buf, m); err == nil {
+			buf.Truncate(buf.Len() - 1)
+		}
+		return
+	}
+	if _, err = self.message.FormatMessage(w, m); err != nil {
+		return
+	}
+	return w.Close()
+}
+
+func (self *WriterGelf_t) __send(p []byte) (n int, err error) {
+	if len(p) <= self.chunk_size {
+		return self.conn.Write(p)
+	}
+	count := (len(p) + self.chunk_size - 1) / self.chunk_size
+	if count > GELF_CHUNK_MAX {
+		return 0, ERROR_GELF_CHUNKS
+	}
+	self.msg_id++
+	chunk := make([]byte, 12, 12+self.chunk_size)
+	chunk[0], chunk[1] = 0x1e, 0x0f
+	binary.BigEndian.PutUint64(chunk[2:10], self.msg_id)
+	chunk[11] = byte(count)
+	for i := 0; i < count; i++ {
+		chunk[10] = byte(i)
+		end := (i + 1) * self.chunk_size
+		if end > len(p) {
+			end = len(p)
+		}
+		if _, err = self.conn.Write(append(chunk[:12], p[i*self.chunk_size:end]...)); err != nil {
+			return
+		}
+		n += end - i*self.chunk_size
+	}
+	return
+}
+
+func (self *WriterGelf_t) Size() (res QueueSize_t) {
+	self.mx.Lock()
+	res.QueueWrite = self.queue_write
+	res.WriteErrorCnt = self.write_error_cnt
+	res.WriteErrorMsg = self.write_error_msg
+	self.mx.Unlock()
+	return
+}
+
+func (self *WriterGelf_t) Close() (err error) {
+	self.mx.Lock()
+	if self.conn != nil {
+		if err = self.conn.Close(); err == nil {
+			self.conn = nil
+		}
+	}
+	self.mx.Unlock()
+	return
+}
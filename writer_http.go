@@ -6,9 +6,13 @@ package log
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
+	"math/rand"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -33,12 +37,143 @@ type PostDelayer interface {
 	Delay()
 }
 
+// formatter able to produce several request bodies, see SplitMessages()
+type SplitFormatter interface {
+	SplitMessage(in ...Msg_t) ([][]byte, error)
+}
+
+type HttpError_t struct {
+	StatusCode int
+	Status     string
+	RetryAfter time.Duration
+}
+
+func (self *HttpError_t) Error() string {
+	if len(self.Status) == 0 {
+		return strconv.FormatInt(int64(self.StatusCode), 10)
+	}
+	return self.Status
+}
+
+// request accepted, Failed messages of batch rejected by endpoint, see ReadResponse()
+type PartialError_t struct {
+	Failed int
+	Reason string
+}
+
+func (self *PartialError_t) Error() string {
+	if len(self.Reason) == 0 {
+		return "partial failure: " + strconv.FormatInt(int64(self.Failed), 10)
+	}
+	return "partial failure: " + strconv.FormatInt(int64(self.Failed), 10) + ": " + self.Reason
+}
+
+// network errors, 429 and 5xx are retryable
+func Retryable(err error) bool {
+	var partial_err *PartialError_t
+	if errors.As(err, &partial_err) {
+		return false
+	}
+	var http_err *HttpError_t
+	if errors.As(err, &http_err) {
+		return http_err.StatusCode == http.StatusTooManyRequests || http_err.StatusCode >= 500
+	}
+	return err != nil
+}
+
+func unauthorized(err error) bool {
+	var http_err *HttpError_t
+	return errors.As(err, &http_err) && http_err.StatusCode == http.StatusUnauthorized
+}
+
+// seconds or http-date
+func RetryAfter(value string, now time.Time) time.Duration {
+	if len(value) == 0 {
+		return 0
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if ts, err := http.ParseTime(value); err == nil && ts.After(now) {
+		return ts.Sub(now)
+	}
+	return 0
+}
+
+type Retry_t struct {
+	max_retries int
+	base        time.Duration
+	max         time.Duration
+}
+
+// equal jitter, Retry-After is honored but capped by max
+func (self Retry_t) Backoff(attempt int, err error) (res time.Duration) {
+	res = self.base << attempt
+	if res <= 0 || res > self.max {
+		res = self.max
+	}
+	if res > 1 {
+		res = res/2 + time.Duration(rand.Int63n(int64(res/2)))
+	}
+	var http_err *HttpError_t
+	if errors.As(err, &http_err) && http_err.RetryAfter > 0 {
+		if res = http_err.RetryAfter; res > self.max {
+			res = self.max
+		}
+	}
+	return
+}
+
+// reused by single writer goroutine
+type Gzip_t struct {
+	level int
+	buf   bytes.Buffer
+	w     *gzip.Writer
+}
+
+func NewGzip(level int) *Gzip_t {
+	return &Gzip_t{level: level}
+}
+
+func (self *Gzip_t) Compress(in []byte) (out []byte, err error) {
+	self.buf.Reset()
+	if self.w == nil {
+		if self.w, err = gzip.NewWriterLevel(&self.buf, self.level); err != nil {
+			return
+		}
+	} else {
+		self.w.Reset(&self.buf)
+	}
+	if _, err = self.w.Write(in); err != nil {
+		return
+	}
+	if err = self.w.Close(); err != nil {
+		return
+	}
+	return self.buf.Bytes(), nil
+}
+
+// optional Urls feedback from post, retryable errors are failures
+type UrlsHealth interface {
+	Success(url string)
+	Failure(url string, ts time.Time)
+}
+
+type url_health_t struct {
+	failures   int
+	down_until time.Time
+}
+
 type Urls_t struct {
-	mx   sync.Mutex
-	urls [][]string
-	i    int
+	mx            sync.Mutex
+	urls          [][]string
+	i             int
+	health        map[string]*url_health_t
+	threshold     int
+	down_duration time.Duration
 }
 
+// each Range starts from next url and tries others in order (round-robin with failover)
 func NewUrls(urls ...string) (self *Urls_t) {
 	self = &Urls_t{}
 	self.urls = make([][]string, len(urls))
@@ -50,14 +185,62 @@ func NewUrls(urls ...string) (self *Urls_t) {
 	return
 }
 
+// as NewUrls, url failed threshold times in a row is moved to the end of Range for down_duration.
+// if all urls are down they are tried in round-robin order
+func NewUrlsFailover(threshold int, down_duration time.Duration, urls ...string) (self *Urls_t) {
+	self = NewUrls(urls...)
+	self.health = map[string]*url_health_t{}
+	self.threshold = threshold
+	self.down_duration = down_duration
+	return
+}
+
 func (self *Urls_t) Range() (res []string) {
 	self.mx.Lock()
 	res = self.urls[self.i]
 	self.i = (self.i + 1) % len(self.urls)
+	if self.health != nil {
+		res = self.__order(res, time.Now())
+	}
 	self.mx.Unlock()
 	return
 }
 
+func (self *Urls_t) __order(in []string, ts time.Time) (res []string) {
+	var down []string
+	for _, v := range in {
+		if h := self.health[v]; h != nil && ts.Before(h.down_until) {
+			down = append(down, v)
+		} else {
+			res = append(res, v)
+		}
+	}
+	return append(res, down...)
+}
+
+func (self *Urls_t) Success(url string) {
+	self.mx.Lock()
+	if self.health != nil {
+		delete(self.health, url)
+	}
+	self.mx.Unlock()
+}
+
+func (self *Urls_t) Failure(url string, ts time.Time) {
+	self.mx.Lock()
+	if self.health != nil {
+		h := self.health[url]
+		if h == nil {
+			h = &url_health_t{}
+			self.health[url] = h
+		}
+		if h.failures++; h.failures >= self.threshold {
+			h.down_until = ts.Add(self.down_duration)
+		}
+	}
+	self.mx.Unlock()
+}
+
 type NoHeaders_t struct{}
 
 func (NoHeaders_t) Header(*http.Request) error {
@@ -85,24 +268,49 @@ func (self NoTimeout_t) WithTimeout(ctx context.Context) (context.Context, conte
 func (self NoTimeout_t) Delay() {}
 
 type Http_t struct {
+	ErrorHandler_t
 	urls       Urls
 	client     Client
 	rps        Rps
+	breaker    Breaker
+	auth       Auth
 	headers    Headers
 	post_ctx   PostContext
 	post_delay PostDelayer
 	message    Formatter
 	bulk_write int
+	split      bool
+	retry      Retry_t
+	gzip       bool
+	gzip_level int
+	content    string
+	max_bytes  int
+	batch      BatchEncoder
+	response   func(*http.Response) error
 }
 
 type HttpOption func(self *Http_t)
 
+// override SetErrorHandler for this output
+func HttpOnError(fn func(error)) HttpOption {
+	return func(self *Http_t) {
+		self.on_error = fn
+	}
+}
+
 func RpsLimit(rps_limit Rps) HttpOption {
 	return func(self *Http_t) {
 		self.rps = rps_limit
 	}
 }
 
+// fast-drop messages while endpoint is failing, see NewBreaker()
+func CircuitBreaker(threshold int, open_duration time.Duration) HttpOption {
+	return func(self *Http_t) {
+		self.breaker = NewBreaker(threshold, open_duration)
+	}
+}
+
 func PostHeader(headers Headers) HttpOption {
 	return func(self *Http_t) {
 		self.headers = headers
@@ -129,17 +337,85 @@ func BulkWrite(bulk_write int) HttpOption {
 	}
 }
 
+// send each body of SplitFormatter as separate request with PostDelay between
+func SplitMessages(split bool) HttpOption {
+	return func(self *Http_t) {
+		self.split = split
+	}
+}
+
+// retry failed post with jittered exponential backoff,
+// messages failed after max_retries are returned to queue if it has space, up to max_retries times
+func RetryPolicy(max_retries int, base time.Duration, max time.Duration) HttpOption {
+	return func(self *Http_t) {
+		self.retry = Retry_t{max_retries: max_retries, base: base, max: max}
+	}
+}
+
+// set Authorization header for each batch, see NewAuth().
+// provider error fails batch as retryable error, on 401 token is refreshed and request is retried once
+func AuthProvider(provider func(context.Context) (string, error), ttl time.Duration) HttpOption {
+	return func(self *Http_t) {
+		self.auth = NewAuth(provider, ttl)
+	}
+}
+
+// post batch when BulkWrite count or max_bytes of body is reached, message larger than max_bytes is sent alone.
+// messages are formatted one by one and joined with newline, use with line-delimited formatters, i.e. MessageKB_t, MessageHEC_t
+func MaxBytes(max_bytes int) HttpOption {
+	return func(self *Http_t) {
+		self.max_bytes = max_bytes
+	}
+}
+
+// body of each batch is framed by encoder, Formatter of NewHttpQueue and MaxBytes are not used
+func Batch(encoder BatchEncoder) HttpOption {
+	return func(self *Http_t) {
+		self.batch = encoder
+	}
+}
+
+// read body of 2xx response, *PartialError_t counts Failed messages as write errors without retry.
+// ElasticBulkResponse is default for MessageKB_t, ReadResponse(nil) disables it
+func ReadResponse(fn func(*http.Response) error) HttpOption {
+	return func(self *Http_t) {
+		self.response = fn
+	}
+}
+
+// Content-Type header, PostHeader is applied after
+func ContentType(content_type string) HttpOption {
+	return func(self *Http_t) {
+		self.content = content_type
+	}
+}
+
+// compress body with Content-Encoding: gzip, PostHeader is applied after
+func Gzip(level int) HttpOption {
+	return func(self *Http_t) {
+		self.gzip = true
+		self.gzip_level = level
+	}
+}
+
 func NewHttpQueue(queue_size int, writers int, urls Urls, message Formatter, client Client, opts ...HttpOption) Queue {
 	self := &Http_t{
 		urls:       urls,
 		message:    message,
 		client:     client,
 		rps:        NoRps_t{},
+		breaker:    NoBreaker_t{},
+		auth:       NoAuth_t{},
 		headers:    NoHeaders_t{},
 		post_ctx:   NoTimeout_t{},
 		post_delay: NoTimeout_t{},
 		bulk_write: 1,
 	}
+	// 200 of _bulk with "errors":true is partial failure
+	switch message.(type) {
+	case MessageKB_t, *MessageKB_t:
+		self.response = ElasticBulkResponse
+	}
 
 	for _, opt := range opts {
 		opt(self)
@@ -158,53 +434,273 @@ func (self *Http_t) writer(q *Queue_t) (err error) {
 	defer q.WgDone()
 
 	var body bytes.Buffer
+	gz := NewGzip(self.gzip_level)
 	for {
 		body.Reset()
 		msg, ok := q.LogRead(self.bulk_write)
 		if !ok {
 			return
 		}
+		if q.Context().Err() != nil {
+			q.Drop(msg)
+			return
+		}
 		if self.rps.Add(msg[0].Info.Ts) == false {
 			q.WriteError(len(msg), "rps")
 			continue
 		}
+		if self.breaker.Allow(time.Now()) == false {
+			q.Circuit(self.breaker.State(), len(msg))
+			continue
+		}
+		if splitter, ok := self.message.(SplitFormatter); ok && self.split {
+			self.split_write(q, gz, splitter, msg)
+			continue
+		}
+		if self.batch != nil {
+			self.batch_write(q, gz, msg)
+			continue
+		}
+		if self.max_bytes > 0 {
+			self.bytes_write(q, gz, msg)
+			continue
+		}
 		if _, err = self.message.FormatMessage(&body, msg...); err != nil {
 			q.WriteError(len(msg), err.Error())
+			self.handle_error(err)
 			continue
 		}
-		for _, v := range self.urls.Range() {
-			if err = self.request(v, body.Bytes()); err == nil {
-				break
-			}
+		self.send(q, gz, body.Bytes(), msg)
+	}
+}
+
+func (self *Http_t) send(q *Queue_t, gz *Gzip_t, body []byte, msg []Msg_t) {
+	var partial_err *PartialError_t
+	if err := self.post(q, gz, body); errors.As(err, &partial_err) {
+		q.WriteError(partial_err.Failed, err.Error())
+		self.handle_error(err)
+	} else if err != nil && q.Context().Err() != nil {
+		// stopped by CloseContext
+		q.Drop(msg)
+	} else if err != nil {
+		self.handle_error(err)
+		if self.retry.max_retries > 0 && Retryable(err) {
+			self.requeue(q, msg, err)
+		} else {
+			q.WriteError(len(msg), err.Error())
+		}
+	}
+	self.post_delay.Delay()
+}
+
+// failed batch is returned to queue up to max_retries times, then messages are counted as write errors.
+// messages not fit to queue are counted as QueueDrop
+func (self *Http_t) requeue(q *Queue_t, msg []Msg_t, err error) {
+	retry := make([]Msg_t, 0, len(msg))
+	for _, v := range msg {
+		if v.requeued < self.retry.max_retries {
+			v.requeued++
+			retry = append(retry, v)
+		}
+	}
+	if failed := len(msg) - len(retry); failed > 0 {
+		q.WriteError(failed, err.Error())
+	}
+	q.Requeue(retry)
+}
+
+// size is counted while formatting, body is sent before message that does not fit
+func (self *Http_t) bytes_write(q *Queue_t, gz *Gzip_t, msg []Msg_t) {
+	var body bytes.Buffer
+	var batch []Msg_t
+	for _, v := range msg {
+		size := body.Len()
+		if _, err := self.message.FormatMessage(&body, v); err != nil {
+			body.Truncate(size)
+			q.WriteError(1, err.Error())
+			self.handle_error(err)
+			continue
+		}
+		if body.Len() > size && body.Bytes()[body.Len()-1] != '\n' {
+			body.WriteByte('\n')
+		}
+		if size > 0 && body.Len() > self.max_bytes {
+			last := append([]byte{}, body.Bytes()[size:]...)
+			body.Truncate(size)
+			self.send(q, gz, body.Bytes(), batch)
+			body.Reset()
+			body.Write(last)
+			batch = batch[:0]
+		}
+		batch = append(batch, v)
+	}
+	if len(batch) > 0 {
+		self.send(q, gz, body.Bytes(), batch)
+	}
+}
+
+// message failed WriteOne is counted as error and skipped
+func (self *Http_t) batch_write(q *Queue_t, gz *Gzip_t, msg []Msg_t) {
+	var body bytes.Buffer
+	var batch []Msg_t
+	if err := self.batch.BeginBatch(&body); err != nil {
+		q.WriteError(len(msg), err.Error())
+		self.handle_error(err)
+		return
+	}
+	for _, v := range msg {
+		size := body.Len()
+		if err := self.batch.WriteOne(&body, len(batch), v); err != nil {
+			body.Truncate(size)
+			q.WriteError(1, err.Error())
+			self.handle_error(err)
+			continue
 		}
-		if err != nil {
+		batch = append(batch, v)
+	}
+	if len(batch) == 0 {
+		return
+	}
+	if err := self.batch.EndBatch(&body); err != nil {
+		q.WriteError(len(batch), err.Error())
+		self.handle_error(err)
+		return
+	}
+	self.send(q, gz, body.Bytes(), batch)
+}
+
+func (self *Http_t) split_write(q *Queue_t, gz *Gzip_t, splitter SplitFormatter, msg []Msg_t) {
+	bodies, err := splitter.SplitMessage(msg...)
+	if err != nil {
+		q.WriteError(len(msg), err.Error())
+		self.handle_error(err)
+		return
+	}
+	for _, v := range bodies {
+		if err = self.post(q, gz, v); err != nil {
 			q.WriteError(len(msg), err.Error())
+			self.handle_error(err)
+			self.post_delay.Delay()
+			return
 		}
 		self.post_delay.Delay()
 	}
 }
 
-func (self *Http_t) request(URL string, body []byte) (err error) {
-	ctx, cancel := self.post_ctx.WithTimeout(context.Background())
+func (self *Http_t) post(q *Queue_t, gz *Gzip_t, body []byte) (err error) {
+	var partial_err *PartialError_t
+	// endpoint answered with non-retryable error is alive
+	defer func() {
+		if Retryable(err) {
+			self.breaker.Failure(time.Now())
+		} else {
+			self.breaker.Success()
+		}
+		q.Circuit(self.breaker.State(), 0)
+	}()
+	if self.gzip {
+		if body, err = gz.Compress(body); err != nil {
+			return
+		}
+	}
+	ctx := q.Context()
+	refreshed := false
+	for attempt := 0; ; attempt++ {
+		var auth string
+		if auth, err = self.authorization(ctx); err != nil {
+			if attempt >= self.retry.max_retries || ctx.Err() != nil {
+				return
+			}
+			q.Retry(1)
+			SleepContext(ctx, self.retry.Backoff(attempt, err))
+			continue
+		}
+		for _, v := range self.urls.Range() {
+			err = self.request(ctx, v, auth, body)
+			// token invalidated by 401 is fetched again
+			if len(auth) > 0 && !refreshed && unauthorized(err) {
+				refreshed = true
+				if auth, err = self.authorization(ctx); err == nil {
+					err = self.request(ctx, v, auth, body)
+				}
+			}
+			if health, ok := self.urls.(UrlsHealth); ok {
+				if Retryable(err) {
+					health.Failure(v, time.Now())
+				} else {
+					health.Success(v)
+				}
+			}
+			// PartialError_t is accepted batch
+			if err == nil || errors.As(err, &partial_err) {
+				return
+			}
+		}
+		if attempt >= self.retry.max_retries || !Retryable(err) || ctx.Err() != nil {
+			return
+		}
+		q.Retry(1)
+		SleepContext(ctx, self.retry.Backoff(attempt, err))
+	}
+}
+
+// returns ctx.Err() if ctx is done before duration
+func SleepContext(ctx context.Context, duration time.Duration) error {
+	t := time.NewTimer(duration)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (self *Http_t) authorization(ctx context.Context) (string, error) {
+	ctx, cancel := self.post_ctx.WithTimeout(ctx)
+	defer cancel()
+	res, err := self.auth.Authorization(ctx, time.Now())
+	if err != nil {
+		return "", fmt.Errorf("auth: %w", err)
+	}
+	return res, nil
+}
+
+func (self *Http_t) request(ctx context.Context, URL string, auth string, body []byte) (err error) {
+	ctx, cancel := self.post_ctx.WithTimeout(ctx)
 	defer cancel()
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, URL, bytes.NewReader(body))
 	if err != nil {
 		return
 	}
+	if len(self.content) > 0 {
+		req.Header.Set("Content-Type", self.content)
+	}
+	if self.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
 	if err = self.headers.Header(req); err != nil {
 		return
 	}
+	if len(auth) > 0 {
+		req.Header.Set("Authorization", auth)
+	}
 	resp, err := self.client.Do(req)
 	if err != nil {
 		return
 	}
-	resp.Body.Close()
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		self.auth.Invalidate()
+	}
 	if (resp.StatusCode >= 200 && resp.StatusCode < 300) == false {
-		if len(resp.Status) == 0 {
-			err = fmt.Errorf("%v", resp.StatusCode)
-		} else {
-			err = fmt.Errorf("%v", resp.Status)
+		err = &HttpError_t{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			RetryAfter: RetryAfter(resp.Header.Get("Retry-After"), time.Now()),
 		}
+	} else if self.response != nil {
+		err = self.response(resp)
 	}
 	return
 }
@@ -0,0 +1,179 @@
+//
+// systemd journal native protocol
+// KEY=value\n, KEY\n<uint64 le length>value\n for values with newline
+//
+
+package log
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var JournalSocket = "/run/systemd/journal/socket"
+
+type WriterJournal_t struct {
+	WriterOptions_t
+	mx              sync.Mutex
+	prefix          []Formatter
+	conn            net.Conn
+	socket          string
+	app_name        string
+	log_limit       int
+	queue_write     int
+	write_error_cnt int
+	write_error_msg string
+	bulk_write      int
+}
+
+// empty socket is JournalSocket.
+// if socket is not present, i.e. not systemd host, messages go to stderr as text
+func NewWriterJournal(socket string, prefix []Formatter, log_limit int, opts ...WriterOption) Queue {
+	return newWriterJournal(socket, prefix, log_limit, opts...)
+}
+
+func NewWriterJournalQueue(queue_size int, writers int, socket string, prefix []Formatter, log_limit int, opts ...WriterOption) Queue {
+	self := newWriterJournal(socket, prefix, log_limit, opts...)
+	self.bulk_write = 16
+
+	q := NewQueue(queue_size)
+	for i := 0; i < writers; i++ {
+		q.WgAdd(1)
+		go self.writer(q)
+	}
+	return &WriterQueue_t{Queue_t: q, writer: self}
+}
+
+func newWriterJournal(socket string, prefix []Formatter, log_limit int, opts ...WriterOption) (self *WriterJournal_t) {
+	if len(socket) == 0 {
+		socket = JournalSocket
+	}
+	self = &WriterJournal_t{
+		WriterOptions_t: NewWriterOptions(opts...),
+		prefix:          prefix,
+		socket:          socket,
+		app_name:        filepath.Base(os.Args[0]),
+		log_limit:       log_limit,
+	}
+	self.conn, _ = net.Dial("unixgram", socket)
+	return
+}
+
+func (self *WriterJournal_t) writer(q *Queue_t) (err error) {
+	defer q.WgDone()
+	for {
+		msg, ok := q.LogRead(self.bulk_write)
+		if !ok {
+			return
+		}
+		for i := 0; i < len(msg); i++ {
+			if _, err = self.LogWrite(msg[i]); err != nil {
+				q.WriteError(1, err.Error())
+			}
+		}
+	}
+}
+
+func (self *WriterJournal_t) LogWrite(m Msg_t) (n int, err error) {
+	var buf, text bytes.Buffer
+	self.mx.Lock()
+	defer self.mx.Unlock()
+	self.queue_write++
+
+	var w io.Writer
+	if self.log_limit > 0 {
+		w = &LimitWriter_t{Buf: &text, Limit: self.log_limit, Ellipsis: self.ellipsis}
+	} else {
+		w = &text
+	}
+	for _, v := range self.prefix {
+		v.FormatMessage(w, m)
+	}
+	self.message.FormatMessage(w, m)
+
+	if self.conn == nil {
+		text.WriteString("\n")
+		if n, err = os.Stderr.Write(text.Bytes()); err != nil {
+			self.write_error_cnt++
+			self.write_error_msg = err.Error()
+		}
+		return
+	}
+
+	JournalField(&buf, "PRIORITY", strconv.FormatInt(int64(SyslogSeverity(m.Info.LevelId)), 10))
+	JournalField(&buf, "SYSLOG_IDENTIFIER", self.app_name)
+	JournalField(&buf, "MESSAGE", text.String())
+	if len(m.Info.File) > 0 {
+		JournalField(&buf, "CODE_FILE", m.Info.File)
+		JournalField(&buf, "CODE_LINE", strconv.FormatInt(int64(m.Info.Line), 10))
+	}
+	for _, v := range m.Fields {
+		if key := JournalKey(v.Key); len(key) > 0 {
+			JournalField(&buf, key, fmt.Sprint(v.Value))
+		}
+	}
+
+	if n, err = self.conn.Write(buf.Bytes()); err != nil {
+		self.write_error_cnt++
+		self.write_error_msg = err.Error()
+		self.handle_error(fmt.Errorf("journal: %w", err))
+	}
+	return
+}
+
+// upper case letters, digits and underscore, not starting with underscore or digit
+func JournalKey(in string) string {
+	var res strings.Builder
+	for _, c := range strings.ToUpper(in) {
+		switch {
+		case c >= 'A' && c <= 'Z', c == '_' && res.Len() > 0, c >= '0' && c <= '9' && res.Len() > 0:
+			res.WriteRune(c)
+		case res.Len() > 0:
+			res.WriteByte('_')
+		}
+	}
+	return res.String()
+}
+
+func JournalField(buf *bytes.Buffer, key string, value string) {
+	buf.WriteString(key)
+	if strings.IndexByte(value, '\n') < 0 {
+		buf.WriteByte('=')
+		buf.WriteString(value)
+	} else {
+		var b [8]byte
+		buf.WriteByte('\n')
+		binary.LittleEndian.PutUint64(b[:], uint64(len(value)))
+		buf.Write(b[:])
+		buf.WriteString(value)
+	}
+	buf.WriteByte('\n')
+}
+
+func (self *WriterJournal_t) Size() (res QueueSize_t) {
+	self.mx.Lock()
+	res.QueueWrite = self.queue_write
+	res.WriteErrorCnt = self.write_error_cnt
+	res.WriteErrorMsg = self.write_error_msg
+	self.mx.Unlock()
+	return
+}
+
+func (self *WriterJournal_t) Close() (err error) {
+	self.mx.Lock()
+	if self.conn != nil {
+		if err = self.conn.Close(); err == nil {
+			self.conn = nil
+		}
+	}
+	self.mx.Unlock()
+	return
+}
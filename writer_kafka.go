@@ -0,0 +1,131 @@
+//
+//
+//
+
+package log
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"time"
+)
+
+type KafkaMessage_t struct {
+	Topic string
+	Key   []byte
+	Value []byte
+	Time  time.Time
+}
+
+// adapter for kafka client, i.e. kafka-go Writer.WriteMessages
+type KafkaProducer interface {
+	WriteMessages(ctx context.Context, msgs ...KafkaMessage_t) error
+}
+
+type Kafka_t struct {
+	ErrorHandler_t
+	producer   KafkaProducer
+	topic      string
+	message    Formatter
+	key        func(m Msg_t) []byte
+	post_ctx   PostContext
+	bulk_write int
+}
+
+type KafkaOption func(self *Kafka_t)
+
+// override SetErrorHandler for this output
+func KafkaOnError(fn func(error)) KafkaOption {
+	return func(self *Kafka_t) {
+		self.on_error = fn
+	}
+}
+
+func KafkaKey(key func(m Msg_t) []byte) KafkaOption {
+	return func(self *Kafka_t) {
+		self.key = key
+	}
+}
+
+func KafkaTimeout(timeout time.Duration) KafkaOption {
+	return func(self *Kafka_t) {
+		self.post_ctx = &Timeout_t{timeout: timeout}
+	}
+}
+
+func KafkaBulkWrite(bulk_write int) KafkaOption {
+	return func(self *Kafka_t) {
+		if bulk_write > 0 {
+			self.bulk_write = bulk_write
+		}
+	}
+}
+
+// records are keyed by hostname by default
+func NewKafka(queue_size int, writers int, producer KafkaProducer, topic string, message Formatter, opts ...KafkaOption) Queue {
+	hostname, _ := os.Hostname()
+	self := &Kafka_t{
+		producer: producer,
+		topic:    topic,
+		message:  message,
+		key: func(Msg_t) []byte {
+			return []byte(hostname)
+		},
+		post_ctx:   NoTimeout_t{},
+		bulk_write: 64,
+	}
+
+	for _, opt := range opts {
+		opt(self)
+	}
+
+	q := NewQueue(queue_size)
+	for i := 0; i < writers; i++ {
+		q.WgAdd(1)
+		go self.writer(q)
+	}
+
+	return q
+}
+
+func (self *Kafka_t) writer(q *Queue_t) (err error) {
+	defer q.WgDone()
+
+	var body bytes.Buffer
+	var records []KafkaMessage_t
+	for {
+		msg, ok := q.LogRead(self.bulk_write)
+		if !ok {
+			return
+		}
+		records = records[:0]
+		for _, v := range msg {
+			body.Reset()
+			if _, err = self.message.FormatMessage(&body, v); err != nil {
+				q.WriteError(1, err.Error())
+				self.handle_error(err)
+				continue
+			}
+			records = append(records, KafkaMessage_t{
+				Topic: self.topic,
+				Key:   self.key(v),
+				Value: bytes.TrimSuffix(append([]byte{}, body.Bytes()...), []byte("\n")),
+				Time:  v.Info.Ts,
+			})
+		}
+		if len(records) == 0 {
+			continue
+		}
+		if err = self.produce(records); err != nil {
+			q.WriteError(len(records), err.Error())
+			self.handle_error(err)
+		}
+	}
+}
+
+func (self *Kafka_t) produce(records []KafkaMessage_t) error {
+	ctx, cancel := self.post_ctx.WithTimeout(context.Background())
+	defer cancel()
+	return self.producer.WriteMessages(ctx, records...)
+}
@@ -0,0 +1,114 @@
+//
+//
+//
+
+package log
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+)
+
+// captured messages for tests of code using logger
+type Memory_t struct {
+	mx  sync.Mutex
+	msg []Msg_t
+}
+
+func NewMemory() *Memory_t {
+	return &Memory_t{}
+}
+
+func (self *Memory_t) LogWrite(m Msg_t) (n int, err error) {
+	self.mx.Lock()
+	self.msg = append(self.msg, m)
+	self.mx.Unlock()
+	return
+}
+
+// copy of captured messages
+func (self *Memory_t) Messages() []Msg_t {
+	self.mx.Lock()
+	defer self.mx.Unlock()
+	return append([]Msg_t{}, self.msg...)
+}
+
+// "level message key=value" for each message
+func (self *Memory_t) Lines() (res []string) {
+	var buf strings.Builder
+	for _, v := range self.Messages() {
+		buf.Reset()
+		TextMessage(&buf, v.Info.LevelName, v)
+		res = append(res, buf.String())
+	}
+	return
+}
+
+// any message with level name (empty for all levels) with rendered text containing substr
+func (self *Memory_t) Contains(level string, substr string) bool {
+	var buf strings.Builder
+	for _, v := range self.Messages() {
+		if len(level) > 0 && v.Info.LevelName != level {
+			continue
+		}
+		buf.Reset()
+		TextMessage(&buf, v.Info.LevelName, v)
+		if strings.Contains(buf.String(), substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *Memory_t) Reset() {
+	self.mx.Lock()
+	self.msg = nil
+	self.mx.Unlock()
+}
+
+func (self *Memory_t) Size() (res QueueSize_t) {
+	self.mx.Lock()
+	res.Size = len(self.msg)
+	self.mx.Unlock()
+	return
+}
+
+func (self *Memory_t) Close() error {
+	return nil
+}
+
+// formatted output for golden tests, use instead of os.Stderr, i.e. NewWriterStdany(prefix, capture, 0)
+type Capture_t struct {
+	mx  sync.Mutex
+	buf bytes.Buffer
+}
+
+func NewCaptureWriter() *Capture_t {
+	return &Capture_t{}
+}
+
+func (self *Capture_t) Write(p []byte) (n int, err error) {
+	self.mx.Lock()
+	n, err = self.buf.Write(p)
+	self.mx.Unlock()
+	return
+}
+
+func (self *Capture_t) Bytes() []byte {
+	self.mx.Lock()
+	defer self.mx.Unlock()
+	return append([]byte{}, self.buf.Bytes()...)
+}
+
+func (self *Capture_t) String() string {
+	self.mx.Lock()
+	defer self.mx.Unlock()
+	return self.buf.String()
+}
+
+func (self *Capture_t) Reset() {
+	self.mx.Lock()
+	self.buf.Reset()
+	self.mx.Unlock()
+}
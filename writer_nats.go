@@ -0,0 +1,78 @@
+//
+//
+//
+
+package log
+
+import (
+	"bytes"
+	"strings"
+)
+
+// *nats.Conn implements it, reconnect is handled by connection
+type NatsPublisher interface {
+	Publish(subject string, data []byte) error
+}
+
+type Nats_t struct {
+	ErrorHandler_t
+	conn       NatsPublisher
+	subject    string
+	message    Formatter
+	bulk_write int
+}
+
+type NatsOption func(self *Nats_t)
+
+// override SetErrorHandler for this output
+func NatsOnError(fn func(error)) NatsOption {
+	return func(self *Nats_t) {
+		self.on_error = fn
+	}
+}
+
+// each message is published to subject + "." + lower case level name, i.e. "logs.error"
+func NewNats(queue_size int, writers int, conn NatsPublisher, subject string, message Formatter, opts ...NatsOption) Queue {
+	self := &Nats_t{
+		conn:       conn,
+		subject:    subject,
+		message:    message,
+		bulk_write: 64,
+	}
+
+	for _, opt := range opts {
+		opt(self)
+	}
+
+	q := NewQueue(queue_size)
+	for i := 0; i < writers; i++ {
+		q.WgAdd(1)
+		go self.writer(q)
+	}
+
+	return q
+}
+
+func (self *Nats_t) writer(q *Queue_t) (err error) {
+	defer q.WgDone()
+
+	var body bytes.Buffer
+	for {
+		msg, ok := q.LogRead(self.bulk_write)
+		if !ok {
+			return
+		}
+		for _, v := range msg {
+			body.Reset()
+			if _, err = self.message.FormatMessage(&body, v); err != nil {
+				q.WriteError(1, err.Error())
+				self.handle_error(err)
+				continue
+			}
+			if err = self.conn.Publish(self.subject+"."+strings.ToLower(v.Info.LevelName), bytes.TrimSuffix(body.Bytes(), []byte("\n"))); err != nil {
+				q.WriteError(1, err.Error())
+				self.handle_error(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,306 @@
+//
+//
+//
+
+package log
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type WriterOptions_t struct {
+	ErrorHandler_t
+	message    Formatter
+	compress   bool
+	sync       bool
+	sync_every time.Duration
+	file_mode  os.FileMode
+	dir_mode   os.FileMode
+	file_group int
+	align      time.Duration
+	align_loc  *time.Location
+	max_total  int
+	max_age    time.Duration
+	ellipsis   string
+	symlink    string
+}
+
+type WriterOption func(self *WriterOptions_t)
+
+// format message body with custom formatter, i.e. NewJson()
+func WriteMessage(message Formatter) WriterOption {
+	return func(self *WriterOptions_t) {
+		self.message = message
+	}
+}
+
+// gzip rotated files in background, file outputs only
+func CompressBackups() WriterOption {
+	return func(self *WriterOptions_t) {
+		self.compress = true
+	}
+}
+
+// oldest backups are removed until total size of backups is under max_total, with backup_count limit applied first.
+// backup being compressed is counted with its uncompressed size, file outputs only
+func MaxTotalSize(max_total int) WriterOption {
+	return func(self *WriterOptions_t) {
+		self.max_total = max_total
+	}
+}
+
+// backups with modification time older than max_age before rotation time are removed, file outputs only
+func MaxAge(max_age time.Duration) WriterOption {
+	return func(self *WriterOptions_t) {
+		self.max_age = max_age
+	}
+}
+
+// appended to message truncated by log_limit, ellipsis is counted in log_limit
+func LimitEllipsis(ellipsis string) WriterOption {
+	return func(self *WriterOptions_t) {
+		self.ellipsis = ellipsis
+	}
+}
+
+// fsync after every write, file outputs only
+func SyncWrite() WriterOption {
+	return func(self *WriterOptions_t) {
+		self.sync = true
+		self.sync_every = 0
+	}
+}
+
+// fsync on write if last sync is older than sync_every, file outputs only
+func SyncEvery(sync_every time.Duration) WriterOption {
+	return func(self *WriterOptions_t) {
+		self.sync = true
+		self.sync_every = sync_every
+	}
+}
+
+// permissions for created log files and backups, file outputs only
+func FileMode(mode os.FileMode) WriterOption {
+	return func(self *WriterOptions_t) {
+		self.file_mode = mode
+	}
+}
+
+// permissions for missing parent directories, file outputs only
+func DirMode(mode os.FileMode) WriterOption {
+	return func(self *WriterOptions_t) {
+		self.dir_mode = mode
+	}
+}
+
+// group id for created log files and backups, file outputs only
+func FileGroup(gid int) WriterOption {
+	return func(self *WriterOptions_t) {
+		self.file_group = gid
+	}
+}
+
+// rotate time-based file on wall-clock boundaries of period in loc, i.e. AlignTo(24*time.Hour, time.Local) rotates at local midnight.
+// periods of whole days are counted in calendar days, shorter periods in wall-clock time since midnight,
+// so DST transitions neither skip nor double a day. overrides truncate of NewWriterFileTime.
+func AlignTo(period time.Duration, loc *time.Location) WriterOption {
+	return func(self *WriterOptions_t) {
+		self.align = period
+		self.align_loc = loc
+	}
+}
+
+// symlink name pointing to active file, updated after each rotation through temporary name and rename.
+// where symlinks are not permitted, i.e. Windows, error goes to error handler and output keeps working.
+// time-based file output only
+func CurrentSymlink(name string) WriterOption {
+	return func(self *WriterOptions_t) {
+		self.symlink = name
+	}
+}
+
+// override SetErrorHandler for this output
+func OnError(fn func(error)) WriterOption {
+	return func(self *WriterOptions_t) {
+		self.on_error = fn
+	}
+}
+
+func NewWriterOptions(opts ...WriterOption) (self WriterOptions_t) {
+	self.message = NewTextMessage()
+	self.file_mode = 0644
+	self.dir_mode = 0755
+	self.file_group = -1
+	for _, opt := range opts {
+		opt(&self)
+	}
+	return
+}
+
+// creates parent directories, applies file mode and group
+func (self *WriterOptions_t) OpenFile(filename string, flag int) (out *os.File, err error) {
+	if err = os.MkdirAll(filepath.Dir(filename), self.dir_mode); err != nil {
+		return
+	}
+	if out, err = os.OpenFile(filename, flag, self.file_mode); err != nil {
+		return
+	}
+	// umask
+	if err = out.Chmod(self.file_mode); err == nil && self.file_group >= 0 {
+		err = out.Chown(-1, self.file_group)
+	}
+	if err != nil {
+		out.Close()
+		out = nil
+	}
+	return
+}
+
+// target is relative when symlink and filename are in same directory
+func (self *WriterOptions_t) update_symlink(filename string) (err error) {
+	if len(self.symlink) == 0 {
+		return
+	}
+	target := filename
+	if filepath.Dir(self.symlink) == filepath.Dir(filename) {
+		target = filepath.Base(filename)
+	} else if target, err = filepath.Abs(filename); err != nil {
+		return
+	}
+	temp := self.symlink + ".tmp"
+	os.Remove(temp)
+	if err = os.Symlink(target, temp); err != nil {
+		return
+	}
+	if err = os.Rename(temp, self.symlink); err != nil {
+		os.Remove(temp)
+	}
+	return
+}
+
+type TextMessage_t struct {
+	pad       int
+	multiline Multiline_t
+}
+
+type TextOption func(self *TextMessage_t)
+
+// level name is right-padded with spaces to width, i.e. "INFO  message" and "ERROR message"
+func TextPad(width int) TextOption {
+	return func(self *TextMessage_t) {
+		self.pad = width
+	}
+}
+
+// newlines inside message and fields, see Multiline_t
+func TextMultiline(policy Multiline_t) TextOption {
+	return func(self *TextMessage_t) {
+		self.multiline = policy
+	}
+}
+
+func NewTextMessage(opts ...TextOption) Formatter {
+	self := &TextMessage_t{}
+	for _, opt := range opts {
+		opt(self)
+	}
+	return self
+}
+
+func (self *TextMessage_t) FormatMessage(out io.Writer, in ...Msg_t) (n int, err error) {
+	if len(in) == 0 {
+		return
+	}
+	if self.multiline != MultilineRaw {
+		out = &MultilineWriter_t{Buf: out, Policy: self.multiline}
+	}
+	return TextMessage(out, PadLevel(in[0].Info.LevelName, self.pad), in[0])
+}
+
+type Multiline_t int
+
+const (
+	// newlines are written as is
+	MultilineRaw Multiline_t = iota
+	// "\n" and "\r" are written as `\n` and `\r`
+	MultilineEscape
+	// continuation lines are prefixed with Indent
+	MultilineIndent
+)
+
+// keeps message on one physical line or marks continuation lines, Indent is "\t" if empty
+type MultilineWriter_t struct {
+	Buf    io.Writer
+	Policy Multiline_t
+	Indent string
+}
+
+// n is len(p) on success
+func (self *MultilineWriter_t) Write(p []byte) (n int, err error) {
+	if self.Policy == MultilineRaw {
+		return self.Buf.Write(p)
+	}
+	var last int
+	for i, c := range p {
+		var repl string
+		switch {
+		case c == '\n' && self.Policy == MultilineEscape:
+			repl = `\n`
+		case c == '\r' && self.Policy == MultilineEscape:
+			repl = `\r`
+		case c == '\n' && self.Policy == MultilineIndent:
+			if repl = self.Indent; len(repl) == 0 {
+				repl = "\t"
+			}
+			repl = "\n" + repl
+		default:
+			continue
+		}
+		if _, err = self.Buf.Write(p[last:i]); err != nil {
+			return
+		}
+		if _, err = io.WriteString(self.Buf, repl); err != nil {
+			return
+		}
+		last = i + 1
+	}
+	if _, err = self.Buf.Write(p[last:]); err != nil {
+		return
+	}
+	return len(p), nil
+}
+
+func PadLevel(level string, width int) string {
+	if len(level) >= width {
+		return level
+	}
+	return level + strings.Repeat(" ", width-len(level))
+}
+
+// "level message key=value"
+func TextMessage(out io.Writer, level string, m Msg_t) (n int, err error) {
+	var n1 int
+	if n1, err = io.WriteString(out, level); err != nil {
+		return n1, err
+	}
+	n += n1
+	if n1, err = io.WriteString(out, " "); err != nil {
+		return n + n1, err
+	}
+	n += n1
+	n1, err = fmt.Fprintf(out, m.Format, m.Args...)
+	n += n1
+	for _, v := range m.Fields {
+		if err != nil {
+			return
+		}
+		n1, err = fmt.Fprintf(out, " %s=%v", v.Key, v.Value)
+		n += n1
+	}
+	return
+}
@@ -0,0 +1,215 @@
+//
+//
+//
+
+package log
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+type ring_cell_t struct {
+	seq atomic.Uint64
+	msg Msg_t
+}
+
+type Ring_t struct {
+	next           Queue
+	cells          []ring_cell_t
+	mask           uint64
+	head           atomic.Uint64
+	tail           atomic.Uint64
+	written        atomic.Uint64
+	queue_overflow atomic.Int64
+	producers      atomic.Int64
+	closed         atomic.Bool
+	wake           chan struct{}
+	done           chan struct{}
+	wg             sync.WaitGroup
+	flush_mx       sync.Mutex
+	flush          *sync.Cond
+	stopped        bool
+}
+
+// lock-free bounded queue in front of next with single writer, many goroutines may LogWrite.
+// queue_size is rounded up to power of two, message is dropped with ERROR_OVERFLOW if queue is full.
+// next is written from one goroutine, i.e. NewRing(1024, NewWriterStdany(prefix, os.Stdout, 0))
+func NewRing(queue_size int, next Queue) Queue {
+	size := 1
+	for size < queue_size {
+		size <<= 1
+	}
+	self := &Ring_t{
+		next:  next,
+		cells: make([]ring_cell_t, size),
+		mask:  uint64(size - 1),
+		wake:  make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+	self.flush = sync.NewCond(&self.flush_mx)
+	for i := range self.cells {
+		self.cells[i].seq.Store(uint64(i))
+	}
+	self.wg.Add(1)
+	go self.writer()
+	return self
+}
+
+// producers counter is checked after closed, so Close waits only for producers that passed closed check
+func (self *Ring_t) LogWrite(m Msg_t) (n int, err error) {
+	if self.closed.Load() {
+		self.queue_overflow.Add(1)
+		return 0, ERROR_OVERFLOW
+	}
+	self.producers.Add(1)
+	if self.closed.Load() || !self.push(m) {
+		self.producers.Add(-1)
+		self.queue_overflow.Add(1)
+		return 0, ERROR_OVERFLOW
+	}
+	self.producers.Add(-1)
+	select {
+	case self.wake <- struct{}{}:
+	default:
+	}
+	return
+}
+
+// cell is free for position pos when seq == pos, filled when seq == pos+1
+func (self *Ring_t) push(m Msg_t) bool {
+	pos := self.head.Load()
+	for {
+		cell := &self.cells[pos&self.mask]
+		seq := cell.seq.Load()
+		switch diff := int64(seq - pos); {
+		case diff == 0:
+			if self.head.CompareAndSwap(pos, pos+1) {
+				cell.msg = m
+				cell.seq.Store(pos + 1)
+				return true
+			}
+			pos = self.head.Load()
+		case diff < 0:
+			return false
+		default:
+			pos = self.head.Load()
+		}
+	}
+}
+
+// single reader
+func (self *Ring_t) pop() (m Msg_t, ok bool) {
+	pos := self.tail.Load()
+	cell := &self.cells[pos&self.mask]
+	if cell.seq.Load() != pos+1 {
+		return
+	}
+	m = cell.msg
+	cell.msg = Msg_t{}
+	cell.seq.Store(pos + self.mask + 1)
+	self.tail.Store(pos + 1)
+	return m, true
+}
+
+func (self *Ring_t) writer() {
+	defer self.wg.Done()
+	defer self.__stopped()
+	for {
+		for {
+			m, ok := self.pop()
+			if !ok {
+				break
+			}
+			self.next.LogWrite(m)
+			self.written.Add(1)
+		}
+		self.flush_mx.Lock()
+		self.flush.Broadcast()
+		self.flush_mx.Unlock()
+		select {
+		case <-self.wake:
+		case <-self.done:
+			// no producers after done, cell reserved in head is filled soon
+			if self.tail.Load() == self.head.Load() {
+				return
+			}
+			runtime.Gosched()
+		}
+	}
+}
+
+func (self *Ring_t) __stopped() {
+	self.flush_mx.Lock()
+	self.stopped = true
+	self.flush.Broadcast()
+	self.flush_mx.Unlock()
+}
+
+func (self *Ring_t) Size() (res QueueSize_t) {
+	res = self.next.Size()
+	head := self.head.Load()
+	res.Limit = len(self.cells)
+	res.Size = int(head - self.tail.Load())
+	res.Writers = 1
+	res.QueueWrite = int(head) + int(self.queue_overflow.Load())
+	res.QueueRead = int(self.tail.Load())
+	res.QueueOverflow += int(self.queue_overflow.Load())
+	return
+}
+
+// wait until queued messages are passed to next, then flush next
+func (self *Ring_t) Flush() error {
+	head := self.head.Load()
+	self.flush_mx.Lock()
+	for self.written.Load() < head && !self.stopped {
+		self.flush.Wait()
+	}
+	self.flush_mx.Unlock()
+	return Flush(self.next)
+}
+
+func (self *Ring_t) Reopen() error {
+	return Reopen(self.next)
+}
+
+// messages of LogWrite calls returned without error are written to next before it is closed
+func (self *Ring_t) Close() error {
+	if !self.stop() {
+		return nil
+	}
+	self.wg.Wait()
+	return self.next.Close()
+}
+
+// stop waiting for writer when ctx is done, then next is closed with ctx
+func (self *Ring_t) CloseContext(ctx context.Context) error {
+	if !self.stop() {
+		return nil
+	}
+	done := make(chan struct{})
+	go func() {
+		self.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+	return CloseQueue(ctx, self.next)
+}
+
+// false if already stopped
+func (self *Ring_t) stop() bool {
+	if self.closed.Swap(true) {
+		return false
+	}
+	// producers started after closed was set leave at once
+	for self.producers.Load() > 0 {
+		runtime.Gosched()
+	}
+	close(self.done)
+	return true
+}
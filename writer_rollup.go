@@ -0,0 +1,147 @@
+//
+//
+//
+
+package log
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// period of NewRollup if not positive
+const ROLLUP_PERIOD = 5 * time.Minute
+
+type rollup_t struct {
+	count int
+	last  Msg_t
+}
+
+type Rollup_t struct {
+	mx     sync.Mutex
+	next   Queue
+	period time.Duration
+	keys   map[string]*rollup_t
+	rolled int
+	closed atomic.Bool
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// first message of each level and format is written at once, others within period are counted,
+// then "N more occurrences of <message> in last <period>" is written for last of them every period and on Close.
+// for notification outputs, i.e. NewRollup(telegram, 5*time.Minute), summaries are paced by PostDelay of next
+func NewRollup(next Queue, period time.Duration) Queue {
+	if period <= 0 {
+		period = ROLLUP_PERIOD
+	}
+	self := &Rollup_t{
+		next:   next,
+		period: period,
+		keys:   map[string]*rollup_t{},
+		done:   make(chan struct{}),
+	}
+	self.wg.Add(1)
+	go self.ticker()
+	return self
+}
+
+func (self *Rollup_t) ticker() {
+	defer self.wg.Done()
+	t := time.NewTicker(self.period)
+	defer t.Stop()
+	for {
+		select {
+		case <-self.done:
+			return
+		case <-t.C:
+			self.__write(false)
+		}
+	}
+}
+
+func (self *Rollup_t) LogWrite(m Msg_t) (n int, err error) {
+	key := fmt.Sprintf("%d %s", m.Info.LevelId, m.Format)
+	self.mx.Lock()
+	if v, ok := self.keys[key]; ok {
+		v.count++
+		v.last = m
+		self.rolled++
+		self.mx.Unlock()
+		return
+	}
+	self.keys[key] = &rollup_t{}
+	self.mx.Unlock()
+	return self.next.LogWrite(m)
+}
+
+// keys without messages in period are removed, all keys are removed on Close
+func (self *Rollup_t) __write(all bool) {
+	var summary []Msg_t
+	self.mx.Lock()
+	for k, v := range self.keys {
+		if v.count > 0 {
+			m := v.last
+			m.Info.Ts = Now()
+			m.Format = "%d more occurrences of %s in last %v"
+			m.Args = []any{v.count, fmt.Sprintf(v.last.Format, v.last.Args...), self.period}
+			summary = append(summary, m)
+			v.count = 0
+			v.last = Msg_t{}
+		} else {
+			delete(self.keys, k)
+		}
+		if all {
+			delete(self.keys, k)
+		}
+	}
+	self.mx.Unlock()
+	for _, v := range summary {
+		self.next.LogWrite(v)
+	}
+}
+
+func (self *Rollup_t) Size() (res QueueSize_t) {
+	res = self.next.Size()
+	self.mx.Lock()
+	res.QueueSampled += self.rolled
+	self.mx.Unlock()
+	return
+}
+
+func (self *Rollup_t) Flush() error {
+	return Flush(self.next)
+}
+
+func (self *Rollup_t) Reopen() error {
+	return Reopen(self.next)
+}
+
+// pending summaries are written before next is closed
+func (self *Rollup_t) Close() error {
+	if !self.stop() {
+		return nil
+	}
+	return self.next.Close()
+}
+
+func (self *Rollup_t) CloseContext(ctx context.Context) error {
+	if !self.stop() {
+		return nil
+	}
+	return CloseQueue(ctx, self.next)
+}
+
+// false if already stopped
+func (self *Rollup_t) stop() bool {
+	if self.closed.Swap(true) {
+		return false
+	}
+	close(self.done)
+	self.wg.Wait()
+	self.__write(true)
+	return true
+}
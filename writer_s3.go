@@ -0,0 +1,216 @@
+//
+// gzip objects with formatted lines, key is prefix + first_ts + "_" + last_ts + "_" + seq + ".log.gz"
+//
+
+package log
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var S3KeyFormat = "20060102T150405.000Z"
+
+// adapter for S3 client, i.e. aws-sdk-go-v2 s3.Client.PutObject
+type S3Uploader interface {
+	PutObject(ctx context.Context, bucket string, key string, body []byte) error
+}
+
+type S3_t struct {
+	ErrorHandler_t
+	mx         sync.Mutex
+	q          *Queue_t
+	client     S3Uploader
+	bucket     string
+	key_prefix string
+	message    Formatter
+	roll_size  int
+	roll_every time.Duration
+	post_ctx   PostContext
+	retry      Retry_t
+	buf        bytes.Buffer
+	gz         *gzip.Writer
+	count      int
+	raw_size   int
+	first      time.Time
+	last       time.Time
+	started    time.Time
+	seq        int
+	done       chan struct{}
+	wg         sync.WaitGroup
+}
+
+type S3Option func(self *S3_t)
+
+// override SetErrorHandler for this output
+func S3OnError(fn func(error)) S3Option {
+	return func(self *S3_t) {
+		self.on_error = fn
+	}
+}
+
+func S3Timeout(timeout time.Duration) S3Option {
+	return func(self *S3_t) {
+		self.post_ctx = &Timeout_t{timeout: timeout}
+	}
+}
+
+// failed upload is retried with backoff, then object is dropped and its messages counted as write errors
+func S3Retry(max_retries int, base time.Duration, max time.Duration) S3Option {
+	return func(self *S3_t) {
+		self.retry = Retry_t{max_retries: max_retries, base: base, max: max}
+	}
+}
+
+// object is uploaded when roll_size of uncompressed lines is reached or roll_every after its first message.
+// remaining lines are uploaded on Close
+func NewS3(queue_size int, client S3Uploader, bucket string, key_prefix string, roll_size int, roll_every time.Duration, message Formatter, opts ...S3Option) Queue {
+	self := &S3_t{
+		q:          NewQueue(queue_size),
+		client:     client,
+		bucket:     bucket,
+		key_prefix: key_prefix,
+		message:    message,
+		roll_size:  roll_size,
+		roll_every: roll_every,
+		post_ctx:   NoTimeout_t{},
+		retry:      Retry_t{max_retries: 3, base: 100 * time.Millisecond, max: 5 * time.Second},
+		done:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(self)
+	}
+	self.gz = gzip.NewWriter(&self.buf)
+
+	self.q.WgAdd(1)
+	go self.writer()
+	if self.roll_every > 0 {
+		self.wg.Add(1)
+		go self.ticker()
+	}
+	return self
+}
+
+func (self *S3_t) writer() {
+	defer self.q.WgDone()
+	var body bytes.Buffer
+	for {
+		msg, ok := self.q.LogRead(64)
+		if !ok {
+			return
+		}
+		self.mx.Lock()
+		for _, v := range msg {
+			body.Reset()
+			if _, err := self.message.FormatMessage(&body, v); err != nil {
+				self.q.WriteError(1, err.Error())
+				self.handle_error(err)
+				continue
+			}
+			if body.Len() == 0 || body.Bytes()[body.Len()-1] != '\n' {
+				body.WriteByte('\n')
+			}
+			self.__add(v.Info.Ts, body.Bytes())
+			if self.roll_size > 0 && self.raw_size >= self.roll_size {
+				self.__roll()
+			}
+		}
+		self.mx.Unlock()
+	}
+}
+
+func (self *S3_t) ticker() {
+	defer self.wg.Done()
+	t := time.NewTicker(self.roll_every / 4)
+	defer t.Stop()
+	for {
+		select {
+		case <-self.done:
+			return
+		case <-t.C:
+			self.mx.Lock()
+			if self.count > 0 && time.Since(self.started) >= self.roll_every {
+				self.__roll()
+			}
+			self.mx.Unlock()
+		}
+	}
+}
+
+func (self *S3_t) __add(ts time.Time, line []byte) {
+	if self.count == 0 {
+		self.first, self.last, self.started = ts, ts, time.Now()
+	}
+	if ts.Before(self.first) {
+		self.first = ts
+	}
+	if ts.After(self.last) {
+		self.last = ts
+	}
+	self.count++
+	self.raw_size += len(line)
+	self.gz.Write(line)
+}
+
+func (self *S3_t) __roll() {
+	if self.count == 0 {
+		return
+	}
+	self.gz.Close()
+	self.seq++
+	key := fmt.Sprintf("%s%s_%s_%d.log.gz", self.key_prefix, self.first.UTC().Format(S3KeyFormat), self.last.UTC().Format(S3KeyFormat), self.seq)
+	if err := self.upload(key, self.buf.Bytes()); err != nil {
+		self.q.WriteError(self.count, err.Error())
+		self.handle_error(err)
+	}
+	self.buf.Reset()
+	self.gz.Reset(&self.buf)
+	self.count = 0
+	self.raw_size = 0
+}
+
+func (self *S3_t) upload(key string, body []byte) (err error) {
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := self.post_ctx.WithTimeout(context.Background())
+		err = self.client.PutObject(ctx, self.bucket, key, body)
+		cancel()
+		if err == nil || attempt >= self.retry.max_retries {
+			return
+		}
+		self.q.Retry(1)
+		time.Sleep(self.retry.Backoff(attempt, err))
+	}
+}
+
+func (self *S3_t) LogWrite(m Msg_t) (int, error) {
+	return self.q.LogWrite(m)
+}
+
+func (self *S3_t) Size() (res QueueSize_t) {
+	return self.q.Size()
+}
+
+// upload lines written so far
+func (self *S3_t) Flush() (err error) {
+	self.q.Flush()
+	self.mx.Lock()
+	self.__roll()
+	self.mx.Unlock()
+	return
+}
+
+func (self *S3_t) Close() (err error) {
+	self.q.Close()
+	if self.roll_every > 0 {
+		close(self.done)
+		self.wg.Wait()
+	}
+	self.mx.Lock()
+	self.__roll()
+	self.mx.Unlock()
+	return
+}
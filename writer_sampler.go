@@ -0,0 +1,207 @@
+//
+//
+//
+
+package log
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type sample_t struct {
+	start      time.Time
+	count      int
+	suppressed int
+	last       Msg_t
+}
+
+type Sampler_t struct {
+	mx         sync.Mutex
+	next       Queue
+	key        func(m Msg_t) string
+	keys       map[string]*sample_t
+	per_key    int
+	window     time.Duration
+	last_sweep time.Time
+	sampled    int
+}
+
+type SamplerOption func(self *Sampler_t)
+
+func SamplerKey(key func(m Msg_t) string) SamplerOption {
+	return func(self *Sampler_t) {
+		self.key = key
+	}
+}
+
+// pass first per_key messages with same Format in window,
+// then "repeated N times" summary when window ends.
+// summaries are flushed by subsequent LogWrite and Close
+func NewSampler(next Queue, per_key int, window time.Duration, opts ...SamplerOption) Queue {
+	self := &Sampler_t{
+		next:    next,
+		key:     func(m Msg_t) string { return m.Format },
+		keys:    map[string]*sample_t{},
+		per_key: per_key,
+		window:  window,
+	}
+	for _, opt := range opts {
+		opt(self)
+	}
+	return self
+}
+
+func (self *Sampler_t) LogWrite(m Msg_t) (n int, err error) {
+	var summary []Msg_t
+	key := self.key(m)
+	self.mx.Lock()
+	if m.Info.Ts.Sub(self.last_sweep) >= self.window {
+		summary = self.__sweep(m.Info.Ts, summary)
+		self.last_sweep = m.Info.Ts
+	}
+	it, ok := self.keys[key]
+	if !ok {
+		it = &sample_t{start: m.Info.Ts}
+		self.keys[key] = it
+	} else if m.Info.Ts.Sub(it.start) >= self.window {
+		summary = it.summary(summary)
+		it.start = m.Info.Ts
+		it.count = 0
+	}
+	pass := it.count < self.per_key
+	if pass {
+		it.count++
+	} else {
+		it.suppressed++
+		it.last = m
+		self.sampled++
+	}
+	self.mx.Unlock()
+	for _, v := range summary {
+		self.next.LogWrite(v)
+	}
+	if pass {
+		return self.next.LogWrite(m)
+	}
+	return
+}
+
+func (self *Sampler_t) __sweep(ts time.Time, summary []Msg_t) []Msg_t {
+	for k, v := range self.keys {
+		if ts.Sub(v.start) >= self.window {
+			summary = v.summary(summary)
+			delete(self.keys, k)
+		}
+	}
+	return summary
+}
+
+func (self *sample_t) summary(in []Msg_t) []Msg_t {
+	if self.suppressed == 0 {
+		return in
+	}
+	m := self.last
+	m.Format = "message repeated %d times: " + m.Format
+	m.Args = append([]any{self.suppressed}, m.Args...)
+	self.suppressed = 0
+	self.last = Msg_t{}
+	return append(in, m)
+}
+
+func (self *Sampler_t) Size() (res QueueSize_t) {
+	res = self.next.Size()
+	self.mx.Lock()
+	res.QueueSampled += self.sampled
+	self.mx.Unlock()
+	return
+}
+
+func (self *Sampler_t) Flush() error {
+	return Flush(self.next)
+}
+
+func (self *Sampler_t) Reopen() error {
+	return Reopen(self.next)
+}
+
+func (self *Sampler_t) Close() error {
+	self.stop()
+	return self.next.Close()
+}
+
+func (self *Sampler_t) CloseContext(ctx context.Context) error {
+	self.stop()
+	return CloseQueue(ctx, self.next)
+}
+
+func (self *Sampler_t) stop() {
+	var summary []Msg_t
+	self.mx.Lock()
+	for k, v := range self.keys {
+		summary = v.summary(summary)
+		delete(self.keys, k)
+	}
+	self.mx.Unlock()
+	for _, v := range summary {
+		self.next.LogWrite(v)
+	}
+}
+
+type level_rate_t struct {
+	rate  int64
+	count atomic.Int64
+}
+
+type LevelSampler_t struct {
+	next    Queue
+	levels  map[int64]*level_rate_t
+	sampled atomic.Int64
+}
+
+// rates is level id -> N, pass 1 of every N messages of that level.
+// levels not in rates and N <= 1 pass everything
+func NewLevelSampler(next Queue, rates map[int64]int) Queue {
+	self := &LevelSampler_t{
+		next:   next,
+		levels: map[int64]*level_rate_t{},
+	}
+	for k, v := range rates {
+		if v > 1 {
+			self.levels[k] = &level_rate_t{rate: int64(v)}
+		}
+	}
+	return self
+}
+
+func (self *LevelSampler_t) LogWrite(m Msg_t) (n int, err error) {
+	if it, ok := self.levels[m.Info.LevelId]; ok && (it.count.Add(1)-1)%it.rate != 0 {
+		self.sampled.Add(1)
+		return
+	}
+	return self.next.LogWrite(m)
+}
+
+func (self *LevelSampler_t) Size() (res QueueSize_t) {
+	res = self.next.Size()
+	res.QueueSampled += int(self.sampled.Load())
+	return
+}
+
+func (self *LevelSampler_t) Flush() error {
+	return Flush(self.next)
+}
+
+func (self *LevelSampler_t) Reopen() error {
+	return Reopen(self.next)
+}
+
+func (self *LevelSampler_t) Close() error {
+	return self.next.Close()
+}
+
+func (self *LevelSampler_t) CloseContext(ctx context.Context) error {
+	return CloseQueue(ctx, self.next)
+}
@@ -0,0 +1,193 @@
+//
+//
+//
+
+package log
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+type Framing_t int
+
+const (
+	// message + "\n"
+	FramingNewline Framing_t = iota
+	// message + "\x00"
+	FramingNull
+	// 4 bytes big endian length + message
+	FramingLength
+)
+
+type WriterSocket_t struct {
+	WriterOptions_t
+	mx              sync.Mutex
+	prefix          []Formatter
+	conn            net.Conn
+	network         string
+	address         string
+	framing         Framing_t
+	backoff         Retry_t
+	log_limit       int
+	queue_write     int
+	write_error_cnt int
+	write_error_msg string
+	bulk_write      int
+}
+
+// connection is dialed by first write, failed write reconnects once.
+// error is always nil
+func NewWriterSocket(network string, address string, framing Framing_t, prefix []Formatter, log_limit int, opts ...WriterOption) (Queue, error) {
+	return newWriterSocket(network, address, framing, prefix, log_limit, opts...), nil
+}
+
+// queue buffers messages while disconnected, worker reconnects with backoff until write succeeds or queue is closed.
+// messages left when write fails after Close are counted in QueueDrop. error is always nil
+func NewWriterSocketQueue(queue_size int, writers int, network string, address string, framing Framing_t, prefix []Formatter, log_limit int, opts ...WriterOption) (Queue, error) {
+	self := newWriterSocket(network, address, framing, prefix, log_limit, opts...)
+	self.bulk_write = 16
+
+	q := NewQueue(queue_size)
+	for i := 0; i < writers; i++ {
+		q.WgAdd(1)
+		go self.writer(q)
+	}
+	return &WriterQueue_t{Queue_t: q, writer: self}, nil
+}
+
+func newWriterSocket(network string, address string, framing Framing_t, prefix []Formatter, log_limit int, opts ...WriterOption) *WriterSocket_t {
+	return &WriterSocket_t{
+		WriterOptions_t: NewWriterOptions(opts...),
+		prefix:          prefix,
+		network:         network,
+		address:         address,
+		framing:         framing,
+		backoff:         Retry_t{base: 100 * time.Millisecond, max: 10 * time.Second},
+		log_limit:       log_limit,
+	}
+}
+
+func (self *WriterSocket_t) writer(q *Queue_t) (err error) {
+	defer q.WgDone()
+	for {
+		msg, ok := q.LogRead(self.bulk_write)
+		if !ok {
+			return
+		}
+		for i := 0; i < len(msg); i++ {
+			for attempt := 0; ; attempt++ {
+				if _, err = self.write(msg[i]); err == nil {
+					break
+				}
+				if q.Closed() || SleepContext(q.Context(), self.backoff.Backoff(attempt, err)) != nil {
+					q.Drop(msg[i:])
+					self.handle_error(fmt.Errorf("socket: %w", err))
+					self.drain(q)
+					return
+				}
+				q.Retry(1)
+			}
+		}
+	}
+}
+
+// queue is closed and remote is not available
+func (self *WriterSocket_t) drain(q *Queue_t) {
+	for {
+		msg, ok := q.LogRead(self.bulk_write)
+		if !ok {
+			return
+		}
+		q.Drop(msg)
+	}
+}
+
+func (self *WriterSocket_t) LogWrite(m Msg_t) (n int, err error) {
+	self.mx.Lock()
+	self.queue_write++
+	self.mx.Unlock()
+	if n, err = self.write(m); err != nil {
+		self.mx.Lock()
+		self.write_error_cnt++
+		self.write_error_msg = err.Error()
+		self.mx.Unlock()
+		self.handle_error(fmt.Errorf("socket: %w", err))
+	}
+	return
+}
+
+func (self *WriterSocket_t) write(m Msg_t) (n int, err error) {
+	var buf bytes.Buffer
+	self.mx.Lock()
+	defer self.mx.Unlock()
+
+	if self.framing == FramingLength {
+		buf.Write([]byte{0, 0, 0, 0})
+	}
+	var w io.Writer
+	if self.log_limit > 0 {
+		w = &LimitWriter_t{Buf: &buf, Limit: self.log_limit, Ellipsis: self.ellipsis}
+	} else {
+		w = &buf
+	}
+	for _, v := range self.prefix {
+		v.FormatMessage(w, m)
+	}
+	self.message.FormatMessage(w, m)
+	switch self.framing {
+	case FramingNewline:
+		buf.WriteByte('\n')
+	case FramingNull:
+		buf.WriteByte(0)
+	case FramingLength:
+		binary.BigEndian.PutUint32(buf.Bytes(), uint32(buf.Len()-4))
+	}
+
+	return self.__write(buf.Bytes())
+}
+
+// reconnect once if remote socket dropped
+func (self *WriterSocket_t) __write(p []byte) (n int, err error) {
+	if self.conn != nil {
+		if n, err = self.conn.Write(p); err == nil {
+			return
+		}
+		self.conn.Close()
+		self.conn = nil
+	}
+	if err = self.__dial(); err != nil {
+		return
+	}
+	return self.conn.Write(p)
+}
+
+func (self *WriterSocket_t) __dial() (err error) {
+	self.conn, err = net.DialTimeout(self.network, self.address, 5*time.Second)
+	return
+}
+
+func (self *WriterSocket_t) Size() (res QueueSize_t) {
+	self.mx.Lock()
+	res.QueueWrite = self.queue_write
+	res.WriteErrorCnt = self.write_error_cnt
+	res.WriteErrorMsg = self.write_error_msg
+	self.mx.Unlock()
+	return
+}
+
+func (self *WriterSocket_t) Close() (err error) {
+	self.mx.Lock()
+	if self.conn != nil {
+		if err = self.conn.Close(); err == nil {
+			self.conn = nil
+		}
+	}
+	self.mx.Unlock()
+	return
+}
@@ -5,12 +5,12 @@
 package log
 
 import (
-	"fmt"
 	"io"
 	"sync"
 )
 
 type WriterStdany_t struct {
+	WriterOptions_t
 	mx              sync.Mutex
 	prefix          []Formatter
 	out             io.Writer
@@ -21,21 +21,23 @@ type WriterStdany_t struct {
 	bulk_write      int
 }
 
-func NewWriterStdany(prefix []Formatter, out io.Writer, log_limit int) Queue {
+func NewWriterStdany(prefix []Formatter, out io.Writer, log_limit int, opts ...WriterOption) Queue {
 	self := &WriterStdany_t{
-		prefix:    prefix,
-		out:       out,
-		log_limit: log_limit,
+		WriterOptions_t: NewWriterOptions(opts...),
+		prefix:          prefix,
+		out:             out,
+		log_limit:       log_limit,
 	}
 	return self
 }
 
-func NewWriterStdanyQueue(queue_size, writers int, prefix []Formatter, out io.Writer, log_limit int) Queue {
+func NewWriterStdanyQueue(queue_size, writers int, prefix []Formatter, out io.Writer, log_limit int, opts ...WriterOption) Queue {
 	self := &WriterStdany_t{
-		prefix:     prefix,
-		out:        out,
-		log_limit:  log_limit,
-		bulk_write: 16,
+		WriterOptions_t: NewWriterOptions(opts...),
+		prefix:          prefix,
+		out:             out,
+		log_limit:       log_limit,
+		bulk_write:      16,
 	}
 
 	q := NewQueue(queue_size)
@@ -62,26 +64,33 @@ func (self *WriterStdany_t) writer(q *Queue_t) (err error) {
 	}
 }
 
+// writers of queue are serialized by mutex, line is formatted into buffer and written by single Write,
+// so lines of outputs sharing same pipe or file are not interleaved if shorter than PIPE_BUF
 func (self *WriterStdany_t) LogWrite(m Msg_t) (n int, err error) {
+	buf := GetBuffer()
+	defer PutBuffer(buf)
 	self.mx.Lock()
 	defer self.mx.Unlock()
 	self.queue_write++
 	var w io.Writer
 	if self.log_limit > 0 {
-		w = &LimitWriter_t{Buf: self.out, Limit: self.log_limit}
+		w = &LimitWriter_t{Buf: buf, Limit: self.log_limit, Ellipsis: self.ellipsis}
 	} else {
-		w = self.out
+		w = buf
 	}
 	for _, v := range self.prefix {
 		v.FormatMessage(w, m)
 	}
-	io.WriteString(w, m.Info.LevelName)
-	io.WriteString(w, " ")
-	n, err = fmt.Fprintf(w, m.Format, m.Args...)
-	io.WriteString(self.out, "\n")
+	_, err = self.message.FormatMessage(w, m)
+	buf.WriteString("\n")
+	n, err2 := self.out.Write(buf.Bytes())
+	if err2 != nil {
+		err = err2
+	}
 	if err != nil {
 		self.write_error_cnt++
 		self.write_error_msg = err.Error()
+		self.handle_error(err)
 	}
 	return
 }
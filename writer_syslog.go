@@ -0,0 +1,218 @@
+//
+// RFC 5424
+//
+
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	SYSLOG_KERN      = 0
+	SYSLOG_USER      = 1
+	SYSLOG_DAEMON    = 3
+	SYSLOG_AUTH      = 4
+	SYSLOG_LOCAL0    = 16
+	SYSLOG_LOCAL1    = 17
+	SYSLOG_LOCAL2    = 18
+	SYSLOG_LOCAL3    = 19
+	SYSLOG_LOCAL4    = 20
+	SYSLOG_LOCAL5    = 21
+	SYSLOG_LOCAL6    = 22
+	SYSLOG_LOCAL7    = 23
+	SYSLOG_TIMESTAMP = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// custom levels above LOG_ERROR are errors
+func SyslogSeverity(level_id int64) int {
+	switch {
+	case level_id >= LOG_ERROR.LevelId:
+		return 3
+	case level_id == LOG_WARN.LevelId:
+		return 4
+	case level_id == LOG_INFO.LevelId:
+		return 6
+	default:
+		return 7
+	}
+}
+
+type WriterSyslog_t struct {
+	WriterOptions_t
+	mx              sync.Mutex
+	prefix          []Formatter
+	conn            net.Conn
+	network         string
+	address         string
+	facility        int
+	hostname        string
+	app_name        string
+	pid             string
+	log_limit       int
+	queue_write     int
+	write_error_cnt int
+	write_error_msg string
+	bulk_write      int
+}
+
+// empty network and address connects to local /dev/log
+func NewWriterSyslog(network string, address string, facility int, prefix []Formatter, log_limit int, opts ...WriterOption) (Queue, error) {
+	self := newWriterSyslog(network, address, facility, prefix, log_limit, opts...)
+	return self, self.__dial()
+}
+
+func NewWriterSyslogQueue(queue_size int, writers int, network string, address string, facility int, prefix []Formatter, log_limit int, opts ...WriterOption) (Queue, error) {
+	self := newWriterSyslog(network, address, facility, prefix, log_limit, opts...)
+	self.bulk_write = 16
+
+	err := self.__dial()
+	if err != nil {
+		return nil, err
+	}
+
+	q := NewQueue(queue_size)
+	for i := 0; i < writers; i++ {
+		q.WgAdd(1)
+		go self.writer(q)
+	}
+	return &WriterQueue_t{Queue_t: q, writer: self}, err
+}
+
+func newWriterSyslog(network string, address string, facility int, prefix []Formatter, log_limit int, opts ...WriterOption) (self *WriterSyslog_t) {
+	self = &WriterSyslog_t{
+		WriterOptions_t: NewWriterOptions(opts...),
+		prefix:          prefix,
+		network:         network,
+		address:         address,
+		facility:        facility,
+		app_name:        filepath.Base(os.Args[0]),
+		pid:             strconv.FormatInt(int64(os.Getpid()), 10),
+		log_limit:       log_limit,
+	}
+	if self.hostname, _ = os.Hostname(); len(self.hostname) == 0 {
+		self.hostname = "-"
+	}
+	return
+}
+
+func (self *WriterSyslog_t) writer(q *Queue_t) (err error) {
+	defer q.WgDone()
+	for {
+		msg, ok := q.LogRead(self.bulk_write)
+		if !ok {
+			return
+		}
+		for i := 0; i < len(msg); i++ {
+			if _, err = self.LogWrite(msg[i]); err != nil {
+				q.WriteError(1, err.Error())
+			}
+		}
+	}
+}
+
+func (self *WriterSyslog_t) LogWrite(m Msg_t) (n int, err error) {
+	var b [64]byte
+	var buf bytes.Buffer
+	self.mx.Lock()
+	defer self.mx.Unlock()
+	self.queue_write++
+
+	buf.WriteByte('<')
+	buf.Write(strconv.AppendInt(b[:0], int64(self.facility*8+SyslogSeverity(m.Info.LevelId)), 10))
+	buf.WriteString(">1 ")
+	buf.Write(m.Info.Ts.AppendFormat(b[:0], SYSLOG_TIMESTAMP))
+	buf.WriteString(" ")
+	buf.WriteString(self.hostname)
+	buf.WriteString(" ")
+	buf.WriteString(self.app_name)
+	buf.WriteString(" ")
+	buf.WriteString(self.pid)
+	buf.WriteString(" - - ")
+
+	var w io.Writer
+	if self.log_limit > 0 {
+		w = &LimitWriter_t{Buf: &buf, Limit: self.log_limit, Ellipsis: self.ellipsis}
+	} else {
+		w = &buf
+	}
+	for _, v := range self.prefix {
+		v.FormatMessage(w, m)
+	}
+	self.message.FormatMessage(w, m)
+
+	if n, err = self.__write(buf.Bytes()); err != nil {
+		self.write_error_cnt++
+		self.write_error_msg = err.Error()
+		self.handle_error(fmt.Errorf("syslog: %w: %s", err, buf.Bytes()))
+	}
+	return
+}
+
+// reconnect once if remote socket dropped
+func (self *WriterSyslog_t) __write(p []byte) (n int, err error) {
+	if self.conn != nil {
+		if n, err = self.__frame(p); err == nil {
+			return
+		}
+		self.conn.Close()
+		self.conn = nil
+	}
+	if err = self.__dial(); err != nil {
+		return
+	}
+	return self.__frame(p)
+}
+
+// RFC 6587 octet counting for stream connections
+func (self *WriterSyslog_t) __frame(p []byte) (n int, err error) {
+	switch self.network {
+	case "tcp", "tcp4", "tcp6", "unix":
+		var b [32]byte
+		if _, err = self.conn.Write(append(strconv.AppendInt(b[:0], int64(len(p)), 10), ' ')); err != nil {
+			return
+		}
+	}
+	return self.conn.Write(p)
+}
+
+func (self *WriterSyslog_t) __dial() (err error) {
+	if len(self.network) > 0 {
+		self.conn, err = net.DialTimeout(self.network, self.address, 5*time.Second)
+		return
+	}
+	for _, v := range []string{"/dev/log", "/var/run/syslog", "/var/run/log"} {
+		if self.conn, err = net.Dial("unixgram", v); err == nil {
+			return
+		}
+	}
+	return
+}
+
+func (self *WriterSyslog_t) Size() (res QueueSize_t) {
+	self.mx.Lock()
+	res.QueueWrite = self.queue_write
+	res.WriteErrorCnt = self.write_error_cnt
+	res.WriteErrorMsg = self.write_error_msg
+	self.mx.Unlock()
+	return
+}
+
+func (self *WriterSyslog_t) Close() (err error) {
+	self.mx.Lock()
+	if self.conn != nil {
+		if err = self.conn.Close(); err == nil {
+			self.conn = nil
+		}
+	}
+	self.mx.Unlock()
+	return
+}
@@ -0,0 +1,120 @@
+//
+//
+//
+
+package log
+
+import (
+	"context"
+	"io"
+)
+
+type Tee_t struct {
+	outputs []Queue
+}
+
+// LogWrite goes to every output with its own formatter, returns max n and first error.
+// for same formatted bytes in several destinations use one output over NewTeeWriter
+func NewTee(outputs ...Queue) Queue {
+	return &Tee_t{outputs: outputs}
+}
+
+func (self *Tee_t) LogWrite(m Msg_t) (n int, err error) {
+	for _, v := range self.outputs {
+		n2, err2 := v.LogWrite(m)
+		if n2 > n {
+			n = n2
+		}
+		if err == nil {
+			err = err2
+		}
+	}
+	return
+}
+
+// counters are summed, HighWater is max, CircuitState and WriteErrorMsg are first not empty
+func (self *Tee_t) Size() (res QueueSize_t) {
+	for _, v := range self.outputs {
+		size := v.Size()
+		res.Limit += size.Limit
+		res.Size += size.Size
+		if size.HighWater > res.HighWater {
+			res.HighWater = size.HighWater
+		}
+		res.Readers += size.Readers
+		res.Writers += size.Writers
+		res.QueueWrite += size.QueueWrite
+		res.QueueRead += size.QueueRead
+		res.QueueOverflow += size.QueueOverflow
+		res.QueueRetry += size.QueueRetry
+		res.QueueDrop += size.QueueDrop
+		res.QueueSampled += size.QueueSampled
+		res.CircuitDrop += size.CircuitDrop
+		res.WriteErrorCnt += size.WriteErrorCnt
+		if len(res.CircuitState) == 0 {
+			res.CircuitState = size.CircuitState
+		}
+		if len(res.WriteErrorMsg) == 0 {
+			res.WriteErrorMsg = size.WriteErrorMsg
+		}
+	}
+	return
+}
+
+func (self *Tee_t) Flush() (err error) {
+	for _, v := range self.outputs {
+		if err2 := Flush(v); err == nil {
+			err = err2
+		}
+	}
+	return
+}
+
+// reopen all outputs, returns first error
+func (self *Tee_t) Reopen() (err error) {
+	for _, v := range self.outputs {
+		if err2 := Reopen(v); err == nil {
+			err = err2
+		}
+	}
+	return
+}
+
+// close all outputs, returns first error
+func (self *Tee_t) Close() (err error) {
+	for _, v := range self.outputs {
+		if err2 := v.Close(); err == nil {
+			err = err2
+		}
+	}
+	return
+}
+
+// CloseQueue for every output with same ctx, returns first error
+func (self *Tee_t) CloseContext(ctx context.Context) (err error) {
+	for _, v := range self.outputs {
+		if err2 := CloseQueue(ctx, v); err == nil {
+			err = err2
+		}
+	}
+	return
+}
+
+type TeeWriter_t struct {
+	outputs []io.Writer
+}
+
+// bytes formatted once are written to every output, failed output does not stop others.
+// returns len(p) and first error, i.e. NewWriterStdany(prefix, NewTeeWriter(file, os.Stdout), 0)
+func NewTeeWriter(outputs ...io.Writer) io.Writer {
+	return &TeeWriter_t{outputs: outputs}
+}
+
+func (self *TeeWriter_t) Write(p []byte) (n int, err error) {
+	for _, v := range self.outputs {
+		if _, err2 := v.Write(p); err == nil {
+			err = err2
+		}
+	}
+	return len(p), err
+}